@@ -0,0 +1,192 @@
+package api
+
+import (
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"net/http"
+	"strings"
+)
+
+type serverRequest struct {
+	UserId       string                `json:"user_id"`
+	ServerConfig model.MCPClientConfig `json:"server_config"`
+	Env          map[string]string     `json:"env"`
+	Headers      map[string]string     `json:"headers"`
+}
+
+// serverResponse is the view of a registered server, its secrets are always redacted.
+type serverResponse struct {
+	UserId       string                `json:"user_id"`
+	ServerName   string                `json:"server_name"`
+	ServerConfig model.MCPClientConfig `json:"server_config"`
+	Env          map[string]string     `json:"env,omitempty"`
+	Headers      map[string]string     `json:"headers,omitempty"`
+}
+
+type secretRequest struct {
+	Value string `json:"value" binding:"required"`
+}
+
+func newServerResponse(server *model.McpServer) (*serverResponse, error) {
+	var conf model.MCPClientConfig
+	if err := sonic.Unmarshal(server.ServerConfig, &conf); err != nil {
+		return nil, err
+	}
+	var env, headers map[string]string
+	if len(server.Env) > 0 {
+		if err := sonic.Unmarshal(server.Env, &env); err != nil {
+			return nil, err
+		}
+	}
+	if len(server.Headers) > 0 {
+		if err := sonic.Unmarshal(server.Headers, &headers); err != nil {
+			return nil, err
+		}
+	}
+	return &serverResponse{
+		UserId:       server.UserId,
+		ServerName:   server.ServerName,
+		ServerConfig: conf.Redacted(),
+		Env:          model.RedactMap(env),
+		Headers:      model.RedactMap(headers),
+	}, nil
+}
+
+// checkEgress rejects the server configs pointing to destinations denied by the egress policy.
+// The urls holding ${VAR} references are only checked once interpolated, when connecting.
+func (s *Server) checkEgress(conf *model.MCPClientConfig) error {
+	urls := []string{conf.URL, conf.Proxy}
+	if conf.Pool != nil {
+		urls = append(urls, conf.Pool.Endpoints...)
+	}
+	for _, u := range urls {
+		if u == "" || strings.Contains(u, "${") {
+			continue
+		}
+		if err := s.egress.CheckURL(u); err != nil {
+			return err
+		}
+	}
+	for i := range conf.Fallbacks {
+		if err := s.checkEgress(&conf.Fallbacks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertServerHandler registers a server, or replaces the config of an existing one.
+func (s *Server) upsertServerHandler(c *gin.Context) {
+	writer, ok := s.mcpServerService.(repository.McpServerWriter)
+	if !ok {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "servers are read-only"})
+		return
+	}
+	var req serverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.checkEgress(&req.ServerConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserId == "" {
+		req.UserId = model.DefaultUserId
+	}
+	serverConfig, err := sonic.Marshal(req.ServerConfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	server := &model.McpServer{
+		UserId:       req.UserId,
+		ServerName:   c.Param("name"),
+		ServerConfig: datatypes.JSON(serverConfig),
+	}
+	if req.Env != nil {
+		env, err := sonic.Marshal(req.Env)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		server.Env = datatypes.JSON(env)
+	}
+	if req.Headers != nil {
+		headers, err := sonic.Marshal(req.Headers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		server.Headers = datatypes.JSON(headers)
+	}
+	if err := writer.UpsertMcpServer(server); err != nil {
+		s.logger.Error("Upsert mcp server failed", zap.String("mcpServerName", server.ServerName), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// the requests made from now on connect with the new config
+	s.dynamicMCPServer.Evict(server.ServerName)
+	resp, err := newServerResponse(server)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) getServerHandler(c *gin.Context) {
+	userId := c.DefaultQuery("user_id", model.DefaultUserId)
+	server, err := s.mcpServerService.GetMcpServer(userId, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := newServerResponse(server)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// upstreamStatusHandler lists the running upstream server instances with the state of their circuit breaker.
+func (s *Server) upstreamStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"upstreams": s.dynamicMCPServer.UpstreamStatus()})
+}
+
+// purgeToolCacheHandler removes the cached tool results of a server, of one of its tools with the tool query parameter,
+// or of all the servers.
+func (s *Server) purgeToolCacheHandler(c *gin.Context) {
+	purged, err := s.dynamicMCPServer.PurgeToolCache(c.Request.Context(), c.Param("name"), c.Query("tool"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// upsertSecretHandler stores a secret referenced by server configs as ${secret:NAME}.
+func (s *Server) upsertSecretHandler(c *gin.Context) {
+	writer, ok := s.secretService.(repository.SecretWriter)
+	if !ok {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "secrets are read-only"})
+		return
+	}
+	var req secretRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := c.Param("name")
+	if err := writer.UpsertSecret(name, req.Value); err != nil {
+		s.logger.Error("Upsert secret failed", zap.String("secretName", name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "value": model.RedactedValue})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the database checks of the readiness
+const readinessTimeout = 3 * time.Second
+
+// healthHandler reports the gateway warming until the pre-warming connected the critical servers.
+func (s *Server) healthHandler(c *gin.Context) {
+	if pending := s.dynamicMCPServer.Warming(); pending > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "warming", "pending": pending})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// livezHandler reports the gateway alive as long as it serves requests.
+func (s *Server) livezHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyzHandler reports whether the gateway can serve: its database reachable and at the expected schema version,
+// and its critical servers up. The reasons of the failing checks are logged, they are listed by the status endpoint.
+func (s *Server) readyzHandler(c *gin.Context) {
+	results := s.readinessChecks(c.Request.Context())
+	checks := make(map[string]string, len(results))
+	for name, err := range results {
+		checks[name] = "ok"
+		if err != nil {
+			s.logger.Warn("Readiness check failed", zap.String("check", name), zap.Error(err))
+			checks[name] = "failing"
+		}
+	}
+	if !ready(results) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
+
+// statusHandler lists the readiness checks with the reasons of the failing ones, and the running upstream
+// server instances.
+func (s *Server) statusHandler(c *gin.Context) {
+	results := s.readinessChecks(c.Request.Context())
+	checks := make(map[string]string, len(results))
+	for name, err := range results {
+		checks[name] = "ok"
+		if err != nil {
+			checks[name] = err.Error()
+		}
+	}
+	status := "ok"
+	if !ready(results) {
+		status = "unavailable"
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status, "checks": checks, "upstreams": s.dynamicMCPServer.UpstreamStatus()})
+}
+
+// readinessChecks runs the readiness checks, returning the error of each by name, nil when it passed.
+// The database is only checked when the gateway has one.
+func (s *Server) readinessChecks(ctx context.Context) map[string]error {
+	checks := make(map[string]error)
+	if checker, ok := s.mcpServerService.(repository.HealthChecker); ok {
+		ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		defer cancel()
+		checks["database"] = checker.CheckHealth(ctx)
+	}
+	checks["upstreams"] = s.dynamicMCPServer.CheckCritical()
+	return checks
+}
+
+func ready(checks map[string]error) bool {
+	for _, err := range checks {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
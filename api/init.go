@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+type initRequest struct {
+	Mode model.ServerMode `json:"mode"`
+}
+
+type initResponse struct {
+	Mode             model.ServerMode `json:"mode"`
+	AdminAccessToken string           `json:"admin_access_token,omitempty"`
+}
+
+// getServerConfig returns the server config, which is cached once the server is initialized
+// since initialization cannot be undone.
+func (s *Server) getServerConfig() (*model.ServerConfig, error) {
+	if config := s.serverConfig.Load(); config != nil {
+		return config, nil
+	}
+	config, err := s.serverConfigService.GetServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Initialized {
+		s.serverConfig.Store(config)
+	}
+	return config, nil
+}
+
+// initHandler initializes the server, it only succeeds once. It requires the bootstrap token
+// of the init-token flag, the API is disabled without one and the server is initialized with the init command.
+func (s *Server) initHandler(c *gin.Context) {
+	if s.initToken == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "init API is disabled, set init-token or use the init command"})
+		return
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.initToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid init token"})
+		return
+	}
+	var req initRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = model.ModeProd
+	}
+	config, admin, err := s.serverConfigService.Initialize(req.Mode)
+	if errors.Is(err, repository.ErrAlreadyInitialized) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		s.logger.Error("Initialize server failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.serverConfig.Store(config)
+	s.logger.Info("Server initialized", zap.String("mode", string(config.Mode)))
+
+	resp := initResponse{Mode: config.Mode}
+	if admin != nil {
+		resp.AdminAccessToken = admin.AccessToken
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -1,13 +1,38 @@
 package api
 
 import (
+	"errors"
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/tomeai/mcp-gateway/internal/auth"
+	"github.com/tomeai/mcp-gateway/internal/oauth"
+	"github.com/tomeai/mcp-gateway/model"
 	"go.uber.org/zap"
+	"gorm.io/datatypes"
 	"net/http"
 	"strings"
 )
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
+// authError is an authentication failure along with the http status to answer it with.
+type authError struct {
+	status int
+	err    error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+var (
+	errNotInitialized = &authError{http.StatusServiceUnavailable, errors.New("server is not initialized")}
+	errUnauthorized   = &authError{http.StatusUnauthorized, errors.New("unauthorized")}
+	errInvalidToken   = &authError{http.StatusUnauthorized, errors.New("invalid access token")}
+	errForbidden      = &authError{http.StatusForbidden, errors.New("forbidden")}
+	errInternal       = &authError{http.StatusInternalServerError, errors.New("internal server error")}
+)
+
 func (s *Server) chainMiddleware(h http.Handler, middlewares ...MiddlewareFunc) http.Handler {
 	ms := []MiddlewareFunc{
 		s.newAuthMiddleware(),
@@ -19,18 +44,127 @@ func (s *Server) chainMiddleware(h http.Handler, middlewares ...MiddlewareFunc)
 	return h
 }
 
+// authenticate identifies the caller of a request. Nobody is let in until the server is initialized.
+// The access token of a user or a client, or a JWT issued by the configured authorization server, identifies the caller.
+// Development mode also lets requests without a token in, as the anonymous default user, who is not an admin.
+func (s *Server) authenticate(r *http.Request) (*auth.Caller, *model.McpClient, *authError) {
+	config, err := s.getServerConfig()
+	if err != nil {
+		s.logger.Error("Get server config failed", zap.Error(err))
+		return nil, nil, errInternal
+	}
+	if !config.Initialized {
+		return nil, nil, errNotInitialized
+	}
+
+	token := r.Header.Get("Authorization")
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if token == "" {
+		if config.Mode == model.ModeDev {
+			return &auth.Caller{UserId: model.DefaultUserId, Anonymous: true}, nil, nil
+		}
+		return nil, nil, errUnauthorized
+	}
+	if s.oauthValidator != nil && oauth.LooksLikeJWT(token) {
+		claims, err := s.oauthValidator.Validate(r.Context(), token)
+		if err != nil {
+			s.logger.Info("Reject access token", zap.Error(err))
+			return nil, nil, errInvalidToken
+		}
+		// the scopes of the token act as the allow list of a client
+		allowList, err := sonic.Marshal(s.oauthValidator.AllowedServers(claims))
+		if err != nil {
+			return nil, nil, errInternal
+		}
+		client := &model.McpClient{Name: claims.Subject, AllowList: datatypes.JSON(allowList)}
+		return &auth.Caller{UserId: auth.SubjectIdOf(claims.Issuer, claims.Subject)}, client, nil
+	}
+	if user, err := s.userService.GetUserByToken(token); err == nil {
+		return &auth.Caller{UserId: auth.UserIdOf(user.Username), Admin: user.Role == model.UserRoleAdmin}, nil, nil
+	}
+	client, err := s.mcpClientService.GetClientByToken(token)
+	if err != nil {
+		return nil, nil, errUnauthorized
+	}
+	return &auth.Caller{UserId: auth.ClientIdOf(client.Name)}, client, nil
+}
+
+// newAuthMiddleware only lets through the anonymous callers of the development mode, the admins, the clients whose allow list contains the requested server
+// and the users the requested server is registered for or who bound credentials to the requested template.
 func (s *Server) newAuthMiddleware() MiddlewareFunc {
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := r.Header.Get("Authorization")
-			token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
-			if token == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			caller, client, authErr := s.authenticate(r)
+			if authErr == nil && !caller.Admin && !caller.Anonymous {
+				authErr = s.checkAcl(caller, client, r.PathValue("name"))
+			}
+			if authErr != nil {
+				s.writeAuthChallenge(w, r, authErr)
 				return
 			}
-			s.logger.Info("New Auth Token", zap.String("token", token))
+			r = r.WithContext(auth.WithCaller(r.Context(), caller))
+			if !caller.Anonymous {
+				// the gateway credentials must never reach an upstream server
+				r.Header = r.Header.Clone()
+				r.Header.Del("Authorization")
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+func (s *Server) checkAcl(caller *auth.Caller, client *model.McpClient, serverName string) *authError {
+	if client == nil {
+		// users are not bound to an allow list, they reach the servers of their own and the servers shared by all users
+		server, err := s.mcpServerService.GetMcpServer(caller.UserId, serverName)
+		if err != nil || (server.UserId != caller.UserId && server.UserId != model.DefaultUserId) {
+			s.logger.Info("User is not allowed", zap.String("userId", caller.UserId), zap.String("mcpServerName", serverName), zap.Error(err))
+			return errForbidden
+		}
+		return nil
+	}
+	allowed, err := s.aclService.IsServerAllowed(client, serverName)
+	if err != nil {
+		s.logger.Error("Check acl failed", zap.String("client", client.Name), zap.Error(err))
+		return errInternal
+	}
+	if !allowed {
+		s.logger.Info("Client is not allowed", zap.String("client", client.Name), zap.String("mcpServerName", serverName))
+		return errForbidden
+	}
+	return nil
+}
+
+// callerMiddleware lets any authenticated caller through and stores it in the request context.
+// The anonymous callers of the development mode are not let through, they have no resources of their own.
+func (s *Server) callerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _, authErr := s.authenticate(c.Request)
+		if authErr == nil && caller.Anonymous {
+			authErr = errUnauthorized
+		}
+		if authErr != nil {
+			c.AbortWithStatusJSON(authErr.status, gin.H{"error": authErr.Error()})
+			return
+		}
+		c.Request = c.Request.WithContext(auth.WithCaller(c.Request.Context(), caller))
+		c.Next()
+	}
+}
+
+// adminMiddleware only lets admins through, in development mode too.
+func (s *Server) adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _, authErr := s.authenticate(c.Request)
+		if authErr == nil && !caller.Admin {
+			authErr = errForbidden
+		}
+		if authErr != nil {
+			c.AbortWithStatusJSON(authErr.status, gin.H{"error": authErr.Error()})
+			return
+		}
+		c.Request = c.Request.WithContext(auth.WithCaller(c.Request.Context(), caller))
+		c.Next()
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+const protectedResourceMetadataPath = "/.well-known/oauth-protected-resource"
+
+// protectedResourceMetadata is the OAuth 2.0 protected resource metadata (RFC 9728) of the gateway.
+type protectedResourceMetadata struct {
+	Resource               string   `json:"resource"`
+	AuthorizationServers   []string `json:"authorization_servers"`
+	BearerMethodsSupported []string `json:"bearer_methods_supported"`
+	ScopesSupported        []string `json:"scopes_supported,omitempty"`
+}
+
+// resourceBase is the public URL of the gateway, the configured resource or else the origin of the request.
+// The forwarded headers are only trusted behind a reverse proxy, any client may set them otherwise.
+func (s *Server) resourceBase(r *http.Request) string {
+	if s.resourceURL != "" {
+		return strings.TrimSuffix(s.resourceURL, "/")
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if s.trustForwardedHeaders {
+		// the first proxy of a chain is the closest to the client
+		if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); proto != "" {
+			scheme = strings.TrimSpace(proto)
+		}
+		if forwardedHost, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ","); forwardedHost != "" {
+			host = strings.TrimSpace(forwardedHost)
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+// protectedResourceHandler serves the metadata of the gateway, or of the /mcp/{name} endpoint of a server.
+func (s *Server) protectedResourceHandler(c *gin.Context) {
+	base := s.resourceBase(c.Request)
+	metadata := protectedResourceMetadata{
+		Resource:               base,
+		AuthorizationServers:   []string{s.oauthValidator.Config().Issuer},
+		BearerMethodsSupported: []string{"header"},
+	}
+	if name := c.Param("name"); name != "" {
+		metadata.Resource = base + "/mcp/" + name
+		metadata.ScopesSupported = s.oauthValidator.ScopesSupported(name)
+	}
+	c.JSON(http.StatusOK, metadata)
+}
+
+// writeAuthChallenge points the client to the protected resource metadata of the requested server
+// so that it can discover the authorization server (RFC 9728 section 5.1).
+func (s *Server) writeAuthChallenge(w http.ResponseWriter, r *http.Request, authErr *authError) {
+	if s.oauthValidator != nil && (authErr.status == http.StatusUnauthorized || authErr.status == http.StatusForbidden) {
+		name := r.PathValue("name")
+		params := []string{fmt.Sprintf(`resource_metadata="%s%s/mcp/%s"`, s.resourceBase(r), protectedResourceMetadataPath, name)}
+		switch authErr {
+		case errInvalidToken:
+			params = append(params, `error="invalid_token"`)
+		case errForbidden:
+			params = append(params, `error="insufficient_scope"`, fmt.Sprintf(`scope="%s"`, strings.Join(s.oauthValidator.ScopesSupported(name), " ")))
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	}
+	http.Error(w, authErr.Error(), authErr.status)
+}
@@ -5,20 +5,38 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/oauth"
 	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
 	"github.com/tomeai/mcp-gateway/repository"
 	"github.com/tomeai/mcp-gateway/service"
 	"github.com/urfave/cli/v2"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 	"net/http"
+	"sync/atomic"
 )
 
 type Server struct {
 	ctx *cli.Context
 	*http.Server
 
-	mcpClientService *repository.McpClientService
+	mcpServerService    repository.McpServerRepository
+	mcpClientService    repository.McpClientRepository
+	aclService          repository.AclRepository
+	userService         repository.UserRepository
+	serverConfigService repository.ServerConfigRepository
+	secretService       repository.SecretRepository
+	oauthValidator      *oauth.Validator
+	egress              *egress.Policy
+	serverConfig        atomic.Pointer[model.ServerConfig]
+	// initToken is the bootstrap token required by the init API, disabled when empty
+	initToken string
+	// resourceURL is the public URL of the gateway, the origin of the requests when empty
+	resourceURL string
+	// trustForwardedHeaders takes the origin of the requests from the X-Forwarded-Proto and X-Forwarded-Host headers
+	trustForwardedHeaders bool
 
 	dynamicMCPServer *service.DynamicMCPServer
 
@@ -37,16 +55,24 @@ func NewOtel(ctx *cli.Context) (*telemetry.Providers, error) {
 	return otelProviders, err
 }
 
-func NewServer(ctx *cli.Context, dynamicMCPServer *service.DynamicMCPServer, otelProviders *telemetry.Providers, mcpClientService *repository.McpClientService, logger *zap.Logger) (*Server, error) {
-	mcpMetrics := telemetry.NewNoopCustomMetrics()
-
+func NewServer(ctx *cli.Context, dynamicMCPServer *service.DynamicMCPServer, otelProviders *telemetry.Providers, mcpServerService repository.McpServerRepository, mcpClientService repository.McpClientRepository, aclService repository.AclRepository, userService repository.UserRepository, serverConfigService repository.ServerConfigRepository, secretService repository.SecretRepository, oauthValidator *oauth.Validator, egress *egress.Policy, mcpMetrics telemetry.CustomMetrics, logger *zap.Logger) (*Server, error) {
 	s := &Server{
-		mcpClientService: mcpClientService,
-		dynamicMCPServer: dynamicMCPServer,
-		otelProviders:    otelProviders,
-		metrics:          mcpMetrics,
-		logger:           logger,
-		ctx:              ctx,
+		mcpServerService:      mcpServerService,
+		mcpClientService:      mcpClientService,
+		aclService:            aclService,
+		userService:           userService,
+		serverConfigService:   serverConfigService,
+		secretService:         secretService,
+		oauthValidator:        oauthValidator,
+		egress:                egress,
+		initToken:             ctx.String("init-token"),
+		resourceURL:           ctx.String("oauth-resource"),
+		trustForwardedHeaders: ctx.Bool("trust-forwarded-headers"),
+		dynamicMCPServer:      dynamicMCPServer,
+		otelProviders:         otelProviders,
+		metrics:               mcpMetrics,
+		logger:                logger,
+		ctx:                   ctx,
 	}
 
 	// Set up the router after the server is fully initialized
@@ -75,12 +101,32 @@ func (s *Server) setupRouter() (*http.ServeMux, error) {
 		r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	}
 
-	r.GET(
-		"/health",
-		func(c *gin.Context) {
-			c.JSON(200, gin.H{"status": "ok"})
-		},
-	)
+	r.GET("/health", s.healthHandler)
+	r.GET("/livez", s.livezHandler)
+	r.GET("/readyz", s.readyzHandler)
+	r.GET("/status", s.adminMiddleware(), s.statusHandler)
+
+	if s.oauthValidator != nil {
+		r.GET(protectedResourceMetadataPath, s.protectedResourceHandler)
+		r.GET(protectedResourceMetadataPath+"/mcp/:name", s.protectedResourceHandler)
+	}
+
+	v0 := r.Group("/api/v0")
+	v0.POST("/init", s.initHandler)
+
+	admin := v0.Group("", s.adminMiddleware())
+	admin.PUT("/servers/:name", s.upsertServerHandler)
+	admin.GET("/servers/:name", s.getServerHandler)
+	admin.GET("/upstreams", s.upstreamStatusHandler)
+	admin.DELETE("/cache", s.purgeToolCacheHandler)
+	admin.DELETE("/servers/:name/cache", s.purgeToolCacheHandler)
+	admin.PUT("/secrets/:name", s.upsertSecretHandler)
+	admin.PUT("/templates/:name", s.upsertTemplateHandler)
+	admin.GET("/templates/:name", s.getTemplateHandler)
+
+	v0.PUT("/templates/:name/credentials", s.callerMiddleware(), s.bindCredentialsHandler)
+	v0.GET("/servers/:name/oauth/authorize", s.callerMiddleware(), s.authorizeUpstreamHandler)
+	r.GET(upstreamOAuthCallbackPath, s.upstreamOAuthCallbackHandler)
 
 	httpMux := http.NewServeMux()
 
@@ -0,0 +1,617 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/tomeai/mcp-gateway/internal/db"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"github.com/tomeai/mcp-gateway/service"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testInitToken = "init-token"
+
+// testGateway is a gateway backed by an in-memory sqlite database.
+type testGateway struct {
+	*httptest.Server
+	db *gorm.DB
+	// clients of the gateway, the servers are registered in the database
+	clients          *repository.MemoryStore
+	dynamicMCPServer *service.DynamicMCPServer
+}
+
+func newTestGateway(t *testing.T) *testGateway {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if _, err := db.NewMigrator(conn).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := egress.NewPolicyFromConfig(egress.Config{AllowCIDRs: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memStore := repository.NewMemoryStore()
+	mcpServerService := repository.NewMcpServerService(conn, nil)
+	metrics := telemetry.NewNoopCustomMetrics()
+	dynamicMCPServer := service.NewDynamicMCPServer(mcpServerService, nil, memStore, memStore, policy, nil, metrics, nil, nil, zap.NewNop())
+	t.Cleanup(func() { _ = dynamicMCPServer.Close() })
+
+	s := &Server{
+		mcpServerService:    mcpServerService,
+		mcpClientService:    memStore,
+		aclService:          memStore,
+		userService:         repository.NewUserService(conn),
+		serverConfigService: repository.NewServerConfigService(conn),
+		egress:              policy,
+		initToken:           testInitToken,
+		dynamicMCPServer:    dynamicMCPServer,
+		otelProviders:       &telemetry.Providers{Config: &telemetry.Config{}},
+		metrics:             metrics,
+		logger:              zap.NewNop(),
+	}
+	r, err := s.setupRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &testGateway{Server: httptest.NewServer(r), db: conn, clients: memStore, dynamicMCPServer: dynamicMCPServer}
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *testGateway) do(t *testing.T, method, path, token string, body any) *http.Response {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = sonic.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, g.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func (g *testGateway) expect(t *testing.T, method, path, token string, body any, status int) *http.Response {
+	t.Helper()
+	resp := g.do(t, method, path, token, body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s status = %d, want %d", method, path, resp.StatusCode, status)
+	}
+	return resp
+}
+
+// init initializes the gateway in the given mode and returns the admin access token.
+func (g *testGateway) init(t *testing.T, mode model.ServerMode) string {
+	t.Helper()
+	resp := g.expect(t, http.MethodPost, "/api/v0/init", testInitToken, initRequest{Mode: mode}, http.StatusOK)
+	var init initResponse
+	if err := sonic.ConfigDefault.NewDecoder(resp.Body).Decode(&init); err != nil {
+		t.Fatal(err)
+	}
+	if init.AdminAccessToken == "" {
+		t.Fatal("init returned no admin access token")
+	}
+	return init.AdminAccessToken
+}
+
+func (g *testGateway) createUser(t *testing.T, name string) string {
+	t.Helper()
+	token := name + "-token"
+	if err := g.db.Create(&model.User{Username: name, Role: model.UserRoleUser, AccessToken: token}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (g *testGateway) createClient(t *testing.T, name string, allowList ...string) string {
+	t.Helper()
+	token := "client-" + name + "-token"
+	data, err := sonic.Marshal(allowList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.clients.CreateClient(&model.McpClient{Name: name, AccessToken: token, AllowList: data}); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// newTestUpstream starts an mcp server whose whoami tool answers with its name and the X-Token
+// and Authorization headers it received.
+func newTestUpstream(t *testing.T, name string) string {
+	t.Helper()
+	mcpServer := server.NewMCPServer(name, "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("whoami"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(name + ":" + request.Header.Get("X-Token") + ":" + request.Header.Get("Authorization")), nil
+	})
+	upstream := httptest.NewServer(server.NewStreamableHTTPServer(mcpServer))
+	t.Cleanup(func() {
+		// the gateway keeps its connections to the upstream server open
+		upstream.CloseClientConnections()
+		upstream.Close()
+	})
+	return upstream.URL
+}
+
+// newGatedTestUpstream starts an mcp server like newTestUpstream holding every request until release is closed.
+func newGatedTestUpstream(t *testing.T, name string, release <-chan struct{}) string {
+	t.Helper()
+	mcpServer := server.NewMCPServer(name, "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("whoami"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(name), nil
+	})
+	httpServer := server.NewStreamableHTTPServer(mcpServer)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			httpServer.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		upstream.CloseClientConnections()
+		upstream.Close()
+	})
+	return upstream.URL
+}
+
+// callWhoami calls the whoami tool of a server through the gateway.
+func (g *testGateway) callWhoami(t *testing.T, serverName, token string) (string, error) {
+	t.Helper()
+	return g.callWhoamiWithHeaders(t, serverName, token, nil)
+}
+
+// callWhoamiWithHeaders calls the whoami tool of a server through the gateway, sending the headers along.
+func (g *testGateway) callWhoamiWithHeaders(t *testing.T, serverName, token string, headers map[string]string) (string, error) {
+	t.Helper()
+	headers = maps.Clone(headers)
+	if token != "" {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers["Authorization"] = "Bearer " + token
+	}
+	var options []transport.StreamableHTTPCOption
+	if len(headers) > 0 {
+		options = append(options, transport.WithHTTPHeaders(headers))
+	}
+	c, err := client.NewStreamableHttpClient(g.URL+"/mcp/"+serverName, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	if err := c.Start(ctx); err != nil {
+		return "", err
+	}
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	if _, err := c.Initialize(ctx, initRequest); err != nil {
+		return "", err
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "whoami"
+	result, err := c.CallTool(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	return result.Content[0].(mcp.TextContent).Text, nil
+}
+
+func TestInit(t *testing.T) {
+	g := newTestGateway(t)
+	// nothing is served before the gateway is initialized
+	g.expect(t, http.MethodGet, "/api/v0/upstreams", "", nil, http.StatusServiceUnavailable)
+	g.expect(t, http.MethodPost, "/mcp/echo", "", nil, http.StatusServiceUnavailable)
+
+	g.expect(t, http.MethodPost, "/api/v0/init", "", initRequest{}, http.StatusUnauthorized)
+	g.expect(t, http.MethodPost, "/api/v0/init", "wrong", initRequest{}, http.StatusUnauthorized)
+	admin := g.init(t, model.ModeProd)
+	g.expect(t, http.MethodPost, "/api/v0/init", testInitToken, initRequest{}, http.StatusConflict)
+
+	g.expect(t, http.MethodGet, "/api/v0/upstreams", admin, nil, http.StatusOK)
+	g.expect(t, http.MethodGet, "/api/v0/upstreams", "", nil, http.StatusUnauthorized)
+	g.expect(t, http.MethodGet, "/api/v0/upstreams", g.createUser(t, "alice"), nil, http.StatusForbidden)
+}
+
+func TestInitDisabledWithoutToken(t *testing.T) {
+	s := &Server{logger: zap.NewNop()}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v0/init", nil)
+	c.Request.Header.Set("Authorization", "Bearer ")
+	s.initHandler(c)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("init without an init token status = %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+}
+
+func TestServerAccess(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	alice, bob := g.createUser(t, "alice"), g.createUser(t, "bob")
+	upstream := newTestUpstream(t, "upstream")
+
+	g.expect(t, http.MethodPut, "/api/v0/servers/private", admin, serverRequest{
+		UserId:       "user:alice",
+		ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream},
+	}, http.StatusOK)
+	g.expect(t, http.MethodPut, "/api/v0/servers/shared", admin, serverRequest{
+		ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream},
+	}, http.StatusOK)
+	// only admins register servers
+	g.expect(t, http.MethodPut, "/api/v0/servers/private", alice, serverRequest{
+		UserId:       "user:alice",
+		ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream},
+	}, http.StatusForbidden)
+
+	ci := g.createClient(t, "ci", "shared")
+	// a client named like a user does not get the servers of the user
+	impostor := g.createClient(t, "alice")
+
+	tests := []struct {
+		name   string
+		server string
+		token  string
+		status int
+	}{
+		{"owner", "private", alice, http.StatusOK},
+		{"other user", "private", bob, http.StatusForbidden},
+		{"admin", "shared", admin, http.StatusOK},
+		{"user on a shared server", "shared", bob, http.StatusOK},
+		{"client allowed", "shared", ci, http.StatusOK},
+		{"client not allowed", "private", ci, http.StatusForbidden},
+		{"client named like the owner", "private", impostor, http.StatusForbidden},
+		{"no token", "private", "", http.StatusUnauthorized},
+		{"unknown token", "private", "unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.status != http.StatusOK {
+				g.expect(t, http.MethodPost, "/mcp/"+tt.server, tt.token, nil, tt.status)
+				return
+			}
+			got, err := g.callWhoami(t, tt.server, tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the gateway credentials never reach the upstream server
+			if got != "upstream::" {
+				t.Errorf("whoami = %q, want upstream::", got)
+			}
+		})
+	}
+
+	// a server of the user shadows the shared server of the same name
+	g.expect(t, http.MethodPut, "/api/v0/servers/shared", admin, serverRequest{
+		UserId:       "user:alice",
+		ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: newTestUpstream(t, "own")},
+	}, http.StatusOK)
+	for token, want := range map[string]string{alice: "own::", bob: "upstream::"} {
+		got, err := g.callWhoami(t, "shared", token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("whoami = %q, want %s", got, want)
+		}
+	}
+}
+
+func TestUpsertServerEvictsProxy(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	for _, name := range []string{"primary", "replacement"} {
+		g.expect(t, http.MethodPut, "/api/v0/servers/shared", admin, serverRequest{
+			ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: newTestUpstream(t, name)},
+		}, http.StatusOK)
+		got, err := g.callWhoami(t, "shared", admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != name+"::" {
+			t.Errorf("whoami = %q, want %s::", got, name)
+		}
+	}
+
+	// the proxy of the replaced config is closed
+	var status struct {
+		Upstreams []service.UpstreamStatus `json:"upstreams"`
+	}
+	resp := g.expect(t, http.MethodGet, "/status", admin, nil, http.StatusOK)
+	if err := sonic.ConfigDefault.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Upstreams) != 1 {
+		t.Errorf("%d upstreams running, want 1", len(status.Upstreams))
+	}
+}
+
+func TestUpsertServerChecksEgress(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	// only the loopback address is allowed to the upstream servers of the test gateway
+	for _, url := range []string{"http://10.0.0.1/mcp", "http://169.254.169.254/mcp"} {
+		g.expect(t, http.MethodPut, "/api/v0/servers/private", admin, serverRequest{
+			ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: url},
+		}, http.StatusBadRequest)
+	}
+	g.expect(t, http.MethodPut, "/api/v0/servers/private", admin, serverRequest{
+		ServerConfig: model.MCPClientConfig{
+			TransportType: model.MCPClientTypeStreamable,
+			URL:           newTestUpstream(t, "upstream"),
+			Fallbacks:     []model.MCPClientConfig{{TransportType: model.MCPClientTypeStreamable, URL: "http://10.0.0.1/mcp"}},
+		},
+	}, http.StatusBadRequest)
+}
+
+func TestForwardHeaders(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	upstream := newTestUpstream(t, "upstream")
+	headers := map[string]string{"X-User-Token": "abc", "X-Token": "spoofed", "Cookie": "session=1"}
+
+	tests := []struct {
+		name  string
+		rules []model.ForwardHeader
+		want  string
+	}{
+		{"not forwarded", nil, "upstream::"},
+		{"allowed", []model.ForwardHeader{{Name: "X-Token"}}, "upstream:spoofed:"},
+		{"renamed", []model.ForwardHeader{{Name: "X-User-Token", As: "X-Token"}}, "upstream:abc:"},
+		{"template", []model.ForwardHeader{{Name: "X-User-Token", As: "X-Token", Template: "token ${value}"}}, "upstream:token abc:"},
+		{"missing", []model.ForwardHeader{{Name: "X-Missing", As: "X-Token"}}, "upstream::"},
+		// the gateway credentials are removed from the request before they could be forwarded
+		{"gateway credentials", []model.ForwardHeader{{Name: "Authorization"}}, "upstream::"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.expect(t, http.MethodPut, "/api/v0/servers/forwarding", admin, serverRequest{
+				ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream, ForwardHeaders: tt.rules},
+			}, http.StatusOK)
+			got, err := g.callWhoamiWithHeaders(t, "forwarding", admin, headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("whoami = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateAccess(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	alice, bob := g.createUser(t, "alice"), g.createUser(t, "bob")
+	upstream := newTestUpstream(t, "upstream")
+
+	g.expect(t, http.MethodPut, "/api/v0/templates/github", admin, templateRequest{
+		ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream},
+	}, http.StatusOK)
+	g.expect(t, http.MethodPut, "/api/v0/templates/github/credentials", alice, credentialRequest{
+		Headers: map[string]string{"X-Token": "alice-github"},
+	}, http.StatusOK)
+	// users only bind their own credentials
+	g.expect(t, http.MethodPut, "/api/v0/templates/github/credentials", alice, credentialRequest{
+		UserId:  "user:bob",
+		Headers: map[string]string{"X-Token": "alice-github"},
+	}, http.StatusForbidden)
+	g.expect(t, http.MethodPut, "/api/v0/templates/github/credentials", "", credentialRequest{}, http.StatusUnauthorized)
+
+	got, err := g.callWhoami(t, "github", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "upstream:alice-github:" {
+		t.Errorf("whoami = %q, want the credentials of alice", got)
+	}
+	g.expect(t, http.MethodPost, "/mcp/github", bob, nil, http.StatusForbidden)
+}
+
+func TestDevMode(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeDev)
+	upstream := newTestUpstream(t, "upstream")
+	g.expect(t, http.MethodPut, "/api/v0/servers/shared", admin, serverRequest{
+		ServerConfig: model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream},
+	}, http.StatusOK)
+
+	// the anonymous callers reach the shared servers only
+	if _, err := g.callWhoami(t, "shared", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/api/v0/upstreams", "/status", "/api/v0/servers/shared"} {
+		g.expect(t, http.MethodGet, path, "", nil, http.StatusForbidden)
+	}
+	g.expect(t, http.MethodPut, "/api/v0/templates/github/credentials", "", credentialRequest{}, http.StatusUnauthorized)
+	g.expect(t, http.MethodGet, "/status", admin, nil, http.StatusOK)
+}
+
+func TestStatus(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	primary, replica := newTestUpstream(t, "upstream"), newTestUpstream(t, "upstream")
+	g.expect(t, http.MethodPut, "/api/v0/servers/shared", admin, serverRequest{
+		ServerConfig: model.MCPClientConfig{
+			TransportType: model.MCPClientTypeStreamable,
+			URL:           primary,
+			Pool:          &model.UpstreamPool{Endpoints: []string{replica}, HealthCheckSeconds: 1},
+		},
+	}, http.StatusOK)
+	if _, err := g.callWhoami(t, "shared", admin); err != nil {
+		t.Fatal(err)
+	}
+
+	var status struct {
+		Status    string                   `json:"status"`
+		Checks    map[string]string        `json:"checks"`
+		Upstreams []service.UpstreamStatus `json:"upstreams"`
+	}
+	// the replicas are pinged every second
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp := g.expect(t, http.MethodGet, "/status", admin, nil, http.StatusOK)
+		if err := sonic.ConfigDefault.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if len(status.Upstreams) == 1 && len(status.Upstreams[0].Members) == 2 &&
+			status.Upstreams[0].Members[0].LastPing != nil && status.Upstreams[0].Members[1].LastPing != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %+v, want the pinged replicas of the upstream", status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if status.Status != "ok" || status.Checks["upstreams"] != "ok" {
+		t.Errorf("status %s, checks %v", status.Status, status.Checks)
+	}
+	upstream := status.Upstreams[0]
+	if upstream.ServerName != "shared" || upstream.UserId != model.DefaultUserId || upstream.State != service.UpstreamUp {
+		t.Errorf("upstream %s of %s is %s", upstream.ServerName, upstream.UserId, upstream.State)
+	}
+	if upstream.Tools != 1 || upstream.ConnectedAt == nil || upstream.UptimeSeconds < 0 {
+		t.Errorf("upstream has %d tools, connected at %v, uptime %ds", upstream.Tools, upstream.ConnectedAt, upstream.UptimeSeconds)
+	}
+	for _, member := range upstream.Members {
+		if !member.Connected || member.Transport != model.MCPClientTypeStreamable || member.PingFailures != 0 ||
+			member.Circuit.State != telemetry.CircuitClosed || member.Circuit.ConsecutiveFailures != 0 {
+			t.Errorf("member = %+v", member)
+		}
+	}
+
+	// only admins see the upstreams
+	g.expect(t, http.MethodGet, "/status", g.createUser(t, "alice"), nil, http.StatusForbidden)
+}
+
+func TestResourceBase(t *testing.T) {
+	forwarded := http.Header{"X-Forwarded-Proto": {"https, http"}, "X-Forwarded-Host": {"gateway.example.com"}}
+	tests := []struct {
+		name   string
+		server *Server
+		header http.Header
+		want   string
+	}{
+		{"request origin", &Server{}, nil, "http://10.0.0.5:8000"},
+		{"untrusted forwarded headers", &Server{}, forwarded, "http://10.0.0.5:8000"},
+		{"trusted forwarded headers", &Server{trustForwardedHeaders: true}, forwarded, "https://gateway.example.com"},
+		{"configured resource", &Server{resourceURL: "https://mcp.example.com/", trustForwardedHeaders: true}, forwarded, "https://mcp.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://10.0.0.5:8000/api/v0/servers/linear/oauth/authorize", nil)
+			maps.Copy(r.Header, tt.header)
+			if got := tt.server.resourceBase(r); got != tt.want {
+				t.Errorf("resourceBase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	g := newTestGateway(t)
+	admin := g.init(t, model.ModeProd)
+	release := make(chan struct{})
+	servers := map[string]model.MCPClientConfig{
+		"critical": {TransportType: model.MCPClientTypeStreamable, URL: newGatedTestUpstream(t, "critical", release), Critical: true},
+		"tagged":   {TransportType: model.MCPClientTypeStreamable, URL: newTestUpstream(t, "tagged"), Tags: []string{"warm"}},
+		"untagged": {TransportType: model.MCPClientTypeStreamable, URL: newTestUpstream(t, "untagged")},
+	}
+	for name, conf := range servers {
+		g.expect(t, http.MethodPut, "/api/v0/servers/"+name, admin, serverRequest{ServerConfig: conf}, http.StatusOK)
+	}
+	if err := g.dynamicMCPServer.Prewarm(context.Background(), []string{"warm"}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var health struct {
+		Status  string `json:"status"`
+		Pending int    `json:"pending"`
+	}
+	resp := g.expect(t, http.MethodGet, "/health", "", nil, http.StatusServiceUnavailable)
+	if err := sonic.ConfigDefault.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "warming" || health.Pending != 1 {
+		t.Errorf("health = %+v, want 1 critical server warming", health)
+	}
+	g.expect(t, http.MethodGet, "/readyz", "", nil, http.StatusServiceUnavailable)
+
+	close(release)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		resp := g.do(t, http.MethodGet, "/health", "", nil)
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health status %d, want the critical server connected", resp.StatusCode)
+		}
+	}
+	g.expect(t, http.MethodGet, "/readyz", "", nil, http.StatusOK)
+
+	// the servers not tagged are left to their first request
+	var status struct {
+		Upstreams []service.UpstreamStatus `json:"upstreams"`
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		resp := g.expect(t, http.MethodGet, "/status", admin, nil, http.StatusOK)
+		if err := sonic.ConfigDefault.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, upstream := range status.Upstreams {
+			if upstream.State == service.UpstreamUp {
+				names = append(names, upstream.ServerName)
+			}
+		}
+		slices.Sort(names)
+		if slices.Equal(names, []string{"critical", "tagged"}) && len(status.Upstreams) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upstreams = %+v, want the critical and tagged servers up", status.Upstreams)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/tomeai/mcp-gateway/internal/auth"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"net/http"
+)
+
+type templateRequest struct {
+	Description  string                `json:"description"`
+	ServerConfig model.MCPClientConfig `json:"server_config"`
+}
+
+type templateResponse struct {
+	Name         string                `json:"name"`
+	Description  string                `json:"description"`
+	ServerConfig model.MCPClientConfig `json:"server_config"`
+}
+
+type credentialRequest struct {
+	// UserId may only be set by admins binding credentials on behalf of a user
+	UserId  string            `json:"user_id"`
+	Env     map[string]string `json:"env"`
+	Headers map[string]string `json:"headers"`
+}
+
+func newTemplateResponse(template *model.ServerTemplate) (*templateResponse, error) {
+	var conf model.MCPClientConfig
+	if err := sonic.Unmarshal(template.ServerConfig, &conf); err != nil {
+		return nil, err
+	}
+	return &templateResponse{
+		Name:         template.Name,
+		Description:  template.Description,
+		ServerConfig: conf.Redacted(),
+	}, nil
+}
+
+func (s *Server) templateRepository(c *gin.Context) (repository.ServerTemplateRepository, bool) {
+	templates, ok := s.mcpServerService.(repository.ServerTemplateRepository)
+	if !ok {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "server templates are not supported"})
+	}
+	return templates, ok
+}
+
+func (s *Server) upsertTemplateHandler(c *gin.Context) {
+	templates, ok := s.templateRepository(c)
+	if !ok {
+		return
+	}
+	var req templateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.checkEgress(&req.ServerConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	serverConfig, err := sonic.Marshal(req.ServerConfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	template := &model.ServerTemplate{
+		Name:         c.Param("name"),
+		Description:  req.Description,
+		ServerConfig: datatypes.JSON(serverConfig),
+	}
+	if err := templates.UpsertServerTemplate(template); err != nil {
+		s.logger.Error("Upsert server template failed", zap.String("templateName", template.Name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := newTemplateResponse(template)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) getTemplateHandler(c *gin.Context) {
+	templates, ok := s.templateRepository(c)
+	if !ok {
+		return
+	}
+	template, err := templates.GetServerTemplate(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := newTemplateResponse(template)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// bindCredentialsHandler binds the credentials of the caller to a template.
+// The template is then reachable at /mcp/{name} with an upstream connection of the caller's own.
+func (s *Server) bindCredentialsHandler(c *gin.Context) {
+	templates, ok := s.templateRepository(c)
+	if !ok {
+		return
+	}
+	var req credentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	caller := auth.CallerFromContext(c.Request.Context())
+	userId := caller.UserId
+	if req.UserId != "" && req.UserId != userId {
+		if !caller.Admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only admins may bind credentials for other users"})
+			return
+		}
+		userId = req.UserId
+	}
+	binding := &model.CredentialBinding{
+		TemplateName: c.Param("name"),
+		UserId:       userId,
+	}
+	if req.Env != nil {
+		env, err := sonic.Marshal(req.Env)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		binding.Env = datatypes.JSON(env)
+	}
+	if req.Headers != nil {
+		headers, err := sonic.Marshal(req.Headers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		binding.Headers = datatypes.JSON(headers)
+	}
+	if err := templates.UpsertCredentialBinding(binding); err != nil {
+		s.logger.Error("Bind credentials failed", zap.String("templateName", binding.TemplateName), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"template_name": binding.TemplateName,
+		"user_id":       binding.UserId,
+		"env":           model.RedactMap(req.Env),
+		"headers":       model.RedactMap(req.Headers),
+	})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tomeai/mcp-gateway/internal/auth"
+	"github.com/tomeai/mcp-gateway/service"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const upstreamOAuthCallbackPath = "/api/v0/oauth/callback"
+
+// authorizeUpstreamHandler starts the authorization of the gateway with an upstream server configured for oauth.
+// Admins authorize the shared servers and may act for other users, users authorize their own servers.
+// It redirects to the authorization server with ?redirect=true, and returns its URL otherwise.
+func (s *Server) authorizeUpstreamHandler(c *gin.Context) {
+	caller := auth.CallerFromContext(c.Request.Context())
+	name := c.Param("name")
+	userId := caller.UserId
+	if requested := c.Query("user_id"); requested != "" && requested != userId {
+		if !caller.Admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only admins may authorize servers for other users"})
+			return
+		}
+		userId = requested
+	}
+	if !caller.Admin {
+		server, err := s.mcpServerService.GetMcpServer(userId, name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if server.UserId != userId {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only admins may authorize shared servers"})
+			return
+		}
+	}
+	authURL, err := s.dynamicMCPServer.AuthorizeUpstream(c.Request.Context(), userId, name, s.resourceBase(c.Request)+upstreamOAuthCallbackPath)
+	if err != nil {
+		s.logger.Warn("Authorize mcp server failed", zap.String("mcpServerName", name), zap.String("userId", userId), zap.Error(err))
+		status := http.StatusBadGateway
+		if errors.Is(err, service.ErrOAuthNotConfigured) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("redirect") == "true" {
+		c.Redirect(http.StatusFound, authURL)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// upstreamOAuthCallbackHandler receives the redirect of the authorization server.
+// It is not authenticated, the state issued by authorizeUpstreamHandler binds it to the authorization.
+func (s *Server) upstreamOAuthCallbackHandler(c *gin.Context) {
+	if oauthErr := c.Query("error"); oauthErr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": oauthErr, "error_description": c.Query("error_description")})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+		return
+	}
+	name, err := s.dynamicMCPServer.CompleteUpstreamAuthorization(c.Request.Context(), state, code)
+	if err != nil {
+		s.logger.Warn("Complete mcp server authorization failed", zap.Error(err))
+		status := http.StatusBadGateway
+		if errors.Is(err, service.ErrUnknownAuthorization) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"server_name": name, "status": "authorized"})
+}
@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/tomeai/mcp-gateway/api"
+	"github.com/tomeai/mcp-gateway/internal/cache"
 	"github.com/tomeai/mcp-gateway/internal/db"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/oauth"
+	"github.com/tomeai/mcp-gateway/internal/sandbox"
+	"github.com/tomeai/mcp-gateway/internal/secret"
 	"github.com/tomeai/mcp-gateway/internal/telemetry"
 	"github.com/tomeai/mcp-gateway/repository"
 	"github.com/tomeai/mcp-gateway/service"
@@ -46,6 +51,106 @@ func (app *App) Run(args []string) {
 			Name:  "dsn",
 			Value: "",
 		},
+		&cli.StringFlag{
+			Name:  "config",
+			Usage: "declarative YAML/JSON config file, used instead of the database when set",
+		},
+		&cli.StringFlag{
+			Name:    "master-key",
+			EnvVars: []string{"MCP_GATEWAY_MASTER_KEY"},
+			Usage:   "32 byte key, hex or base64 encoded, encrypting the secrets stored in the database",
+		},
+		&cli.StringFlag{
+			Name:    "master-key-file",
+			EnvVars: []string{"MCP_GATEWAY_MASTER_KEY_FILE"},
+			Usage:   "file holding the master key, takes precedence over master-key",
+		},
+		&cli.StringFlag{
+			Name:    "init-token",
+			EnvVars: []string{"MCP_GATEWAY_INIT_TOKEN"},
+			Usage:   "bootstrap token required by the init API, which is disabled when empty",
+		},
+		&cli.StringFlag{
+			Name:  "oauth-issuer",
+			Usage: "issuer of the JWT access tokens accepted from MCP clients, enables OAuth when set",
+		},
+		&cli.StringFlag{
+			Name:  "oauth-jwks",
+			Usage: "path or URL of the JSON Web Key Set of the issuer",
+		},
+		&cli.StringFlag{
+			Name:  "oauth-audience",
+			Usage: "audience the access tokens must be issued for, defaults to oauth-resource",
+		},
+		&cli.StringFlag{
+			Name:  "oauth-resource",
+			Usage: "public URL of the gateway advertised as the protected resource and redirected to by the upstream authorizations",
+		},
+		&cli.BoolFlag{
+			Name:  "trust-forwarded-headers",
+			Usage: "take the public URL of the gateway from the X-Forwarded-Proto and X-Forwarded-Host headers when oauth-resource is empty, only behind a reverse proxy setting them",
+		},
+		&cli.StringFlag{
+			Name:  "oauth-scope-prefix",
+			Value: "mcp:",
+			Usage: "prefix of the scopes granting access to a server, e.g. mcp:github",
+		},
+		&cli.StringSliceFlag{
+			Name:  "egress-allow-host",
+			Usage: "host the upstream servers may be reached at, *.example.com matches the subdomains; restricts egress to the allow lists when set",
+		},
+		&cli.StringSliceFlag{
+			Name:  "egress-allow-cidr",
+			Usage: "address range the upstream servers may be reached at, private ranges included; restricts egress to the allow lists when set",
+		},
+		&cli.BoolFlag{
+			Name:  "egress-allow-private",
+			Usage: "let the upstream servers be reached at loopback, private and link-local addresses",
+		},
+		&cli.StringSliceFlag{
+			Name:  "stdio-allow-command",
+			Value: cli.NewStringSlice("npx", "uvx"),
+			Usage: "executable the stdio servers may run, a name looked up in PATH or an absolute path",
+		},
+		&cli.StringFlag{
+			Name:  "stdio-workdir",
+			Usage: "parent of the working directories of the stdio server instances, the temporary directory when empty",
+		},
+		&cli.StringFlag{
+			Name:  "tool-cache-backend",
+			Value: cache.BackendMemory,
+			Usage: "store of the cached tool results, the in-memory LRU or a backend registered with cache.Register",
+		},
+		&cli.IntFlag{
+			Name:  "tool-cache-size",
+			Value: 1000,
+			Usage: "maximum number of tool results kept by the memory backend",
+		},
+		&cli.StringFlag{
+			Name:  "catalog-dir",
+			Usage: "directory persisting the listings of the upstream servers in config file mode, kept in memory when empty",
+		},
+		&cli.BoolFlag{
+			Name:  "prewarm",
+			Usage: "connect the registered upstream servers on start instead of on their first request",
+		},
+		&cli.StringSliceFlag{
+			Name:  "prewarm-tag",
+			Usage: "pre-warm only the servers carrying one of the tags, the critical servers are always pre-warmed",
+		},
+		&cli.IntFlag{
+			Name:  "prewarm-concurrency",
+			Value: 4,
+			Usage: "maximum number of servers connected at once by the pre-warming",
+		},
+		&cli.BoolFlag{
+			Name:  "auto-migrate",
+			Usage: "apply pending database migrations on start instead of refusing to start",
+		},
+	}
+	cliV2.Commands = []*cli.Command{
+		initCommand(),
+		migrateCommand(),
 	}
 	cliV2.Action = func(c *cli.Context) error {
 		options := []fx.Option{
@@ -61,15 +166,49 @@ func (app *App) Run(args []string) {
 			fx.Provide(func() *zap.Logger {
 				return utils.ZlogInit()
 			}),
+			// secrets
+			fx.Provide(secret.NewCipher),
+			// oauth
+			fx.Provide(oauth.NewValidator),
+			// egress
+			fx.Provide(egress.NewPolicy),
+			// stdio sandbox
+			fx.Provide(sandbox.NewPolicy),
+			// tool result cache
+			fx.Provide(cache.NewStore),
+		}
+		if c.String("config") != "" {
+			options = append(options,
+				fx.Provide(repository.NewConfigFileStore),
+				fx.Provide(func(s *repository.ConfigFileStore) repository.McpServerRepository { return s }),
+				fx.Provide(func(s *repository.ConfigFileStore) repository.McpClientRepository { return s }),
+				fx.Provide(func(s *repository.ConfigFileStore) repository.AclRepository { return s }),
+				fx.Provide(func(s *repository.ConfigFileStore) repository.ServerConfigRepository { return s }),
+				fx.Provide(func(s *repository.ConfigFileStore) repository.UserRepository { return s }),
+				fx.Provide(func(s *repository.ConfigFileStore) repository.SecretRepository { return s }),
+				// the config file holds no tokens, they are kept in memory until the next restart
+				fx.Provide(fx.Annotate(repository.NewMemoryStore, fx.As(new(repository.OAuthTokenRepository)))),
+				fx.Provide(newCatalogStore),
+			)
+		} else {
+			options = append(options,
+				fx.Provide(db.NewDBConnection),
+				fx.Provide(fx.Annotate(repository.NewMcpServerService, fx.As(new(repository.McpServerRepository)))),
+				fx.Provide(fx.Annotate(repository.NewMCPClientService, fx.As(new(repository.McpClientRepository)), fx.As(new(repository.AclRepository)))),
+				fx.Provide(fx.Annotate(repository.NewServerConfigService, fx.As(new(repository.ServerConfigRepository)))),
+				fx.Provide(fx.Annotate(repository.NewUserService, fx.As(new(repository.UserRepository)))),
+				fx.Provide(fx.Annotate(repository.NewSecretService, fx.As(new(repository.SecretRepository)))),
+				fx.Provide(fx.Annotate(repository.NewOAuthTokenService, fx.As(new(repository.OAuthTokenRepository)))),
+				fx.Provide(fx.Annotate(repository.NewServerCatalogService, fx.As(new(repository.ServerCatalogRepository)))),
+			)
 		}
 		options = append(options,
 			fx.Provide(service.NewDynamicMCPServer),
 			fx.Provide(api.NewOtel),
-			fx.Provide(db.NewDBConnection),
-			fx.Provide(repository.NewMcpServerService),
-			fx.Provide(repository.NewMCPClientService),
+			fx.Provide(telemetry.NewCustomMetrics),
 			fx.Provide(api.NewServer),
 			fx.Invoke(NewHttpServer),
+			fx.Invoke(Prewarm),
 		)
 		depInj := fx.New(options...)
 		if err := depInj.Start(app.ctx); err != nil {
@@ -86,10 +225,21 @@ func (app *App) Run(args []string) {
 		fmt.Printf("[Fx] Cleanly stopped\n")
 		return nil
 	}
-	_ = cliV2.RunContext(app.ctx, args)
+	if err := cliV2.RunContext(app.ctx, args); err != nil {
+		fmt.Printf("[App] ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newCatalogStore persists the server catalogs of the config file mode in the catalog directory, or in memory.
+func newCatalogStore(c *cli.Context) (repository.ServerCatalogRepository, error) {
+	if dir := c.String("catalog-dir"); dir != "" {
+		return repository.NewCatalogFileStore(dir)
+	}
+	return repository.NewMemoryStore(), nil
 }
 
-func NewHttpServer(lc fx.Lifecycle, server *api.Server, otel *telemetry.Providers, logger *zap.Logger) {
+func NewHttpServer(lc fx.Lifecycle, server *api.Server, dynamicMCPServer *service.DynamicMCPServer, otel *telemetry.Providers, logger *zap.Logger) {
 	hook := fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			go func() {
@@ -105,6 +255,10 @@ func NewHttpServer(lc fx.Lifecycle, server *api.Server, otel *telemetry.Provider
 				logger.Error("http server shutdown failed", zap.Error(err))
 				errs = append(errs, err)
 			}
+			if err := dynamicMCPServer.Close(); err != nil {
+				logger.Error("mcp servers close failed", zap.Error(err))
+				errs = append(errs, err)
+			}
 			if err := otel.Shutdown(ctx); err != nil {
 				logger.Error("otel shutdown failed", zap.Error(err))
 				errs = append(errs, err)
@@ -115,6 +269,19 @@ func NewHttpServer(lc fx.Lifecycle, server *api.Server, otel *telemetry.Provider
 	lc.Append(hook)
 }
 
+// Prewarm connects the registered upstream servers in the background once started, when the prewarm flag is set.
+func Prewarm(lc fx.Lifecycle, ctx context.Context, c *cli.Context, dynamicMCPServer *service.DynamicMCPServer) {
+	if !c.Bool("prewarm") {
+		return
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			// the start context expires with the start timeout, the pre-warming runs until the app stops
+			return dynamicMCPServer.Prewarm(ctx, c.StringSlice("prewarm-tag"), c.Int("prewarm-concurrency"))
+		},
+	})
+}
+
 func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
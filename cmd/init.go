@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tomeai/mcp-gateway/internal/db"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"github.com/urfave/cli/v2"
+)
+
+func initCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "init",
+		Usage: "initialize the server, mcp traffic is rejected until then",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "mode",
+				Value: string(model.ModeProd),
+				Usage: "development allows anonymous mcp access, production enforces access tokens",
+			},
+		},
+		Action: initServer,
+	}
+}
+
+func initServer(c *cli.Context) error {
+	conn, err := db.NewDBConnection(c)
+	if err != nil {
+		return err
+	}
+	config, admin, err := repository.NewServerConfigService(conn).Initialize(model.ServerMode(c.String("mode")))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("server initialized in %s mode\n", config.Mode)
+	if admin != nil {
+		fmt.Printf("admin access token: %s\n", admin.AccessToken)
+		fmt.Println("store it safely, it will not be shown again")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tomeai/mcp-gateway/internal/db"
+	"github.com/urfave/cli/v2"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func migrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "manage the database schema",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "up",
+				Usage:  "apply all pending migrations",
+				Action: migrateUp,
+			},
+			{
+				Name:   "down",
+				Usage:  "roll back the latest applied migration",
+				Action: migrateDown,
+			},
+			{
+				Name:   "status",
+				Usage:  "list migrations and whether they are applied",
+				Action: migrateStatus,
+			},
+		},
+	}
+}
+
+func migrateUp(c *cli.Context) error {
+	conn, err := db.OpenDB(c)
+	if err != nil {
+		return err
+	}
+	applied, err := db.NewMigrator(conn).Up()
+	for _, migration := range applied {
+		fmt.Printf("applied %d: %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("database schema is up to date (version %d)\n", db.LatestVersion())
+	}
+	return nil
+}
+
+func migrateDown(c *cli.Context) error {
+	conn, err := db.OpenDB(c)
+	if err != nil {
+		return err
+	}
+	migration, err := db.NewMigrator(conn).Down()
+	if err != nil {
+		return err
+	}
+	if migration == nil {
+		fmt.Println("no migration to roll back")
+		return nil
+	}
+	fmt.Printf("rolled back %d: %s\n", migration.Version, migration.Description)
+	return nil
+}
+
+func migrateStatus(c *cli.Context) error {
+	conn, err := db.OpenDB(c)
+	if err != nil {
+		return err
+	}
+	status, err := db.NewMigrator(conn).Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Description, appliedAt)
+	}
+	return w.Flush()
+}
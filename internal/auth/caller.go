@@ -0,0 +1,48 @@
+package auth
+
+import "context"
+
+// Caller is the authenticated party behind a request.
+type Caller struct {
+	// UserId identifies the caller for per-user resources, e.g. credential bindings.
+	// It is prefixed by the source of the identity, see UserIdOf, ClientIdOf and SubjectIdOf.
+	UserId string
+	Admin  bool
+	// Anonymous is set for the callers without a token in development mode, who are never admins
+	Anonymous bool
+}
+
+// The prefixes of the caller ids keep apart the users, the clients and the subjects of the access tokens,
+// which would otherwise share the resources of one another when their names collide.
+const (
+	UserIdPrefix    = "user:"
+	ClientIdPrefix  = "client:"
+	SubjectIdPrefix = "jwt:"
+)
+
+// UserIdOf returns the caller id of a user of the gateway.
+func UserIdOf(username string) string {
+	return UserIdPrefix + username
+}
+
+// ClientIdOf returns the caller id of an MCP client of the gateway.
+func ClientIdOf(name string) string {
+	return ClientIdPrefix + name
+}
+
+// SubjectIdOf returns the caller id of the subject of an access token issued by an authorization server.
+func SubjectIdOf(issuer, subject string) string {
+	return SubjectIdPrefix + issuer + ":" + subject
+}
+
+type callerKey struct{}
+
+func WithCaller(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller stored in the context, nil if there is none.
+func CallerFromContext(ctx context.Context) *Caller {
+	caller, _ := ctx.Value(callerKey{}).(*Caller)
+	return caller
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BackendMemory is the name of the in-memory LRU backend
+const BackendMemory = "memory"
+
+// Store keeps the results of the upstream tool calls. Its implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored for key, false when missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value for key until ttl elapsed.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Purge removes the entries whose key starts with prefix, all of them when prefix is empty,
+	// and returns how many were removed.
+	Purge(ctx context.Context, prefix string) (int, error)
+}
+
+// Factory builds a store from the cache flags.
+type Factory func(ctx *cli.Context) (Store, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]Factory{
+		BackendMemory: func(ctx *cli.Context) (Store, error) {
+			return NewLRU(ctx.Int("tool-cache-size")), nil
+		},
+	}
+)
+
+// Register makes a store backend available to the tool-cache-backend flag under name.
+// It is meant to be called from the init function of the package implementing the backend.
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic("cache: backend registered twice: " + name)
+	}
+	backends[name] = factory
+}
+
+// NewStore builds the store of the backend selected by the tool-cache-backend flag.
+func NewStore(ctx *cli.Context, logger *zap.Logger) (Store, error) {
+	name := ctx.String("tool-cache-backend")
+	backendsMu.Lock()
+	factory, ok := backends[name]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown tool cache backend %s, expected one of %v", name, backendNames())
+	}
+	store, err := factory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tool cache backend %s: %w", name, err)
+	}
+	logger.Info("Tool cache", zap.String("backend", name))
+	return store, nil
+}
+
+func backendNames() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
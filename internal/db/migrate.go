@@ -0,0 +1,185 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// schemaMigration is a row of the table recording the applied migrations.
+type schemaMigration struct {
+	Version     int `gorm:"primaryKey"`
+	Description string
+	AppliedAt   time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus tells whether a migration has been applied to the database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back the versioned schema migrations.
+type Migrator struct {
+	db *gorm.DB
+}
+
+func NewMigrator(db *gorm.DB) *Migrator {
+	return &Migrator{db: db}
+}
+
+func (m *Migrator) ensureTable() error {
+	return m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error
+}
+
+// CurrentVersion returns the version of the latest migration applied to the database, 0 if none.
+func (m *Migrator) CurrentVersion() (int, error) {
+	if err := m.ensureTable(); err != nil {
+		return 0, err
+	}
+	return m.latestApplied()
+}
+
+// Up applies all the pending migrations in order and returns them.
+func (m *Migrator) Up() ([]Migration, error) {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		statements, err := m.statements(migration.Up)
+		if err != nil {
+			return applied, err
+		}
+		err = m.db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Create(&schemaMigration{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d: %w", migration.Version, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down rolls back the latest applied migration and returns it, nil if there is nothing to roll back.
+func (m *Migrator) Down() (*Migration, error) {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current == 0 {
+		return nil, nil
+	}
+	for i := range migrations {
+		migration := migrations[i]
+		if migration.Version != current {
+			continue
+		}
+		statements, err := m.statements(migration.Down)
+		if err != nil {
+			return nil, err
+		}
+		err = m.db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&schemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to roll back migration %d: %w", migration.Version, err)
+		}
+		return &migration, nil
+	}
+	return nil, fmt.Errorf("migration %d is unknown to this version of the gateway", current)
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		at, ok := appliedAt[migration.Version]
+		status = append(status, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return status, nil
+}
+
+// appliedVersion returns the version of the latest migration applied to the database, 0 when the table recording
+// them does not exist. Unlike CurrentVersion it only reads, it never creates the table.
+func (m *Migrator) appliedVersion() (int, error) {
+	if !m.db.Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+	return m.latestApplied()
+}
+
+func (m *Migrator) latestApplied() (int, error) {
+	var version int
+	err := m.db.Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// CheckVersion returns an error unless the database schema is at the version this build expects.
+// It only reads the database, the health checks run it on every probe.
+func (m *Migrator) CheckVersion() error {
+	current, err := m.appliedVersion()
+	if err != nil {
+		return err
+	}
+	latest := LatestVersion()
+	switch {
+	case current < latest:
+		return fmt.Errorf("database schema is at version %d but %d is required, run `wemcp-gateway migrate up`", current, latest)
+	case current > latest:
+		return fmt.Errorf("database schema is at version %d, newer than the %d supported by this build", current, latest)
+	}
+	return nil
+}
+
+func (m *Migrator) statements(byDialect map[string][]string) ([]string, error) {
+	statements, ok := byDialect[m.db.Dialector.Name()]
+	if !ok {
+		return nil, errors.New("unsupported database dialect: " + m.db.Dialector.Name())
+	}
+	return statements, nil
+}
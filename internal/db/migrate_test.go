@@ -0,0 +1,85 @@
+package db
+
+import (
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return conn
+}
+
+func TestCheckVersionOnlyReads(t *testing.T) {
+	conn := newTestDB(t)
+	migrator := NewMigrator(conn)
+	if err := migrator.CheckVersion(); err == nil {
+		t.Fatal("CheckVersion() on an empty database succeeded")
+	}
+	if conn.Migrator().HasTable(&schemaMigration{}) {
+		t.Fatal("CheckVersion() created the schema_migrations table")
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	migrator := NewMigrator(newTestDB(t))
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("Up() applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	if err := migrator.CheckVersion(); err != nil {
+		t.Fatalf("CheckVersion() after Up() error = %v", err)
+	}
+	if applied, err := migrator.Up(); err != nil || len(applied) != 0 {
+		t.Fatalf("second Up() = %d migrations, %v", len(applied), err)
+	}
+
+	rolledBack, err := migrator.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack == nil || rolledBack.Version != LatestVersion() {
+		t.Fatalf("Down() rolled back %+v, want version %d", rolledBack, LatestVersion())
+	}
+	if err := migrator.CheckVersion(); err == nil {
+		t.Fatal("CheckVersion() after Down() succeeded")
+	}
+	status, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range status {
+		if want := migration.Version < LatestVersion(); migration.Applied != want {
+			t.Errorf("migration %d applied = %v, want %v", migration.Version, migration.Applied, want)
+		}
+	}
+
+	// the whole schema rolls back
+	for {
+		rolledBack, err := migrator.Down()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rolledBack == nil {
+			break
+		}
+	}
+	if version, err := migrator.CurrentVersion(); err != nil || version != 0 {
+		t.Fatalf("CurrentVersion() = %d, %v, want 0", version, err)
+	}
+}
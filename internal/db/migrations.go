@@ -0,0 +1,343 @@
+package db
+
+const (
+	dialectPostgres = "postgres"
+	dialectSqlite   = "sqlite"
+)
+
+// Migration is one versioned step of the database schema.
+// Up and Down hold the statements to run for each supported dialect.
+type Migration struct {
+	Version     int
+	Description string
+	Up          map[string][]string
+	Down        map[string][]string
+}
+
+// migrations lists every schema migration, ordered by version.
+// Released migrations must never be edited, add a new one instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create mcp_servers, mcp_clients and server_configs",
+		// IF NOT EXISTS keeps databases created by the former AutoMigrate usable
+		Up: map[string][]string{
+			dialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS mcp_servers (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					user_id TEXT NOT NULL,
+					server_name TEXT NOT NULL,
+					server_config JSONB NOT NULL,
+					env JSONB DEFAULT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_mcp_servers_deleted_at ON mcp_servers (deleted_at)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_server ON mcp_servers (user_id, server_name)`,
+				`CREATE TABLE IF NOT EXISTS mcp_clients (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					name TEXT NOT NULL,
+					description TEXT,
+					access_token TEXT NOT NULL,
+					allow_list JSONB NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_mcp_clients_deleted_at ON mcp_clients (deleted_at)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_mcp_clients_name ON mcp_clients (name)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_mcp_clients_access_token ON mcp_clients (access_token)`,
+				`CREATE TABLE IF NOT EXISTS server_configs (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					mode VARCHAR(12) NOT NULL,
+					initialized BOOLEAN NOT NULL DEFAULT false
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_server_configs_deleted_at ON server_configs (deleted_at)`,
+			},
+			dialectSqlite: {
+				`CREATE TABLE IF NOT EXISTS mcp_servers (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					user_id TEXT NOT NULL,
+					server_name TEXT NOT NULL,
+					server_config JSON NOT NULL,
+					env JSON DEFAULT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_mcp_servers_deleted_at ON mcp_servers (deleted_at)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_server ON mcp_servers (user_id, server_name)`,
+				`CREATE TABLE IF NOT EXISTS mcp_clients (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					name TEXT NOT NULL,
+					description TEXT,
+					access_token TEXT NOT NULL,
+					allow_list JSON NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_mcp_clients_deleted_at ON mcp_clients (deleted_at)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_mcp_clients_name ON mcp_clients (name)`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS idx_mcp_clients_access_token ON mcp_clients (access_token)`,
+				`CREATE TABLE IF NOT EXISTS server_configs (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					mode VARCHAR(12) NOT NULL,
+					initialized BOOLEAN NOT NULL DEFAULT false
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_server_configs_deleted_at ON server_configs (deleted_at)`,
+			},
+		},
+		Down: map[string][]string{
+			dialectPostgres: {
+				`DROP TABLE IF EXISTS server_configs`,
+				`DROP TABLE IF EXISTS mcp_clients`,
+				`DROP TABLE IF EXISTS mcp_servers`,
+			},
+			dialectSqlite: {
+				`DROP TABLE IF EXISTS server_configs`,
+				`DROP TABLE IF EXISTS mcp_clients`,
+				`DROP TABLE IF EXISTS mcp_servers`,
+			},
+		},
+	},
+	{
+		Version:     2,
+		Description: "create users",
+		Up: map[string][]string{
+			dialectPostgres: {
+				`CREATE TABLE users (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					username TEXT NOT NULL,
+					role VARCHAR(12) NOT NULL,
+					access_token TEXT NOT NULL
+				)`,
+				`CREATE INDEX idx_users_deleted_at ON users (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_users_username ON users (username)`,
+				`CREATE UNIQUE INDEX idx_users_access_token ON users (access_token)`,
+			},
+			dialectSqlite: {
+				`CREATE TABLE users (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					username TEXT NOT NULL,
+					role VARCHAR(12) NOT NULL,
+					access_token TEXT NOT NULL
+				)`,
+				`CREATE INDEX idx_users_deleted_at ON users (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_users_username ON users (username)`,
+				`CREATE UNIQUE INDEX idx_users_access_token ON users (access_token)`,
+			},
+		},
+		Down: map[string][]string{
+			dialectPostgres: {`DROP TABLE users`},
+			dialectSqlite:   {`DROP TABLE users`},
+		},
+	},
+	{
+		Version:     3,
+		Description: "add mcp_servers.headers and create secrets",
+		Up: map[string][]string{
+			dialectPostgres: {
+				`ALTER TABLE mcp_servers ADD COLUMN headers JSONB DEFAULT NULL`,
+				`CREATE TABLE secrets (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					name TEXT NOT NULL,
+					value TEXT NOT NULL
+				)`,
+				`CREATE INDEX idx_secrets_deleted_at ON secrets (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_secrets_name ON secrets (name)`,
+			},
+			dialectSqlite: {
+				`ALTER TABLE mcp_servers ADD COLUMN headers JSON DEFAULT NULL`,
+				`CREATE TABLE secrets (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					name TEXT NOT NULL,
+					value TEXT NOT NULL
+				)`,
+				`CREATE INDEX idx_secrets_deleted_at ON secrets (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_secrets_name ON secrets (name)`,
+			},
+		},
+		Down: map[string][]string{
+			dialectPostgres: {
+				`DROP TABLE secrets`,
+				`ALTER TABLE mcp_servers DROP COLUMN headers`,
+			},
+			dialectSqlite: {
+				`DROP TABLE secrets`,
+				`ALTER TABLE mcp_servers DROP COLUMN headers`,
+			},
+		},
+	},
+	{
+		Version:     4,
+		Description: "create server_templates and credential_bindings",
+		Up: map[string][]string{
+			dialectPostgres: {
+				`CREATE TABLE server_templates (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					name TEXT NOT NULL,
+					description TEXT,
+					server_config JSONB NOT NULL
+				)`,
+				`CREATE INDEX idx_server_templates_deleted_at ON server_templates (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_server_templates_name ON server_templates (name)`,
+				`CREATE TABLE credential_bindings (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					template_name TEXT NOT NULL,
+					user_id TEXT NOT NULL,
+					env JSONB DEFAULT NULL,
+					headers JSONB DEFAULT NULL
+				)`,
+				`CREATE INDEX idx_credential_bindings_deleted_at ON credential_bindings (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_template_user ON credential_bindings (template_name, user_id)`,
+			},
+			dialectSqlite: {
+				`CREATE TABLE server_templates (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					name TEXT NOT NULL,
+					description TEXT,
+					server_config JSON NOT NULL
+				)`,
+				`CREATE INDEX idx_server_templates_deleted_at ON server_templates (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_server_templates_name ON server_templates (name)`,
+				`CREATE TABLE credential_bindings (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					template_name TEXT NOT NULL,
+					user_id TEXT NOT NULL,
+					env JSON DEFAULT NULL,
+					headers JSON DEFAULT NULL
+				)`,
+				`CREATE INDEX idx_credential_bindings_deleted_at ON credential_bindings (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_template_user ON credential_bindings (template_name, user_id)`,
+			},
+		},
+		Down: map[string][]string{
+			dialectPostgres: {
+				`DROP TABLE credential_bindings`,
+				`DROP TABLE server_templates`,
+			},
+			dialectSqlite: {
+				`DROP TABLE credential_bindings`,
+				`DROP TABLE server_templates`,
+			},
+		},
+	},
+	{
+		Version:     5,
+		Description: "create oauth_tokens",
+		Up: map[string][]string{
+			dialectPostgres: {
+				`CREATE TABLE oauth_tokens (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					user_id TEXT NOT NULL,
+					server_name TEXT NOT NULL,
+					client_id TEXT,
+					client_secret TEXT,
+					token TEXT
+				)`,
+				`CREATE INDEX idx_oauth_tokens_deleted_at ON oauth_tokens (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_oauth_user_server ON oauth_tokens (user_id, server_name)`,
+			},
+			dialectSqlite: {
+				`CREATE TABLE oauth_tokens (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					user_id TEXT NOT NULL,
+					server_name TEXT NOT NULL,
+					client_id TEXT,
+					client_secret TEXT,
+					token TEXT
+				)`,
+				`CREATE INDEX idx_oauth_tokens_deleted_at ON oauth_tokens (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_oauth_user_server ON oauth_tokens (user_id, server_name)`,
+			},
+		},
+		Down: map[string][]string{
+			dialectPostgres: {`DROP TABLE oauth_tokens`},
+			dialectSqlite:   {`DROP TABLE oauth_tokens`},
+		},
+	},
+	{
+		Version:     6,
+		Description: "create server_catalogs",
+		Up: map[string][]string{
+			dialectPostgres: {
+				`CREATE TABLE server_catalogs (
+					id BIGSERIAL PRIMARY KEY,
+					created_at TIMESTAMPTZ,
+					updated_at TIMESTAMPTZ,
+					deleted_at TIMESTAMPTZ,
+					user_id TEXT NOT NULL,
+					server_name TEXT NOT NULL,
+					config_key TEXT NOT NULL,
+					catalog JSONB NOT NULL
+				)`,
+				`CREATE INDEX idx_server_catalogs_deleted_at ON server_catalogs (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_catalog_user_server ON server_catalogs (user_id, server_name)`,
+			},
+			dialectSqlite: {
+				`CREATE TABLE server_catalogs (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					created_at DATETIME,
+					updated_at DATETIME,
+					deleted_at DATETIME,
+					user_id TEXT NOT NULL,
+					server_name TEXT NOT NULL,
+					config_key TEXT NOT NULL,
+					catalog JSON NOT NULL
+				)`,
+				`CREATE INDEX idx_server_catalogs_deleted_at ON server_catalogs (deleted_at)`,
+				`CREATE UNIQUE INDEX idx_catalog_user_server ON server_catalogs (user_id, server_name)`,
+			},
+		},
+		Down: map[string][]string{
+			dialectPostgres: {`DROP TABLE server_catalogs`},
+			dialectSqlite:   {`DROP TABLE server_catalogs`},
+		},
+	},
+}
+
+// LatestVersion is the schema version this build of the gateway expects.
+func LatestVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
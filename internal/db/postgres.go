@@ -2,9 +2,7 @@ package db
 
 import (
 	"fmt"
-	"github.com/gin-gonic/gin"
 	"github.com/glebarez/sqlite"
-	"github.com/tomeai/mcp-gateway/model"
 	"github.com/urfave/cli/v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -12,7 +10,31 @@ import (
 	"log"
 )
 
+// NewDBConnection opens the database and refuses to hand it out unless its schema is up to date.
+// Pending migrations are applied first when auto-migrate is enabled.
 func NewDBConnection(ctx *cli.Context) (*gorm.DB, error) {
+	db, err := OpenDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrator := NewMigrator(db)
+	if ctx.Bool("auto-migrate") {
+		applied, err := migrator.Up()
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+		for _, migration := range applied {
+			log.Printf("[db] applied migration %d: %s\n", migration.Version, migration.Description)
+		}
+	}
+	if err := migrator.CheckVersion(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDB opens the database without looking at its schema.
+func OpenDB(ctx *cli.Context) (*gorm.DB, error) {
 	var dialector gorm.Dialector
 	dsn := ctx.String("dsn")
 	if dsn == "" {
@@ -29,11 +51,5 @@ func NewDBConnection(ctx *cli.Context) (*gorm.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	if gin.Mode() != gin.ReleaseMode {
-		err := db.AutoMigrate(&model.McpServer{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to migrate database: %w", err)
-		}
-	}
 	return db, nil
 }
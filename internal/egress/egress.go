@@ -0,0 +1,205 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+var ErrDenied = errors.New("egress denied")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Config of the destinations the gateway may connect to on behalf of the upstream servers.
+type Config struct {
+	// AllowHosts lists the host names allowed whatever their address, *.example.com matches the subdomains
+	AllowHosts []string
+	// AllowCIDRs lists the address ranges allowed, private ones included
+	AllowCIDRs []string
+	// AllowPrivate lets the upstream servers resolve to loopback, private and link-local addresses
+	AllowPrivate bool
+}
+
+// Policy decides which destinations the upstream servers may be reached at.
+// With no allow list any public address is allowed, otherwise only the listed hosts and ranges.
+// A nil policy allows everything.
+type Policy struct {
+	hosts        []string
+	prefixes     []netip.Prefix
+	allowPrivate bool
+}
+
+// NewPolicy builds the policy from the egress flags.
+func NewPolicy(ctx *cli.Context, logger *zap.Logger) (*Policy, error) {
+	config := Config{
+		AllowHosts:   ctx.StringSlice("egress-allow-host"),
+		AllowCIDRs:   ctx.StringSlice("egress-allow-cidr"),
+		AllowPrivate: ctx.Bool("egress-allow-private"),
+	}
+	policy, err := NewPolicyFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Egress policy",
+		zap.Strings("allowHosts", config.AllowHosts),
+		zap.Strings("allowCidrs", config.AllowCIDRs),
+		zap.Bool("allowPrivate", config.AllowPrivate))
+	return policy, nil
+}
+
+func NewPolicyFromConfig(config Config) (*Policy, error) {
+	p := &Policy{allowPrivate: config.AllowPrivate}
+	for _, host := range config.AllowHosts {
+		// a wildcard only stands for the subdomains, e.g. *.example.com
+		if domain, _ := strings.CutPrefix(host, "*."); domain == "" || strings.Contains(domain, "*") {
+			return nil, fmt.Errorf("invalid egress host %s", host)
+		}
+		p.hosts = append(p.hosts, strings.ToLower(strings.TrimSuffix(host, ".")))
+	}
+	for _, cidr := range config.AllowCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress cidr %s: %w", cidr, err)
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	return p, nil
+}
+
+func (p *Policy) hasAllowList() bool {
+	return len(p.hosts) > 0 || len(p.prefixes) > 0
+}
+
+// hostAllowed tells whether the host name is in the allow list.
+func (p *Policy) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.hosts {
+		if pattern == host {
+			return true
+		}
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAddr returns an error unless the upstream servers may connect to the address.
+func (p *Policy) CheckAddr(addr netip.Addr) error {
+	if p == nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if p.hasAllowList() {
+		return fmt.Errorf("%w: %s is not in the allow list", ErrDenied, addr)
+	}
+	if !p.allowPrivate && isPrivate(addr) {
+		return fmt.Errorf("%w: %s is a private address", ErrDenied, addr)
+	}
+	return nil
+}
+
+func isPrivate(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// CheckURL returns an error unless the URL may be connected to. Host names that are not in the allow list
+// are only checked once resolved, by the dialer.
+func (p *Policy) CheckURL(rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("%w: unsupported scheme %s", ErrDenied, u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrDenied)
+	}
+	if p.hostAllowed(host) {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.CheckAddr(addr)
+	}
+	if len(p.prefixes) == 0 && len(p.hosts) > 0 {
+		return fmt.Errorf("%w: %s is not in the allow list", ErrDenied, host)
+	}
+	return nil
+}
+
+// CheckHost returns an error unless the host may be connected to, resolving it unless it is in the allow list.
+// It checks the destinations reached through a proxy, the dialer only seeing the address of the proxy.
+func (p *Policy) CheckHost(ctx context.Context, host string) error {
+	if p == nil || p.hostAllowed(host) {
+		return nil
+	}
+	_, err := p.resolve(ctx, host)
+	return err
+}
+
+// resolve returns the addresses of the host, failing unless they are all allowed by the policy.
+func (p *Policy) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if err := p.CheckAddr(addr); err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return addrs, nil
+}
+
+// DialContext wraps the dialer so that it only connects to the addresses allowed by the policy.
+// The host is resolved once and the checked address is dialed, a DNS answer changing in between
+// (DNS rebinding) cannot redirect the connection.
+func (p *Policy) DialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if p == nil {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if p.hostAllowed(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		addrs, err := p.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var dialErr error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = err
+		}
+		return nil, dialErr
+	}
+}
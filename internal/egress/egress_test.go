@@ -0,0 +1,122 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func newTestPolicy(t *testing.T, config Config) *Policy {
+	t.Helper()
+	policy, err := NewPolicyFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestCheckAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		addr    string
+		allowed bool
+	}{
+		{"public", Config{}, "93.184.216.34", true},
+		{"loopback", Config{}, "127.0.0.1", false},
+		{"private", Config{}, "10.1.2.3", false},
+		{"metadata", Config{}, "169.254.169.254", false},
+		{"shared address space", Config{}, "100.64.0.1", false},
+		{"mapped loopback", Config{}, "::ffff:127.0.0.1", false},
+		{"ipv6 loopback", Config{}, "::1", false},
+		{"private allowed", Config{AllowPrivate: true}, "10.1.2.3", true},
+		{"in allowed cidr", Config{AllowCIDRs: []string{"10.0.0.0/8"}}, "10.1.2.3", true},
+		{"outside allowed cidr", Config{AllowCIDRs: []string{"10.0.0.0/8"}}, "93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestPolicy(t, tt.config).CheckAddr(netip.MustParseAddr(tt.addr))
+			if tt.allowed && err != nil {
+				t.Fatalf("CheckAddr(%s) error = %v", tt.addr, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrDenied) {
+				t.Fatalf("CheckAddr(%s) error = %v, want ErrDenied", tt.addr, err)
+			}
+		})
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		url     string
+		allowed bool
+	}{
+		{"public host", Config{}, "https://mcp.example.com/mcp", true},
+		{"private address", Config{}, "http://192.168.1.1/mcp", false},
+		{"unsupported scheme", Config{}, "file:///etc/passwd", false},
+		{"missing host", Config{}, "http:///mcp", false},
+		{"allowed host", Config{AllowHosts: []string{"mcp.example.com"}}, "https://mcp.example.com/mcp", true},
+		{"allowed subdomain", Config{AllowHosts: []string{"*.example.com"}}, "https://mcp.example.com/mcp", true},
+		{"host not allowed", Config{AllowHosts: []string{"*.example.com"}}, "https://example.org/mcp", false},
+		{"nested subdomain", Config{AllowHosts: []string{"*.example.com"}}, "https://mcp.eu.example.com/mcp", true},
+		{"suffix without a dot", Config{AllowHosts: []string{"*.example.com"}}, "https://evilexample.com/mcp", false},
+		{"wildcard domain itself", Config{AllowHosts: []string{"*.example.com"}}, "https://example.com/mcp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestPolicy(t, tt.config).CheckURL(tt.url)
+			if tt.allowed && err != nil {
+				t.Fatalf("CheckURL(%s) error = %v", tt.url, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrDenied) {
+				t.Fatalf("CheckURL(%s) error = %v, want ErrDenied", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestInvalidAllowHosts(t *testing.T) {
+	for _, host := range []string{"*", "*.", "*example.com", "mcp.*.example.com", "*.*.example.com"} {
+		if _, err := NewPolicyFromConfig(Config{AllowHosts: []string{host}}); err == nil {
+			t.Errorf("NewPolicyFromConfig accepted the allowed host %s", host)
+		}
+	}
+}
+
+func TestCheckHostResolvesNames(t *testing.T) {
+	policy := newTestPolicy(t, Config{})
+	// localhost resolves to the loopback addresses, denied unless private addresses are allowed
+	if err := policy.CheckHost(context.Background(), "localhost"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("CheckHost(localhost) error = %v, want ErrDenied", err)
+	}
+	if err := newTestPolicy(t, Config{AllowPrivate: true}).CheckHost(context.Background(), "localhost"); err != nil {
+		t.Fatalf("CheckHost(localhost) with private addresses allowed error = %v", err)
+	}
+	if err := newTestPolicy(t, Config{AllowHosts: []string{"localhost"}}).CheckHost(context.Background(), "localhost"); err != nil {
+		t.Fatalf("CheckHost(localhost) with localhost allowed error = %v", err)
+	}
+}
+
+func TestDialContextDeniesPrivateAddresses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	dial := newTestPolicy(t, Config{}).DialContext(&net.Dialer{})
+	if _, err := dial(context.Background(), "tcp", listener.Addr().String()); !errors.Is(err, ErrDenied) {
+		t.Fatalf("dial error = %v, want ErrDenied", err)
+	}
+
+	dial = newTestPolicy(t, Config{AllowCIDRs: []string{"127.0.0.1/32"}}).DialContext(&net.Dialer{})
+	conn, err := dial(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error = %v", err)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,276 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is the leeway allowed when checking the time based claims
+const clockSkew = time.Minute
+
+var ErrInvalidToken = errors.New("invalid access token")
+
+// Config of the authorization server whose access tokens the gateway accepts.
+type Config struct {
+	Issuer string
+	// JWKS is the path or URL of the JSON Web Key Set of the issuer
+	JWKS string
+	// Audience the tokens must be issued for, Resource when empty
+	Audience string
+	// Resource is the identifier of the gateway advertised in its protected resource metadata,
+	// derived from the request when empty
+	Resource string
+	// ScopePrefix prefixes the scopes granting access to a server, e.g. mcp:github
+	ScopePrefix string
+}
+
+// Claims are the claims of an access token the gateway relies on.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Scope     string   `json:"scope"`
+	Scp       []string `json:"scp"`
+	ClientId  string   `json:"client_id"`
+}
+
+// Scopes returns the scopes of the token, from either the scope or the scp claim.
+func (c *Claims) Scopes() []string {
+	if c.Scope != "" {
+		return strings.Fields(c.Scope)
+	}
+	return c.Scp
+}
+
+// audience accepts both the single string and the array forms of the aud claim.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := sonic.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := sonic.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// accepts tells whether the token is meant for the gateway: issued for the audience itself
+// or for one of the resources under it, e.g. the /mcp/{name} endpoint of a server.
+func (a audience) accepts(aud string) bool {
+	for _, v := range a {
+		if v == aud || strings.HasPrefix(v, strings.TrimSuffix(aud, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Validator validates the JWT access tokens issued by the configured authorization server.
+type Validator struct {
+	config Config
+	keys   *keySet
+}
+
+// NewValidator builds the validator from the oauth flags, it returns nil when no issuer is configured.
+func NewValidator(ctx *cli.Context, logger *zap.Logger) (*Validator, error) {
+	config := Config{
+		Issuer:      ctx.String("oauth-issuer"),
+		JWKS:        ctx.String("oauth-jwks"),
+		Audience:    ctx.String("oauth-audience"),
+		Resource:    ctx.String("oauth-resource"),
+		ScopePrefix: ctx.String("oauth-scope-prefix"),
+	}
+	if config.Issuer == "" {
+		return nil, nil
+	}
+	validator, err := NewValidatorFromConfig(ctx.Context, config)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("OAuth access tokens enabled", zap.String("issuer", config.Issuer), zap.String("jwks", config.JWKS))
+	return validator, nil
+}
+
+func NewValidatorFromConfig(ctx context.Context, config Config) (*Validator, error) {
+	if config.JWKS == "" {
+		return nil, errors.New("oauth-jwks is required with oauth-issuer")
+	}
+	if config.Audience == "" {
+		config.Audience = config.Resource
+	}
+	if config.Audience == "" {
+		return nil, errors.New("oauth-audience or oauth-resource is required with oauth-issuer")
+	}
+	keys, err := newKeySet(ctx, config.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{config: config, keys: keys}, nil
+}
+
+func (v *Validator) Config() Config {
+	return v.config
+}
+
+// LooksLikeJWT tells tokens in the compact JWT serialization apart from the opaque gateway tokens.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Validate checks the signature, issuer, audience and lifetime of the token and returns its claims.
+func (v *Validator) Validate(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	key, err := v.keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != v.config.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	case !claims.Audience.accepts(v.config.Audience):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	case claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return &claims, nil
+}
+
+// AllowedServers maps the scopes of the token to the names of the servers it grants access to.
+func (v *Validator) AllowedServers(claims *Claims) []string {
+	servers := []string{}
+	for _, scope := range claims.Scopes() {
+		if name, ok := strings.CutPrefix(scope, v.config.ScopePrefix); ok && name != "" {
+			servers = append(servers, name)
+		}
+	}
+	return servers
+}
+
+// ScopesSupported returns the scopes granting access to the given servers.
+func (v *Validator) ScopesSupported(serverNames ...string) []string {
+	scopes := make([]string, 0, len(serverNames))
+	for _, name := range serverNames {
+		scopes = append(scopes, v.config.ScopePrefix+name)
+	}
+	return scopes
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return sonic.Unmarshal(data, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hashFunc func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hashFunc, cryptoHash = sha256.New, crypto.SHA256
+	case "384":
+		hashFunc, cryptoHash = sha512.New384, crypto.SHA384
+	case "512":
+		hashFunc, cryptoHash = sha512.New, crypto.SHA512
+	}
+
+	switch {
+	case alg == "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(edKey, signed, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case hashFunc == nil:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hashFunc()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, cryptoHash, digest, signature) != nil {
+			return errors.New("invalid signature")
+		}
+	case strings.HasPrefix(alg, "PS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPSS(rsaKey, cryptoHash, digest, signature, nil) != nil {
+			return errors.New("invalid signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("invalid signature")
+		}
+		bitSize := ecKey.Curve.Params().BitSize
+		if alg != fmt.Sprintf("ES%d", min(bitSize, 512)) {
+			return errors.New("key curve does not match the algorithm")
+		}
+		size := (bitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		// none and the HMAC algorithms are rejected, the gateway has no shared secret
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://auth.example.com"
+	testAudience = "https://gateway.example.com"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// writeJWKS writes the public keys to a local JWKS file and returns its path.
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   b64(key.N.Bytes()),
+		"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"crv": key.Curve.Params().Name,
+		"x":   b64(key.X.FillBytes(make([]byte, size))),
+		"y":   b64(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+// signToken signs the claims as a compact JWT with an RS256 or ES256 key.
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":   testIssuer,
+		"sub":   "alice",
+		"aud":   testAudience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "openid mcp:github mcp:fetch",
+	}
+}
+
+func newTestValidator(t *testing.T, jwks string) *Validator {
+	t.Helper()
+	validator, err := NewValidatorFromConfig(context.Background(), Config{
+		Issuer:      testIssuer,
+		JWKS:        jwks,
+		Audience:    testAudience,
+		ScopePrefix: "mcp:",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validator
+}
+
+func TestValidateAgainstLocalJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := newTestValidator(t, writeJWKS(t, rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey)))
+
+	withClaim := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"rsa", signToken(t, "RS256", "rsa", rsaKey, validClaims()), true},
+		{"ec", signToken(t, "ES256", "ec", ecKey, validClaims()), true},
+		{"audience of a server", signToken(t, "RS256", "rsa", rsaKey, withClaim("aud", []string{testAudience + "/mcp/github"})), true},
+		{"other issuer", signToken(t, "RS256", "rsa", rsaKey, withClaim("iss", "https://evil.example.com")), false},
+		{"other audience", signToken(t, "RS256", "rsa", rsaKey, withClaim("aud", "https://other.example.com")), false},
+		{"expired", signToken(t, "RS256", "rsa", rsaKey, withClaim("exp", time.Now().Add(-time.Hour).Unix())), false},
+		{"no expiry", signToken(t, "RS256", "rsa", rsaKey, withClaim("exp", nil)), false},
+		{"not yet valid", signToken(t, "RS256", "rsa", rsaKey, withClaim("nbf", time.Now().Add(time.Hour).Unix())), false},
+		{"no subject", signToken(t, "RS256", "rsa", rsaKey, withClaim("sub", nil)), false},
+		{"unknown key", signToken(t, "RS256", "other", otherKey, validClaims()), false},
+		{"key of another kid", signToken(t, "RS256", "rsa", otherKey, validClaims()), false},
+		{"algorithm of another key", signToken(t, "RS256", "ec", rsaKey, validClaims()), false},
+		{"malformed", "a.b.c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := validator.Validate(context.Background(), tt.token)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				if claims.Subject != "alice" {
+					t.Errorf("Subject = %q, want alice", claims.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Validate() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsUnsignedToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := newTestValidator(t, writeJWKS(t, rsaJWK("rsa", rsaKey)))
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rsa"})
+	payload, _ := json.Marshal(validClaims())
+	token := b64(header) + "." + b64(payload) + "."
+	if _, err := validator.Validate(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Validate() error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAllowedServers(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := newTestValidator(t, writeJWKS(t, rsaJWK("rsa", rsaKey)))
+
+	claims, err := validator.Validate(context.Background(), signToken(t, "RS256", "rsa", rsaKey, validClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := validator.AllowedServers(claims), []string{"github", "fetch"}; !slices.Equal(got, want) {
+		t.Errorf("AllowedServers() = %v, want %v", got, want)
+	}
+}
+
+func TestNewValidatorRejectsEmptyJWKS(t *testing.T) {
+	_, err := NewValidatorFromConfig(context.Background(), Config{
+		Issuer:   testIssuer,
+		JWKS:     writeJWKS(t),
+		Audience: testAudience,
+	})
+	if err == nil {
+		t.Fatal("NewValidatorFromConfig() succeeded with a JWKS holding no key")
+	}
+}
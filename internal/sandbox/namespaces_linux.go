@@ -0,0 +1,26 @@
+package sandbox
+
+import (
+	"github.com/tomeai/mcp-gateway/model"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const namespacesSupported = true
+
+// applyNamespaces runs the command in new namespaces, as the user of the gateway mapped to itself.
+func applyNamespaces(cmd *exec.Cmd, conf model.StdioSandbox) {
+	if !conf.Namespaces && !conf.IsolateNetwork {
+		return
+	}
+	cloneflags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if conf.IsolateNetwork {
+		cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  cloneflags,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+	}
+}
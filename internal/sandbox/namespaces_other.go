@@ -0,0 +1,12 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"github.com/tomeai/mcp-gateway/model"
+	"os/exec"
+)
+
+const namespacesSupported = false
+
+func applyNamespaces(cmd *exec.Cmd, conf model.StdioSandbox) {}
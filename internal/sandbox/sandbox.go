@@ -0,0 +1,143 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// gatewayEnvPrefix marks the env of the gateway itself (e.g. its master key), never passed to the commands
+const gatewayEnvPrefix = "MCP_GATEWAY_"
+
+// defaultEnv is passed from the gateway env to every command
+var defaultEnv = []string{"PATH", "LANG", "LC_ALL", "TZ"}
+
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+// Config of the isolation applied to all the stdio upstream commands.
+type Config struct {
+	// AllowCommands lists the executables the commands may run, names looked up in PATH or absolute paths
+	AllowCommands []string
+	// WorkDir is the parent of the working directories of the instances, the temporary directory when empty
+	WorkDir string
+}
+
+// Policy launches the stdio upstream commands under their isolation profile.
+type Policy struct {
+	config Config
+}
+
+// NewPolicy builds the policy from the stdio flags.
+func NewPolicy(ctx *cli.Context, logger *zap.Logger) *Policy {
+	config := Config{
+		AllowCommands: ctx.StringSlice("stdio-allow-command"),
+		WorkDir:       ctx.String("stdio-workdir"),
+	}
+	logger.Info("Stdio sandbox", zap.Strings("allowCommands", config.AllowCommands))
+	return NewPolicyFromConfig(config)
+}
+
+func NewPolicyFromConfig(config Config) *Policy {
+	if config.WorkDir == "" {
+		config.WorkDir = os.TempDir()
+	}
+	return &Policy{config: config}
+}
+
+// CheckCommand returns an error unless the command is in the allow list. A name is looked up in PATH,
+// a path must be listed as is.
+func (p *Policy) CheckCommand(command string) error {
+	if !slices.Contains(p.config.AllowCommands, command) {
+		return fmt.Errorf("%w: %s", ErrCommandNotAllowed, command)
+	}
+	return nil
+}
+
+// Sandbox is the isolation of a running instance of a stdio upstream server.
+type Sandbox struct {
+	dir  string
+	conf model.StdioSandbox
+}
+
+// Prepare checks the command of a server against the policy and creates the working directory of the instance.
+// The sandbox must be closed once the command exited.
+func (p *Policy) Prepare(name, command string, conf *model.StdioSandbox) (*Sandbox, error) {
+	if err := p.CheckCommand(command); err != nil {
+		return nil, err
+	}
+	s := &Sandbox{}
+	if conf != nil {
+		s.conf = *conf
+	}
+	if (s.conf.Namespaces || s.conf.IsolateNetwork) && !namespacesSupported {
+		return nil, errors.New("sandbox namespaces are only supported on Linux")
+	}
+	for _, name := range s.conf.EnvAllowList {
+		if strings.HasPrefix(name, gatewayEnvPrefix) {
+			return nil, fmt.Errorf("sandbox env %s is reserved to the gateway", name)
+		}
+	}
+	dir, err := os.MkdirTemp(p.config.WorkDir, "mcp-"+filepath.Base(name)+"-")
+	if err != nil {
+		return nil, err
+	}
+	s.dir = dir
+	return s, nil
+}
+
+// Command builds the process of the server in the sandbox, it implements transport.CommandFunc.
+// The process only gets the allowed variables of the gateway env and its working directory as HOME.
+func (s *Sandbox) Command(ctx context.Context, command string, env []string, args []string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, err
+	}
+	var cmd *exec.Cmd
+	if limits := s.ulimits(); limits != "" {
+		// the limits are set by the shell, then inherited by the command it executes
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", limits + `exec "$@"`, "sh", path}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, path, args...)
+	}
+	cmd.Dir = s.dir
+	cmd.Env = append(s.baseEnv(), env...)
+	applyNamespaces(cmd, s.conf)
+	return cmd, nil
+}
+
+func (s *Sandbox) baseEnv() []string {
+	env := []string{"HOME=" + s.dir, "TMPDIR=" + s.dir}
+	for _, name := range append(slices.Clone(defaultEnv), s.conf.EnvAllowList...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+func (s *Sandbox) ulimits() string {
+	var limits strings.Builder
+	if s.conf.CPUSeconds > 0 {
+		fmt.Fprintf(&limits, "ulimit -t %d || exit 1; ", s.conf.CPUSeconds)
+	}
+	if s.conf.MemoryMB > 0 {
+		fmt.Fprintf(&limits, "ulimit -v %d || exit 1; ", s.conf.MemoryMB*1024)
+	}
+	if s.conf.OpenFiles > 0 {
+		fmt.Fprintf(&limits, "ulimit -n %d || exit 1; ", s.conf.OpenFiles)
+	}
+	return limits.String()
+}
+
+// Close removes the working directory of the instance.
+func (s *Sandbox) Close() error {
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,127 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"github.com/tomeai/mcp-gateway/model"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestSandbox(t *testing.T, conf *model.StdioSandbox) *Sandbox {
+	t.Helper()
+	policy := NewPolicyFromConfig(Config{AllowCommands: []string{"sh"}, WorkDir: t.TempDir()})
+	s, err := policy.Prepare("test", "sh", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// run runs the shell script in the sandbox and returns the lines it printed.
+func run(t *testing.T, s *Sandbox, script string, env []string, args ...string) []string {
+	t.Helper()
+	cmd, err := s.Command(context.Background(), "sh", env, append([]string{"-c", script}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n")
+}
+
+func TestCheckCommand(t *testing.T) {
+	policy := NewPolicyFromConfig(Config{AllowCommands: []string{"npx", "/opt/bin/server"}})
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"npx", true},
+		{"/opt/bin/server", true},
+		{"bash", false},
+		// a name is only allowed as a name, its path is not
+		{"/usr/bin/npx", false},
+		{"server", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			err := policy.CheckCommand(tt.command)
+			if tt.allowed && err != nil {
+				t.Fatalf("CheckCommand(%s) error = %v", tt.command, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrCommandNotAllowed) {
+				t.Fatalf("CheckCommand(%s) error = %v, want ErrCommandNotAllowed", tt.command, err)
+			}
+		})
+	}
+}
+
+func TestPrepareRejectsConfigs(t *testing.T) {
+	policy := NewPolicyFromConfig(Config{AllowCommands: []string{"sh"}, WorkDir: t.TempDir()})
+	if _, err := policy.Prepare("test", "bash", nil); !errors.Is(err, ErrCommandNotAllowed) {
+		t.Errorf("Prepare() of a command not allowed error = %v, want ErrCommandNotAllowed", err)
+	}
+	if _, err := policy.Prepare("test", "sh", &model.StdioSandbox{EnvAllowList: []string{"MCP_GATEWAY_MASTER_KEY"}}); err == nil {
+		t.Error("Prepare() passed the env of the gateway to the command")
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("MCP_GATEWAY_MASTER_KEY", "master")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "aws")
+	t.Setenv("SANDBOX_ALLOWED", "allowed")
+	s := newTestSandbox(t, &model.StdioSandbox{EnvAllowList: []string{"SANDBOX_ALLOWED"}})
+
+	env := run(t, s, "env", []string{"SERVER_TOKEN=token"})
+	for _, want := range []string{"HOME=" + s.dir, "TMPDIR=" + s.dir, "PATH=" + os.Getenv("PATH"), "SANDBOX_ALLOWED=allowed", "SERVER_TOKEN=token"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("env lacks %s", want)
+		}
+	}
+	for _, variable := range env {
+		if strings.HasPrefix(variable, "MCP_GATEWAY_MASTER_KEY=") || strings.HasPrefix(variable, "AWS_SECRET_ACCESS_KEY=") {
+			t.Errorf("env leaks %s", variable)
+		}
+	}
+	if dir := run(t, s, "pwd", nil); !slices.Equal(dir, []string{s.dir}) {
+		t.Errorf("working directory = %q, want %s", dir, s.dir)
+	}
+}
+
+func TestCommandUlimits(t *testing.T) {
+	s := newTestSandbox(t, nil)
+	cmd, err := s.Command(context.Background(), "sh", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd.Args) != 1 {
+		t.Errorf("command without limits is wrapped: %q", cmd.Args)
+	}
+
+	s = newTestSandbox(t, &model.StdioSandbox{CPUSeconds: 30, MemoryMB: 512, OpenFiles: 64})
+	if limits, want := run(t, s, "ulimit -t; ulimit -v; ulimit -n", nil), []string{"30", "524288", "64"}; !slices.Equal(limits, want) {
+		t.Errorf("limits = %q, want %q", limits, want)
+	}
+	// the arguments reach the command unchanged through the shell setting the limits
+	if args := run(t, s, `echo "$1"`, nil, "sh", "a b; c"); !slices.Equal(args, []string{"a b; c"}) {
+		t.Errorf("argument = %q, want %q", args, "a b; c")
+	}
+}
+
+func TestCloseRemovesWorkDir(t *testing.T) {
+	s := newTestSandbox(t, nil)
+	if err := os.WriteFile(s.dir+"/cache", []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Errorf("working directory kept: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+)
+
+// prefix marks the values encrypted by a Cipher.
+const prefix = "enc:v1:"
+
+const keySize = 32
+
+var ErrNoMasterKey = errors.New("secret is encrypted but no master key is configured")
+
+// Cipher encrypts secrets at rest with envelope encryption: every value is sealed with
+// a fresh AES-256-GCM data key, which is itself sealed with the master key.
+// A nil Cipher leaves plaintext values untouched and fails to decrypt encrypted ones.
+type Cipher struct {
+	master cipher.AEAD
+}
+
+// NewCipher loads the master key from the master-key flag or the file named by master-key-file,
+// both of which may be set through the environment. It returns a nil Cipher when neither is set.
+func NewCipher(ctx *cli.Context, logger *zap.Logger) (*Cipher, error) {
+	encoded := ctx.String("master-key")
+	if path := ctx.String("master-key-file"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		encoded = string(data)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		logger.Warn("No master key configured, secrets are stored in plaintext")
+		return nil, nil
+	}
+	key, err := decodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipherFromKey(key)
+}
+
+func NewCipherFromKey(key []byte) (*Cipher, error) {
+	master, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{master: master}, nil
+}
+
+// decodeKey accepts a 32 byte key encoded in hex or base64.
+func decodeKey(encoded string) ([]byte, error) {
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("master key must be %d bytes encoded in hex or base64", keySize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether the value has been encrypted by a Cipher.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals the value, values already encrypted are returned as is.
+func (c *Cipher) Encrypt(value string) (string, error) {
+	if c == nil || IsEncrypted(value) {
+		return value, nil
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(c.master, dataKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(data, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens an encrypted value, plaintext values are returned as is.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrNoMasterKey
+	}
+	wrappedKey, ciphertext, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted secret")
+	}
+	dataKey, err := open(c.master, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptMap returns a copy of values with every value encrypted.
+func (c *Cipher) EncryptMap(values map[string]string) (map[string]string, error) {
+	return transformMap(values, c.Encrypt)
+}
+
+// DecryptMap returns a copy of values with every value decrypted.
+func (c *Cipher) DecryptMap(values map[string]string) (map[string]string, error) {
+	return transformMap(values, c.Decrypt)
+}
+
+func transformMap(values map[string]string, transform func(string) (string, error)) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	transformed := make(map[string]string, len(values))
+	for k, v := range values {
+		t, err := transform(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		transformed[k] = t
+	}
+	return transformed, nil
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, encoded string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted secret")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt secret, wrong master key?")
+	}
+	return plaintext, nil
+}
+
+// Store looks up the named secrets, the values it returns may be encrypted.
+type Store interface {
+	GetSecret(name string) (string, error)
+}
+
+// Resolver decrypts secrets and resolves the named ones from a store.
+type Resolver struct {
+	cipher *Cipher
+	store  Store
+}
+
+func NewResolver(cipher *Cipher, store Store) *Resolver {
+	return &Resolver{cipher: cipher, store: store}
+}
+
+func (r *Resolver) Decrypt(value string) (string, error) {
+	return r.cipher.Decrypt(value)
+}
+
+func (r *Resolver) DecryptMap(values map[string]string) (map[string]string, error) {
+	return r.cipher.DecryptMap(values)
+}
+
+// Secret returns the decrypted value of the named secret.
+func (r *Resolver) Secret(name string) (string, error) {
+	if r.store == nil {
+		return "", fmt.Errorf("secret %s not found", name)
+	}
+	value, err := r.store.GetSecret(name)
+	if err != nil {
+		return "", err
+	}
+	return r.cipher.Decrypt(value)
+}
@@ -0,0 +1,156 @@
+package secret
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T, fill byte) *Cipher {
+	t.Helper()
+	c, err := NewCipherFromKey([]byte(strings.Repeat(string(fill), keySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t, 'k')
+	encrypted, err := c.Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "s3cret") {
+		t.Fatalf("Encrypt() = %q", encrypted)
+	}
+	// every value is sealed with a fresh data key
+	again, err := c.Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("Encrypt() returned the same ciphertext twice")
+	}
+	// values already encrypted are not encrypted twice
+	if twice, err := c.Encrypt(encrypted); err != nil || twice != encrypted {
+		t.Errorf("Encrypt(encrypted) = %q, %v", twice, err)
+	}
+	for _, value := range []string{encrypted, again} {
+		if decrypted, err := c.Decrypt(value); err != nil || decrypted != "s3cret" {
+			t.Errorf("Decrypt() = %q, %v", decrypted, err)
+		}
+	}
+	if plaintext, err := c.Decrypt("plain"); err != nil || plaintext != "plain" {
+		t.Errorf("Decrypt(plaintext) = %q, %v", plaintext, err)
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	c := newTestCipher(t, 'k')
+	encrypted, err := c.Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestCipher(t, 'x').Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() with another master key succeeded")
+	}
+	var nilCipher *Cipher
+	if _, err := nilCipher.Decrypt(encrypted); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Decrypt() without master key error = %v, want ErrNoMasterKey", err)
+	}
+	// flip a character inside the sealed value, the last one may only hold padding bits
+	i := len(encrypted) - 10
+	flipped := byte('A')
+	if encrypted[i] == 'A' {
+		flipped = 'B'
+	}
+	tampered := encrypted[:i] + string(flipped) + encrypted[i+1:]
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Error("Decrypt() of a tampered value succeeded")
+	}
+	for _, malformed := range []string{prefix, prefix + "abc", prefix + "abc:def", prefix + "!!!:???"} {
+		if _, err := c.Decrypt(malformed); err == nil {
+			t.Errorf("Decrypt(%q) succeeded", malformed)
+		}
+	}
+}
+
+func TestNilCipherKeepsPlaintext(t *testing.T) {
+	var c *Cipher
+	if value, err := c.Encrypt("plain"); err != nil || value != "plain" {
+		t.Errorf("Encrypt() = %q, %v", value, err)
+	}
+	values, err := c.EncryptMap(map[string]string{"TOKEN": "plain"})
+	if err != nil || values["TOKEN"] != "plain" {
+		t.Errorf("EncryptMap() = %v, %v", values, err)
+	}
+}
+
+func TestEncryptMap(t *testing.T) {
+	c := newTestCipher(t, 'k')
+	values := map[string]string{"TOKEN": "abc", "URL": "https://example.com"}
+	encrypted, err := c.EncryptMap(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["TOKEN"] != "abc" {
+		t.Error("EncryptMap() modified its argument")
+	}
+	decrypted, err := c.DecryptMap(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		if !IsEncrypted(encrypted[k]) || decrypted[k] != v {
+			t.Errorf("%s: encrypted %q, decrypted %q", k, encrypted[k], decrypted[k])
+		}
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := []byte(strings.Repeat("k", keySize))
+	for _, encoded := range []string{hex.EncodeToString(key), base64.StdEncoding.EncodeToString(key)} {
+		if decoded, err := decodeKey(encoded); err != nil || string(decoded) != string(key) {
+			t.Errorf("decodeKey(%q) = %q, %v", encoded, decoded, err)
+		}
+	}
+	for _, encoded := range []string{"", "short", hex.EncodeToString(key[:16]), string(key)} {
+		if _, err := decodeKey(encoded); err == nil {
+			t.Errorf("decodeKey(%q) succeeded", encoded)
+		}
+	}
+}
+
+type mapStore map[string]string
+
+func (s mapStore) GetSecret(name string) (string, error) {
+	value, ok := s[name]
+	if !ok {
+		return "", errors.New("secret not found")
+	}
+	return value, nil
+}
+
+func TestResolverSecret(t *testing.T) {
+	c := newTestCipher(t, 'k')
+	encrypted, err := c.Encrypt("db-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewResolver(c, mapStore{"DB": encrypted, "PLAIN": "plain"})
+	if value, err := resolver.Secret("DB"); err != nil || value != "db-password" {
+		t.Errorf("Secret(DB) = %q, %v", value, err)
+	}
+	if value, err := resolver.Secret("PLAIN"); err != nil || value != "plain" {
+		t.Errorf("Secret(PLAIN) = %q, %v", value, err)
+	}
+	if _, err := resolver.Secret("MISSING"); err == nil {
+		t.Error("Secret(MISSING) succeeded")
+	}
+	if _, err := NewResolver(c, nil).Secret("DB"); err == nil {
+		t.Error("Secret() without store succeeded")
+	}
+}
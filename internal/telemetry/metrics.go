@@ -15,9 +15,59 @@ const (
 	ToolCallOutcomeError ToolCallOutcome = "error"
 )
 
+// CallLimit names the limit of an upstream server a call exceeded.
+type CallLimit string
+
+const (
+	// CallLimitTimeout indicates a call that did not complete within its timeout
+	CallLimitTimeout CallLimit = "timeout"
+	// CallLimitConcurrency indicates a call rejected or timed out while waiting for a free slot
+	CallLimitConcurrency CallLimit = "concurrency"
+	// CallLimitRequestSize indicates a request larger than allowed
+	CallLimitRequestSize CallLimit = "request_size"
+	// CallLimitResponseSize indicates a result larger than allowed
+	CallLimitResponseSize CallLimit = "response_size"
+)
+
+// CircuitState is the state of the circuit breaker of an upstream server.
+type CircuitState string
+
+const (
+	// CircuitClosed indicates a healthy upstream, calls are forwarded
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen indicates a failing upstream, calls fail fast
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen indicates a single call probing the recovery of the upstream
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CacheResult is the result of a lookup in the tool result cache.
+type CacheResult string
+
+const (
+	// CacheHit indicates a tool call answered from the cache
+	CacheHit CacheResult = "hit"
+	// CacheMiss indicates a tool call forwarded to the upstream server
+	CacheMiss CacheResult = "miss"
+)
+
 // CustomMetrics defines the interface for recording custom metrics from mcpjungle.
 // It provides convenience methods for recording metrics related to http server, mcp servers, tools, usage, etc.
 type CustomMetrics interface {
 	// RecordToolCall records a tool invocation, its latency, and its outcome (success or error).
 	RecordToolCall(ctx context.Context, serverName, toolName string, outcome ToolCallOutcome, elapsedTime time.Duration)
+	// RecordCallLimitExceeded records a call to an upstream server rejected for exceeding one of its limits.
+	RecordCallLimitExceeded(ctx context.Context, serverName, method string, limit CallLimit)
+	// RecordCircuitState records the state the circuit breaker of an upstream server switched to.
+	RecordCircuitState(ctx context.Context, serverName string, state CircuitState)
+	// RecordToolCacheLookup records a lookup of a tool result in the cache.
+	RecordToolCacheLookup(ctx context.Context, serverName, toolName string, result CacheResult)
+}
+
+// NewCustomMetrics returns the OpenTelemetry metrics when otel is enabled, the no-op metrics otherwise.
+func NewCustomMetrics(providers *Providers) (CustomMetrics, error) {
+	if !providers.IsEnabled() {
+		return NewNoopCustomMetrics(), nil
+	}
+	return NewOtelCustomMetrics(providers.Meter)
 }
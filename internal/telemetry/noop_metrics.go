@@ -19,3 +19,15 @@ func (m *NoopCustomMetrics) RecordToolCall(
 ) {
 	// No-op
 }
+
+func (m *NoopCustomMetrics) RecordCallLimitExceeded(ctx context.Context, serverName, method string, limit CallLimit) {
+	// No-op
+}
+
+func (m *NoopCustomMetrics) RecordCircuitState(ctx context.Context, serverName string, state CircuitState) {
+	// No-op
+}
+
+func (m *NoopCustomMetrics) RecordToolCacheLookup(ctx context.Context, serverName, toolName string, result CacheResult) {
+	// No-op
+}
@@ -13,6 +13,9 @@ const (
 	labelMCPServerName   = "mcp_server_name"
 	labelToolName        = "tool_name"
 	labelToolCallOutcome = "outcome"
+	labelMethod          = "method"
+	labelCallLimit       = "limit"
+	labelCacheResult     = "result"
 )
 
 const (
@@ -26,6 +29,9 @@ const (
 type OtelCustomMetrics struct {
 	toolCalls       metric.Int64Counter
 	toolCallLatency metric.Float64Histogram
+	limitExceeded   metric.Int64Counter
+	circuitState    metric.Int64Gauge
+	cacheLookups    metric.Int64Counter
 }
 
 // NewOtelCustomMetrics initializes all metric instruments required by MCPJungle.
@@ -55,9 +61,39 @@ func NewOtelCustomMetrics(meter metric.Meter) (CustomMetrics, error) {
 		return nil, fmt.Errorf("failed to create tool latency histogram: %w", err)
 	}
 
+	limitExceeded, err := meter.Int64Counter(
+		"mcpjungle_call_limit_exceeded_total",
+		metric.WithDescription("Total number of upstream calls rejected for exceeding a limit"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create call limit counter: %w", err)
+	}
+
+	circuitState, err := meter.Int64Gauge(
+		"mcpjungle_circuit_state",
+		metric.WithDescription("State of the circuit breaker of upstream servers: 0 closed, 1 half open, 2 open"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create circuit state gauge: %w", err)
+	}
+
+	cacheLookups, err := meter.Int64Counter(
+		"mcpjungle_tool_cache_lookups_total",
+		metric.WithDescription("Total number of tool calls looked up in the result cache, by hit or miss"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tool cache counter: %w", err)
+	}
+
 	return &OtelCustomMetrics{
 		toolCalls:       toolInv,
 		toolCallLatency: toolLat,
+		limitExceeded:   limitExceeded,
+		circuitState:    circuitState,
+		cacheLookups:    cacheLookups,
 	}, nil
 }
 
@@ -73,6 +109,37 @@ func (m *OtelCustomMetrics) RecordToolCall(
 	m.toolCallLatency.Record(ctx, elapsedTime.Seconds(), metric.WithAttributes(attrs...))
 }
 
+func (m *OtelCustomMetrics) RecordCallLimitExceeded(
+	ctx context.Context, mcpServerName, method string, limit CallLimit,
+) {
+	m.limitExceeded.Add(ctx, 1, metric.WithAttributes(
+		attribute.String(labelMCPServerName, boundString(mcpServerName)),
+		attribute.String(labelMethod, boundString(method)),
+		attribute.String(labelCallLimit, string(limit)),
+	))
+}
+
+// circuitStateValues maps the circuit states to the values of the gauge
+var circuitStateValues = map[CircuitState]int64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+func (m *OtelCustomMetrics) RecordCircuitState(ctx context.Context, mcpServerName string, state CircuitState) {
+	m.circuitState.Record(ctx, circuitStateValues[state], metric.WithAttributes(
+		attribute.String(labelMCPServerName, boundString(mcpServerName)),
+	))
+}
+
+func (m *OtelCustomMetrics) RecordToolCacheLookup(ctx context.Context, mcpServerName, toolName string, result CacheResult) {
+	m.cacheLookups.Add(ctx, 1, metric.WithAttributes(
+		attribute.String(labelMCPServerName, boundString(mcpServerName)),
+		attribute.String(labelToolName, boundString(toolName)),
+		attribute.String(labelCacheResult, string(result)),
+	))
+}
+
 // boundString ensures strings are capped at maxLen and not empty.
 func boundString(s string) string {
 	if s == "" {
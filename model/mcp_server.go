@@ -3,6 +3,7 @@ package model
 import (
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"net/url"
 	"time"
 )
 
@@ -10,17 +11,148 @@ type StdioMCPClientConfig struct {
 	Command string            `json:"command"`
 	Env     map[string]string `json:"env"`
 	Args    []string          `json:"args"`
+	Sandbox *StdioSandbox     `json:"sandbox"`
+}
+
+// StdioSandbox isolates the command of a stdio upstream server. Whether a config sets it or not,
+// every instance runs in a working directory of its own, with a minimal env.
+type StdioSandbox struct {
+	// EnvAllowList lists the variables of the gateway env passed to the command, on top of PATH, LANG, LC_ALL and TZ
+	EnvAllowList []string `json:"envAllowList,omitempty"`
+	// CPUSeconds, MemoryMB (address space) and OpenFiles limit the resources of the command, unlimited when 0
+	CPUSeconds uint64 `json:"cpuSeconds,omitempty"`
+	MemoryMB   uint64 `json:"memoryMb,omitempty"`
+	OpenFiles  uint64 `json:"openFiles,omitempty"`
+	// Namespaces runs the command in new user, mount, PID, IPC and UTS namespaces, Linux only
+	Namespaces bool `json:"namespaces,omitempty"`
+	// IsolateNetwork also runs the command in a new network namespace, cutting its network access
+	IsolateNetwork bool `json:"isolateNetwork,omitempty"`
 }
 
 type SSEMCPClientConfig struct {
-	URL     string            `json:"url"`
-	Headers map[string]string `json:"headers"`
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	ForwardHeaders []ForwardHeader   `json:"forwardHeaders"`
+	OAuth          *UpstreamOAuth    `json:"oauth"`
+	TLS            *UpstreamTLS      `json:"tls"`
+	Proxy          string            `json:"proxy"`
 }
 
 type StreamableMCPClientConfig struct {
-	URL     string            `json:"url"`
-	Headers map[string]string `json:"headers"`
-	Timeout time.Duration     `json:"timeout"`
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	ForwardHeaders []ForwardHeader   `json:"forwardHeaders"`
+	OAuth          *UpstreamOAuth    `json:"oauth"`
+	TLS            *UpstreamTLS      `json:"tls"`
+	Proxy          string            `json:"proxy"`
+	Timeout        time.Duration     `json:"timeout"`
+}
+
+// ForwardHeader passes a header of the downstream request through to the upstream server on each call.
+type ForwardHeader struct {
+	// Name of the incoming header
+	Name string `json:"name"`
+	// As renames the header sent upstream, Name when empty
+	As string `json:"as,omitempty"`
+	// Template of the value sent upstream where ${value} stands for the incoming value, e.g. "Bearer ${value}"
+	Template string `json:"template,omitempty"`
+}
+
+// UpstreamOAuth makes the gateway an OAuth client of an SSE or streamable HTTP upstream server.
+// The tokens are obtained with the authorization code flow and refreshed by the gateway.
+type UpstreamOAuth struct {
+	// ClientID registered with the authorization server, the gateway registers itself dynamically when empty
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// AuthServerMetadataURL is discovered from the server URL when empty
+	AuthServerMetadataURL string `json:"authServerMetadataUrl,omitempty"`
+	// RedirectURI defaults to the OAuth callback endpoint of the gateway
+	RedirectURI string `json:"redirectUri,omitempty"`
+}
+
+// UpstreamTLS configures the TLS connections to an SSE or streamable HTTP upstream server.
+// The PEM values are given inline or as ${secret:NAME} references.
+type UpstreamTLS struct {
+	// CA is the bundle of the certificate authorities trusted for the server, the system roots when empty
+	CA string `json:"ca,omitempty"`
+	// ClientCert and ClientKey authenticate the gateway to the server (mTLS)
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	// ServerName overrides the host name verified against the server certificate
+	ServerName string `json:"serverName,omitempty"`
+	// MinVersion is the minimum TLS version, 1.0, 1.1, 1.2 (default) or 1.3
+	MinVersion string `json:"minVersion,omitempty"`
+}
+
+// CallLimits bound the calls the gateway forwards to an upstream server, all its instances together:
+// tool calls, prompt gets and resource reads. Zero values are unlimited.
+type CallLimits struct {
+	// TimeoutSeconds bounds each call, the wait for a free slot included
+	TimeoutSeconds uint64 `json:"timeoutSeconds,omitempty"`
+	// ToolTimeoutSeconds overrides TimeoutSeconds for the named tools
+	ToolTimeoutSeconds map[string]uint64 `json:"toolTimeoutSeconds,omitempty"`
+	// MaxRequestBytes bounds the size of the JSON-RPC messages sent by the clients
+	MaxRequestBytes int64 `json:"maxRequestBytes,omitempty"`
+	// MaxResponseBytes bounds the size of the results returned by the upstream server
+	MaxResponseBytes int64 `json:"maxResponseBytes,omitempty"`
+	// MaxConcurrent bounds the calls in flight, the others wait for a free slot in a queue of MaxQueued calls
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	MaxQueued     int `json:"maxQueued,omitempty"`
+}
+
+// CircuitBreaker stops forwarding calls to an upstream server instance that keeps failing.
+// The calls fail fast while the circuit is open, then a single call probes the server (half open).
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit, 5 when 0
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// OpenSeconds is the time the circuit stays open before probing the server, 30 when 0
+	OpenSeconds uint64 `json:"openSeconds,omitempty"`
+	// Disabled forwards every call whatever the failures
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// RetryPolicy retries the calls to an upstream server failing with a transient network error, after a jittered
+// exponential backoff. Listings, prompt gets and resource reads are retried, tool calls only when opted in.
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts of a call, the first one included, 3 when 0; 1 disables the retries
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialBackoffMs is the backoff before the first retry, 200 when 0, doubled on each retry up to MaxBackoffMs, 2000 when 0
+	InitialBackoffMs uint64 `json:"initialBackoffMs,omitempty"`
+	MaxBackoffMs     uint64 `json:"maxBackoffMs,omitempty"`
+	// Tools lists the tools whose calls are retried, "*" for all of them.
+	// A tool is only retried when the upstream server annotates it idempotent (idempotentHint).
+	Tools []string `json:"tools,omitempty"`
+}
+
+// ToolCache caches the results of the read-only tools of an upstream server, per tool, arguments and caller.
+type ToolCache struct {
+	// TTLSeconds is the lifetime of the cached results, 60 when 0
+	TTLSeconds uint64 `json:"ttlSeconds,omitempty"`
+	// ToolTTLSeconds overrides TTLSeconds for the named tools
+	ToolTTLSeconds map[string]uint64 `json:"toolTtlSeconds,omitempty"`
+	// Tools lists the tools whose results are cached, "*" for all of them.
+	// A tool is only cached when the upstream server annotates it read-only (readOnlyHint).
+	Tools []string `json:"tools,omitempty"`
+}
+
+// Pool strategies selecting the member of a pool serving each call
+const (
+	PoolStrategyRoundRobin    = "round-robin"
+	PoolStrategyLeastInFlight = "least-in-flight"
+)
+
+// UpstreamPool runs several replicas of an upstream server and balances the calls across them.
+// The replicas whose circuit is open are ejected until they recover.
+type UpstreamPool struct {
+	// Endpoints lists the URLs of the replicas of an SSE or streamable HTTP server, on top of URL when set
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Size is the number of instances of a stdio server
+	Size int `json:"size,omitempty"`
+	// Strategy selects the replica serving each call, round-robin (default) or least-in-flight
+	Strategy string `json:"strategy,omitempty"`
+	// HealthCheckSeconds is the interval of the pings checking the replicas, 10 when 0
+	HealthCheckSeconds uint64 `json:"healthCheckSeconds,omitempty"`
 }
 
 type MCPClientType string
@@ -45,18 +177,110 @@ type MCPClientConfig struct {
 	Command string            `json:"command,omitempty"`
 	Args    []string          `json:"args,omitempty"`
 	Env     map[string]string `json:"env,omitempty"`
+	Sandbox *StdioSandbox     `json:"sandbox,omitempty"`
 
 	// SSE or Streamable HTTP
 	URL     string            `json:"url,omitempty"`
 	Headers map[string]string `json:"headers,omitempty"`
 	Timeout time.Duration     `json:"timeout,omitempty"`
+
+	// ForwardHeaders lists the downstream request headers passed through to the upstream server.
+	// The gateway's own credentials are stripped from downstream requests before forwarding.
+	ForwardHeaders []ForwardHeader `json:"forwardHeaders,omitempty"`
+
+	// OAuth authorizes the gateway with the upstream server
+	OAuth *UpstreamOAuth `json:"oauth,omitempty"`
+	// TLS configures the connections to the upstream server
+	TLS *UpstreamTLS `json:"tls,omitempty"`
+	// Proxy is the URL of the HTTP(S) or SOCKS5 proxy the upstream server is reached through
+	Proxy string `json:"proxy,omitempty"`
+
+	// Tags select the server for pre-warming on start
+	Tags []string `json:"tags,omitempty"`
+	// Critical servers are pre-warmed whatever their tags, the gateway is warming until they connected
+	Critical bool `json:"critical,omitempty"`
+
+	// Limits bound the calls forwarded to the upstream server, across its replicas and fallbacks.
+	// The limits of a fallback are ignored.
+	Limits *CallLimits `json:"limits,omitempty"`
+	// CircuitBreaker tunes the circuit breaker of the upstream server
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+	// Retry retries the calls failing with a transient network error
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Cache caches the results of the read-only tools
+	Cache *ToolCache `json:"cache,omitempty"`
+	// Pool balances the calls across several replicas of the upstream server
+	Pool *UpstreamPool `json:"pool,omitempty"`
+	// Fallbacks lists the alternates serving the server, in order, while its own instances are down
+	Fallbacks []MCPClientConfig `json:"fallbacks,omitempty"`
+
+	// LogLevel is the minimum level (debug, info, notice, warning, error, critical, alert, emergency)
+	// of the log messages captured from the upstream server. Empty leaves it to the upstream server.
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// LiteralEnv and LiteralHeaders name the env and header values used as is, without interpolation:
+	// the credentials a user bound to a server template. They are never persisted.
+	LiteralEnv     []string `json:"-"`
+	LiteralHeaders []string `json:"-"`
+}
+
+// RedactedValue replaces secrets in logs and API responses.
+const RedactedValue = "******"
+
+// Redacted returns a copy of the config with the env and header values, the OAuth client secret,
+// the TLS client key and the proxy password of the config and its fallbacks hidden.
+func (c MCPClientConfig) Redacted() MCPClientConfig {
+	if c.Fallbacks != nil {
+		fallbacks := make([]MCPClientConfig, len(c.Fallbacks))
+		for i, fallback := range c.Fallbacks {
+			fallbacks[i] = fallback.Redacted()
+		}
+		c.Fallbacks = fallbacks
+	}
+	c.Env = RedactMap(c.Env)
+	c.Headers = RedactMap(c.Headers)
+	if c.OAuth != nil && c.OAuth.ClientSecret != "" {
+		oauth := *c.OAuth
+		oauth.ClientSecret = RedactedValue
+		c.OAuth = &oauth
+	}
+	if c.TLS != nil && c.TLS.ClientKey != "" {
+		tls := *c.TLS
+		tls.ClientKey = RedactedValue
+		c.TLS = &tls
+	}
+	if proxy, err := url.Parse(c.Proxy); err == nil && proxy.User != nil {
+		c.Proxy = proxy.Redacted()
+	}
+	return c
+}
+
+// RedactMap returns a copy of values with every value hidden.
+func RedactMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(values))
+	for k := range values {
+		redacted[k] = RedactedValue
+	}
+	return redacted
 }
 
+// DefaultUserId owns the servers shared by all users of the gateway.
+const DefaultUserId = "gage"
+
 type McpServer struct {
 	gorm.Model
 
 	UserId       string         `json:"user_id" gorm:"not null;index:idx_user_server,unique"`
 	ServerName   string         `json:"server_name" gorm:"not null;index:idx_user_server,unique"`
 	ServerConfig datatypes.JSON `json:"server_config" gorm:"type:jsonb; not null"`
-	Env          datatypes.JSON `json:"env" gorm:"type:jsonb; default null"`
+	// Env and Headers are layered over the env and headers of ServerConfig,
+	// they also provide the values of the ${VAR} references in it
+	Env     datatypes.JSON `json:"env" gorm:"type:jsonb; default null"`
+	Headers datatypes.JSON `json:"headers" gorm:"type:jsonb; default null"`
+	// TemplateInstance is set on the instances of a server template, whose Env and Headers
+	// are the credentials of a user
+	TemplateInstance bool `json:"-" gorm:"-"`
 }
@@ -0,0 +1,22 @@
+package model
+
+import "gorm.io/gorm"
+
+// OAuthToken holds the client registration and the tokens the gateway uses to call an upstream server
+// on behalf of a user. ClientSecret and Token are encrypted when a master key is configured.
+type OAuthToken struct {
+	gorm.Model
+
+	UserId     string `json:"user_id" gorm:"not null;index:idx_oauth_user_server,unique"`
+	ServerName string `json:"server_name" gorm:"not null;index:idx_oauth_user_server,unique"`
+	// ClientId and ClientSecret were obtained by dynamic client registration,
+	// empty when the server config declares its client
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"-"`
+	// Token is the JSON encoded token, empty until the authorization completes
+	Token string `json:"-"`
+}
+
+func (OAuthToken) TableName() string {
+	return "oauth_tokens"
+}
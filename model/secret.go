@@ -0,0 +1,12 @@
+package model
+
+import "gorm.io/gorm"
+
+// Secret is a named secret referenced by server configs as ${secret:NAME}.
+// Value is encrypted when a master key is configured.
+type Secret struct {
+	gorm.Model
+
+	Name  string `json:"name" gorm:"uniqueIndex;not null"`
+	Value string `json:"-" gorm:"not null"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ServerCatalog holds the tools, prompts, resources and resource templates last listed from the upstream server
+// of a user, serving its cold starts while the gateway connects to it.
+type ServerCatalog struct {
+	gorm.Model
+
+	UserId     string `json:"user_id" gorm:"not null;index:idx_catalog_user_server,unique"`
+	ServerName string `json:"server_name" gorm:"not null;index:idx_catalog_user_server,unique"`
+	// ConfigKey fingerprints the server config the catalog was listed with, the catalog is stale once it changed
+	ConfigKey string         `json:"config_key" gorm:"not null"`
+	Catalog   datatypes.JSON `json:"catalog" gorm:"not null"`
+}
+
+func (ServerCatalog) TableName() string {
+	return "server_catalogs"
+}
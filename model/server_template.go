@@ -0,0 +1,27 @@
+package model
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ServerTemplate is an upstream server shared by many users, each connecting with their own credentials.
+// Its ServerConfig references the credentials as ${VAR}, which the CredentialBinding of a user provides.
+type ServerTemplate struct {
+	gorm.Model
+
+	Name         string         `json:"name" gorm:"uniqueIndex;not null"`
+	Description  string         `json:"description"`
+	ServerConfig datatypes.JSON `json:"server_config" gorm:"type:jsonb; not null"`
+}
+
+// CredentialBinding holds the credentials of a user for a server template.
+// Values are encrypted when a master key is configured.
+type CredentialBinding struct {
+	gorm.Model
+
+	TemplateName string         `json:"template_name" gorm:"not null;index:idx_template_user,unique"`
+	UserId       string         `json:"user_id" gorm:"not null;index:idx_template_user,unique"`
+	Env          datatypes.JSON `json:"env" gorm:"type:jsonb; default null"`
+	Headers      datatypes.JSON `json:"headers" gorm:"type:jsonb; default null"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type UserRole string
+
+const (
+	// UserRoleAdmin can manage the gateway and access every MCP server
+	UserRoleAdmin UserRole = "admin"
+
+	UserRoleUser UserRole = "user"
+)
+
+// User is a human operator of the gateway, as opposed to an McpClient.
+type User struct {
+	gorm.Model
+
+	Username string   `json:"username" gorm:"uniqueIndex;not null"`
+	Role     UserRole `json:"role" gorm:"type:varchar(12);not null"`
+
+	AccessToken string `json:"-" gorm:"unique;not null"`
+}
+
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	if u.Role != UserRoleAdmin && u.Role != UserRoleUser {
+		return fmt.Errorf("invalid user role: %s", u.Role)
+	}
+	return nil
+}
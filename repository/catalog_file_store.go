@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/utils"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// CatalogFileStore persists the server catalogs as JSON files of a directory, one per user and server.
+// It backs the config file mode, which has no database.
+type CatalogFileStore struct {
+	dir string
+}
+
+func NewCatalogFileStore(dir string) (*CatalogFileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &CatalogFileStore{dir: dir}, nil
+}
+
+func (s *CatalogFileStore) path(userId, serverName string) string {
+	return filepath.Join(s.dir, utils.Md5String(userId+"\n"+serverName)+".json")
+}
+
+// UpsertServerCatalog writes the catalog to a temporary file renamed over the previous one,
+// so that a concurrent reader never sees a partial file.
+func (s *CatalogFileStore) UpsertServerCatalog(catalog *model.ServerCatalog) error {
+	data, err := sonic.Marshal(catalog)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(s.dir, "catalog-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path(catalog.UserId, catalog.ServerName))
+}
+
+func (s *CatalogFileStore) GetServerCatalog(userId, serverName string) (*model.ServerCatalog, error) {
+	data, err := os.ReadFile(s.path(userId, serverName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrServerCatalogNotFound, serverName)
+		}
+		return nil, err
+	}
+	var found model.ServerCatalog
+	if err := sonic.Unmarshal(data, &found); err != nil {
+		return nil, err
+	}
+	return &found, nil
+}
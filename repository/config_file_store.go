@@ -0,0 +1,362 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"gorm.io/datatypes"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const configFilePollInterval = 2 * time.Second
+
+// ConfigFile is the declarative configuration of the gateway.
+// Upstream servers are declared in the `mcpServers` format used by Claude Desktop.
+// Both YAML and JSON files are accepted, JSON being a subset of YAML.
+type ConfigFile struct {
+	// Mode is development or production, production when empty.
+	// A config file is its own initialization, there is no init step in this mode.
+	Mode       model.ServerMode            `yaml:"mode"`
+	McpServers map[string]ConfigFileServer `yaml:"mcpServers"`
+	Clients    map[string]ConfigFileClient `yaml:"clients"`
+	// Secrets are referenced by the servers as ${secret:NAME}, their values may be encrypted
+	Secrets map[string]string `yaml:"secrets"`
+}
+
+// ConfigFileServer is the entry of an upstream server. It is decoded as a model.MCPClientConfig, so that it accepts
+// every field of a server config, with two conveniences of the mcpServers format: `type` selects the transport,
+// stdio, sse or streamable_http, inferred when empty, and `timeout` may be a duration such as 30s.
+type ConfigFileServer map[string]any
+
+type ConfigFileClient struct {
+	Description string   `yaml:"description"`
+	AccessToken string   `yaml:"accessToken"`
+	AllowList   []string `yaml:"allowList"`
+}
+
+func (s ConfigFileServer) clientConfig() (*model.MCPClientConfig, error) {
+	entry, err := s.normalize()
+	if err != nil {
+		return nil, err
+	}
+	data, err := sonic.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	conf := &model.MCPClientConfig{}
+	if err := sonic.Unmarshal(data, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// normalize returns a copy of the entry and of its fallbacks in the JSON form of model.MCPClientConfig.
+func (s ConfigFileServer) normalize() (map[string]any, error) {
+	entry := maps.Clone(map[string]any(s))
+	if serverType, ok := entry["type"]; ok {
+		delete(entry, "type")
+		name, isString := serverType.(string)
+		if !isString && serverType != nil {
+			return nil, fmt.Errorf("unknown server type: %v", serverType)
+		}
+		switch strings.ToLower(name) {
+		case "":
+		case "stdio":
+			entry["transportType"] = model.MCPClientTypeStdio
+		case "sse":
+			entry["transportType"] = model.MCPClientTypeSSE
+		case "streamable_http", "streamable-http", "http":
+			entry["transportType"] = model.MCPClientTypeStreamable
+		default:
+			return nil, fmt.Errorf("unknown server type: %v", serverType)
+		}
+	}
+	// e.g. PORT: 8080, the scalars of the string values are taken as strings
+	for _, key := range []string{"env", "headers"} {
+		if values, ok := asMap(entry[key]); ok {
+			formatted := make(map[string]any, len(values))
+			for name, value := range values {
+				formatted[name] = scalarString(value)
+			}
+			entry[key] = formatted
+		}
+	}
+	if args, ok := entry["args"].([]any); ok {
+		formatted := make([]any, len(args))
+		for i, arg := range args {
+			formatted[i] = scalarString(arg)
+		}
+		entry["args"] = formatted
+	}
+	if timeout, ok := entry["timeout"].(string); ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		entry["timeout"] = d
+	}
+	if fallbacks, ok := entry["fallbacks"].([]any); ok {
+		normalized := make([]any, len(fallbacks))
+		for i, fallback := range fallbacks {
+			fallbackEntry, ok := asMap(fallback)
+			if !ok {
+				return nil, errors.New("fallback: not a server entry")
+			}
+			var err error
+			if normalized[i], err = ConfigFileServer(fallbackEntry).normalize(); err != nil {
+				return nil, fmt.Errorf("fallback: %w", err)
+			}
+		}
+		entry["fallbacks"] = normalized
+	}
+	return entry, nil
+}
+
+// asMap returns the mapping of a value, yaml decodes the nested mappings of a ConfigFileServer as ConfigFileServer.
+func asMap(value any) (map[string]any, bool) {
+	switch value := value.(type) {
+	case ConfigFileServer:
+		return value, true
+	case map[string]any:
+		return value, true
+	}
+	return nil, false
+}
+
+// scalarString formats the numbers and booleans of the string values as strings.
+func scalarString(value any) any {
+	switch value.(type) {
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+// ConfigFileStore serves the upstream servers and clients declared in a config file.
+// The file is watched for changes and reloaded in place.
+type ConfigFileStore struct {
+	path   string
+	logger *zap.Logger
+
+	mu       sync.RWMutex
+	mode     model.ServerMode
+	servers  map[string]*model.McpServer
+	clients  map[string]*model.McpClient
+	secrets  map[string]string
+	modTime  time.Time
+	size     int64
+	watchers []func(serverNames []string)
+}
+
+func NewConfigFileStore(ctx context.Context, cliCtx *cli.Context, logger *zap.Logger) (*ConfigFileStore, error) {
+	s := &ConfigFileStore{
+		path:   cliCtx.String("config"),
+		logger: logger,
+	}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	go s.poll(ctx)
+	return s, nil
+}
+
+// GetMcpServer returns the server declared under serverName.
+// Servers declared in a config file are shared by all users, so userId is ignored.
+func (s *ConfigFileStore) GetMcpServer(userId, serverName string) (*model.McpServer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	server, ok := s.servers[serverName]
+	if !ok {
+		return nil, fmt.Errorf("mcp server %s not found", serverName)
+	}
+	return server, nil
+}
+
+// ListMcpServers returns the servers declared in the config file, sorted by name.
+func (s *ConfigFileStore) ListMcpServers() ([]*model.McpServer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	servers := make([]*model.McpServer, 0, len(s.servers))
+	for _, server := range s.servers {
+		servers = append(servers, server)
+	}
+	slices.SortFunc(servers, func(a, b *model.McpServer) int { return cmp.Compare(a.ServerName, b.ServerName) })
+	return servers, nil
+}
+
+func (s *ConfigFileStore) GetClientByToken(token string) (*model.McpClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[token]
+	if !ok {
+		return nil, errors.New("client not found")
+	}
+	return client, nil
+}
+
+func (s *ConfigFileStore) IsServerAllowed(client *model.McpClient, serverName string) (bool, error) {
+	return allowListContains(client, serverName)
+}
+
+func (s *ConfigFileStore) GetSecret(name string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", name)
+	}
+	return value, nil
+}
+
+func (s *ConfigFileStore) GetServerConfig() (*model.ServerConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &model.ServerConfig{Mode: s.mode, Initialized: true}, nil
+}
+
+func (s *ConfigFileStore) Initialize(mode model.ServerMode) (*model.ServerConfig, *model.User, error) {
+	return nil, nil, ErrAlreadyInitialized
+}
+
+// GetUserByToken always fails, users are not supported in config file mode.
+func (s *ConfigFileStore) GetUserByToken(token string) (*model.User, error) {
+	return nil, errors.New("user not found")
+}
+
+func (s *ConfigFileStore) Watch(onChange func(serverNames []string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, onChange)
+}
+
+func (s *ConfigFileStore) poll(ctx context.Context) {
+	ticker := time.NewTicker(configFilePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				s.logger.Warn("Stat config file failed", zap.String("path", s.path), zap.Error(err))
+				continue
+			}
+			s.mu.RLock()
+			unchanged := info.ModTime().Equal(s.modTime) && info.Size() == s.size
+			s.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+			changed, err := s.reload()
+			if err != nil {
+				// keep serving the last valid configuration
+				s.logger.Error("Reload config file failed", zap.String("path", s.path), zap.Error(err))
+				continue
+			}
+			s.logger.Info("Config file reloaded", zap.String("path", s.path), zap.Strings("changedServers", changed))
+			if len(changed) > 0 {
+				s.mu.RLock()
+				watchers := s.watchers
+				s.mu.RUnlock()
+				for _, onChange := range watchers {
+					onChange(changed)
+				}
+			}
+		}
+	}
+}
+
+// reload parses the config file and swaps it in, returning the names of the servers
+// whose entries changed or disappeared.
+func (s *ConfigFileStore) reload() ([]string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var configFile ConfigFile
+	if err := yaml.Unmarshal(data, &configFile); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", s.path, err)
+	}
+	switch configFile.Mode {
+	case "":
+		configFile.Mode = model.ModeProd
+	case model.ModeDev, model.ModeProd:
+	default:
+		return nil, fmt.Errorf("invalid server mode: %s", configFile.Mode)
+	}
+
+	servers := make(map[string]*model.McpServer, len(configFile.McpServers))
+	for name, entry := range configFile.McpServers {
+		conf, err := entry.clientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid mcp server %s: %w", name, err)
+		}
+		serverConfig, err := sonic.Marshal(conf)
+		if err != nil {
+			return nil, err
+		}
+		servers[name] = &model.McpServer{
+			// the servers of a config file are shared by all users
+			UserId:       model.DefaultUserId,
+			ServerName:   name,
+			ServerConfig: datatypes.JSON(serverConfig),
+		}
+	}
+
+	clients := make(map[string]*model.McpClient, len(configFile.Clients))
+	for name, entry := range configFile.Clients {
+		if entry.AccessToken == "" {
+			return nil, fmt.Errorf("client %s has no access token", name)
+		}
+		if _, ok := clients[entry.AccessToken]; ok {
+			return nil, fmt.Errorf("client %s reuses the access token of another client", name)
+		}
+		if entry.AllowList == nil {
+			entry.AllowList = []string{}
+		}
+		allowList, err := sonic.Marshal(entry.AllowList)
+		if err != nil {
+			return nil, err
+		}
+		clients[entry.AccessToken] = &model.McpClient{
+			Name:        name,
+			Description: entry.Description,
+			AccessToken: entry.AccessToken,
+			AllowList:   datatypes.JSON(allowList),
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []string
+	// servers may reference any secret, rebuild them all when secrets change
+	secretsChanged := !maps.Equal(s.secrets, configFile.Secrets)
+	for name, old := range s.servers {
+		if server, ok := servers[name]; !ok || secretsChanged || string(server.ServerConfig) != string(old.ServerConfig) {
+			changed = append(changed, name)
+		}
+	}
+	s.mode = configFile.Mode
+	s.servers = servers
+	s.clients = clients
+	s.secrets = configFile.Secrets
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return changed, nil
+}
@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func parseTestServer(t *testing.T, data string) ConfigFileServer {
+	t.Helper()
+	var server ConfigFileServer
+	if err := yaml.Unmarshal([]byte(data), &server); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestConfigFileServerTLS(t *testing.T) {
+	server := parseTestServer(t, `
+type: streamable_http
+url: https://mcp.internal/mcp
+tls:
+  ca: ${secret:CA}
+  clientCert: ${secret:CERT}
+  clientKey: ${secret:KEY}
+  serverName: mcp.internal
+  minVersion: "1.3"
+fallbacks:
+  - url: https://mcp-backup.internal/mcp
+    tls:
+      ca: ${secret:BACKUP_CA}
+`)
+	conf, err := server.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.TLS == nil {
+		t.Fatal("tls not mapped")
+	}
+	if conf.TLS.CA != "${secret:CA}" || conf.TLS.ClientCert != "${secret:CERT}" || conf.TLS.ClientKey != "${secret:KEY}" ||
+		conf.TLS.ServerName != "mcp.internal" || conf.TLS.MinVersion != "1.3" {
+		t.Errorf("tls = %+v", conf.TLS)
+	}
+	if len(conf.Fallbacks) != 1 || conf.Fallbacks[0].TLS == nil || conf.Fallbacks[0].TLS.CA != "${secret:BACKUP_CA}" {
+		t.Errorf("fallback tls not mapped: %+v", conf.Fallbacks)
+	}
+}
+
+func TestConfigFileServerProxy(t *testing.T) {
+	server := parseTestServer(t, `
+type: sse
+url: https://mcp.example.com/sse
+proxy: socks5://10.20.0.5:1080
+`)
+	conf, err := server.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Proxy != "socks5://10.20.0.5:1080" {
+		t.Errorf("proxy = %q", conf.Proxy)
+	}
+}
+
+func TestConfigFileServerSandbox(t *testing.T) {
+	server := parseTestServer(t, `
+command: npx
+args: ["-y", "@modelcontextprotocol/server-filesystem", "/data"]
+sandbox:
+  envAllowList: [NPM_CONFIG_REGISTRY]
+  cpuSeconds: 600
+  memoryMb: 4096
+  openFiles: 256
+  namespaces: true
+  isolateNetwork: true
+`)
+	conf, err := server.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandbox := conf.Sandbox
+	if sandbox == nil {
+		t.Fatal("sandbox not mapped")
+	}
+	if len(sandbox.EnvAllowList) != 1 || sandbox.EnvAllowList[0] != "NPM_CONFIG_REGISTRY" || sandbox.CPUSeconds != 600 ||
+		sandbox.MemoryMB != 4096 || sandbox.OpenFiles != 256 || !sandbox.Namespaces || !sandbox.IsolateNetwork {
+		t.Errorf("sandbox = %+v", sandbox)
+	}
+}
+
+func TestConfigFileServerOAuth(t *testing.T) {
+	server := parseTestServer(t, `
+type: streamable_http
+url: https://mcp.example.com/mcp
+oauth:
+  clientId: gateway
+  clientSecret: ${secret:OAUTH_SECRET}
+  scopes: [read, write]
+`)
+	conf, err := server.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.OAuth == nil || conf.OAuth.ClientID != "gateway" || conf.OAuth.ClientSecret != "${secret:OAUTH_SECRET}" || len(conf.OAuth.Scopes) != 2 {
+		t.Errorf("oauth = %+v", conf.OAuth)
+	}
+}
+
+func TestConfigFileServerTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		want    model.MCPClientType
+		wantErr bool
+	}{
+		{"inferred", `command: uvx`, "", false},
+		{"stdio", `{type: stdio, command: uvx}`, model.MCPClientTypeStdio, false},
+		{"sse", `{type: SSE, url: "https://mcp.example.com/sse"}`, model.MCPClientTypeSSE, false},
+		{"streamable http", `{type: streamable_http, url: "https://mcp.example.com/mcp"}`, model.MCPClientTypeStreamable, false},
+		{"transport type", `{transportType: streamable-http, url: "https://mcp.example.com/mcp"}`, model.MCPClientTypeStreamable, false},
+		{"unknown", `{type: websocket, url: "wss://mcp.example.com"}`, "", true},
+		{"unknown fallback", `{url: "https://mcp.example.com/mcp", fallbacks: [{type: websocket}]}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := parseTestServer(t, tt.entry).clientConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && conf.TransportType != tt.want {
+				t.Errorf("transport type = %q, want %q", conf.TransportType, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFileServerValues(t *testing.T) {
+	server := parseTestServer(t, `
+command: server
+args: [--port, 8080, --verbose, true]
+env:
+  PORT: 8080
+  DEBUG: false
+timeout: 30s
+fallbacks:
+  - url: https://mcp-backup.example.com/mcp
+    timeout: 1m
+`)
+	conf, err := server.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(conf.Args, []string{"--port", "8080", "--verbose", "true"}) {
+		t.Errorf("args = %q", conf.Args)
+	}
+	if conf.Env["PORT"] != "8080" || conf.Env["DEBUG"] != "false" {
+		t.Errorf("env = %v", conf.Env)
+	}
+	if conf.Timeout != 30*time.Second || len(conf.Fallbacks) != 1 || conf.Fallbacks[0].Timeout != time.Minute {
+		t.Errorf("timeout = %s, fallbacks = %+v", conf.Timeout, conf.Fallbacks)
+	}
+	if _, err := parseTestServer(t, `{url: "https://mcp.example.com/mcp", timeout: soon}`).clientConfig(); err == nil {
+		t.Error("clientConfig() accepted an invalid timeout")
+	}
+}
+
+func TestConfigFileServersAreShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte("mcpServers:\n  fetch:\n    command: uvx\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &ConfigFileStore{path: path, logger: zap.NewNop()}
+	if _, err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	server, err := s.GetMcpServer("user:alice", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.UserId != model.DefaultUserId {
+		t.Errorf("user id = %q, want %q", server.UserId, model.DefaultUserId)
+	}
+}
+
+func TestConfigFileStoreReloadChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &ConfigFileStore{path: path, logger: zap.NewNop()}
+	write(`
+mcpServers:
+  fetch: {command: uvx, args: [mcp-server-fetch]}
+  time: {command: uvx, args: [mcp-server-time]}
+  git: {command: uvx, args: [mcp-server-git]}
+`)
+	if _, err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"unchanged", `
+mcpServers:
+  fetch: {command: uvx, args: [mcp-server-fetch]}
+  time: {command: uvx, args: [mcp-server-time]}
+  git: {command: uvx, args: [mcp-server-git]}
+`, nil},
+		{"changed and removed", `
+mcpServers:
+  fetch: {command: uvx, args: [mcp-server-fetch, --ignore-robots-txt]}
+  time: {command: uvx, args: [mcp-server-time]}
+`, []string{"fetch", "git"}},
+		{"added", `
+mcpServers:
+  fetch: {command: uvx, args: [mcp-server-fetch, --ignore-robots-txt]}
+  time: {command: uvx, args: [mcp-server-time]}
+  git: {command: uvx, args: [mcp-server-git]}
+`, nil},
+		{"secrets changed", `
+secrets:
+  TOKEN: s3cr3t
+mcpServers:
+  fetch: {command: uvx, args: [mcp-server-fetch, --ignore-robots-txt]}
+  time: {command: uvx, args: [mcp-server-time]}
+`, []string{"fetch", "git", "time"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.data)
+			changed, err := s.reload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(changed)
+			if !slices.Equal(changed, tt.want) {
+				t.Errorf("changed = %q, want %q", changed, tt.want)
+			}
+		})
+	}
+
+	// an invalid config keeps the last valid one
+	write("mcpServers:\n  fetch: {type: websocket}\n")
+	if _, err := s.reload(); err == nil {
+		t.Fatal("reload() accepted an invalid config")
+	}
+	if _, err := s.GetMcpServer(model.DefaultUserId, "time"); err != nil {
+		t.Errorf("last valid config dropped: %v", err)
+	}
+}
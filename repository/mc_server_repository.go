@@ -1,34 +1,182 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"github.com/bytedance/sonic"
+	"github.com/tomeai/mcp-gateway/internal/db"
+	"github.com/tomeai/mcp-gateway/internal/secret"
 	"github.com/tomeai/mcp-gateway/model"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 type McpServerService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	cipher *secret.Cipher
 }
 
-func NewMcpServerService(db *gorm.DB) *McpServerService {
-	return &McpServerService{db: db}
+func NewMcpServerService(db *gorm.DB, cipher *secret.Cipher) *McpServerService {
+	return &McpServerService{db: db, cipher: cipher}
 }
 
+// UpsertMcpServer stores the server with the env and header values of its config encrypted.
 func (ms *McpServerService) UpsertMcpServer(server *model.McpServer) error {
+	if err := encryptMcpServer(ms.cipher, server); err != nil {
+		return err
+	}
 	return ms.db.Clauses(clause.OnConflict{
 		Columns: []clause.Column{{Name: "user_id"}, {Name: "server_name"}},
 		DoUpdates: clause.Assignments(map[string]interface{}{
 			"server_config": server.ServerConfig,
-			"updated_at":    gorm.Expr("NOW()"),
+			"env":           server.Env,
+			"headers":       server.Headers,
+			"updated_at":    gorm.Expr("CURRENT_TIMESTAMP"),
 		}),
 	}).Create(server).Error
 }
 
+// ListMcpServers returns the servers registered by all the users, the instances of the server templates excluded.
+func (ms *McpServerService) ListMcpServers() ([]*model.McpServer, error) {
+	var servers []*model.McpServer
+	if err := ms.db.Order("server_name, user_id").Find(&servers).Error; err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// CheckHealth pings the database and checks that its schema is at the version this build expects.
+func (ms *McpServerService) CheckHealth(ctx context.Context) error {
+	sqlDB, err := ms.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+	return db.NewMigrator(ms.db.WithContext(ctx)).CheckVersion()
+}
+
+// GetMcpServer returns the server registered by the user, or else the instance of the server template
+// of that name bound to the credentials of the user, or else the server shared by all users.
+// Secrets are returned as stored, they are only decrypted when the client of the server is built.
 func (ms *McpServerService) GetMcpServer(userId, serverName string) (*model.McpServer, error) {
 	var server model.McpServer
 	err := ms.db.Where("user_id = ? AND server_name = ?", userId, serverName).First(&server).Error
+	if err == nil {
+		return &server, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var template model.ServerTemplate
+	err = ms.db.Where("name = ?", serverName).First(&template).Error
+	if err == nil {
+		var binding model.CredentialBinding
+		err = ms.db.Where("template_name = ? AND user_id = ?", serverName, userId).First(&binding).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoCredentialBinding
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &model.McpServer{
+			UserId:           userId,
+			ServerName:       serverName,
+			ServerConfig:     template.ServerConfig,
+			Env:              binding.Env,
+			Headers:          binding.Headers,
+			TemplateInstance: true,
+		}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) || userId == model.DefaultUserId {
+		return nil, err
+	}
+
+	err = ms.db.Where("user_id = ? AND server_name = ?", model.DefaultUserId, serverName).First(&server).Error
 	if err != nil {
 		return nil, err
 	}
 	return &server, nil
 }
+
+// encryptMcpServer encrypts the env and header values of the server config and the server in place.
+func encryptMcpServer(cipher *secret.Cipher, server *model.McpServer) error {
+	if cipher == nil {
+		return nil
+	}
+	var err error
+	if server.ServerConfig, err = encryptServerConfig(cipher, server.ServerConfig); err != nil {
+		return err
+	}
+	if server.Env, err = encryptJSONMap(cipher, server.Env); err != nil {
+		return err
+	}
+	server.Headers, err = encryptJSONMap(cipher, server.Headers)
+	return err
+}
+
+// encryptServerConfig encrypts the env and header values, the OAuth client secret and the TLS client key
+// of a server config and its fallbacks.
+func encryptServerConfig(cipher *secret.Cipher, data datatypes.JSON) (datatypes.JSON, error) {
+	var conf model.MCPClientConfig
+	if err := sonic.Unmarshal(data, &conf); err != nil {
+		return nil, err
+	}
+	if err := encryptClientConfig(cipher, &conf); err != nil {
+		return nil, err
+	}
+	encrypted, err := sonic.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(encrypted), nil
+}
+
+func encryptClientConfig(cipher *secret.Cipher, conf *model.MCPClientConfig) error {
+	var err error
+	if conf.Env, err = cipher.EncryptMap(conf.Env); err != nil {
+		return err
+	}
+	if conf.Headers, err = cipher.EncryptMap(conf.Headers); err != nil {
+		return err
+	}
+	if conf.OAuth != nil && conf.OAuth.ClientSecret != "" {
+		if conf.OAuth.ClientSecret, err = cipher.Encrypt(conf.OAuth.ClientSecret); err != nil {
+			return err
+		}
+	}
+	if conf.TLS != nil && conf.TLS.ClientKey != "" {
+		if conf.TLS.ClientKey, err = cipher.Encrypt(conf.TLS.ClientKey); err != nil {
+			return err
+		}
+	}
+	for i := range conf.Fallbacks {
+		if err := encryptClientConfig(cipher, &conf.Fallbacks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptJSONMap encrypts the values of a JSON object of strings.
+func encryptJSONMap(cipher *secret.Cipher, data datatypes.JSON) (datatypes.JSON, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	var values map[string]string
+	if err := sonic.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	values, err := cipher.EncryptMap(values)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := sonic.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(encrypted), nil
+}
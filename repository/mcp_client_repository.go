@@ -26,3 +26,7 @@ func (m *McpClientService) GetClientByToken(token string) (*model.McpClient, err
 	}
 	return &client, nil
 }
+
+func (m *McpClientService) IsServerAllowed(client *model.McpClient, serverName string) (bool, error) {
+	return allowListContains(client, serverName)
+}
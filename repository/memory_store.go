@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"sync"
+)
+
+// MemoryStore is a thread-safe, in-memory implementation of the server, client, ACL, OAuth token
+// and server catalog repositories.
+// It is meant for tests and other setups that do not need the data to survive a restart.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// userId -> serverName -> server
+	servers map[string]map[string]*model.McpServer
+	// access token -> client
+	clients map[string]*model.McpClient
+	// userId -> serverName -> token
+	oauthTokens map[string]map[string]*model.OAuthToken
+	// userId -> serverName -> catalog
+	catalogs map[string]map[string]*model.ServerCatalog
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		servers:     make(map[string]map[string]*model.McpServer),
+		clients:     make(map[string]*model.McpClient),
+		oauthTokens: make(map[string]map[string]*model.OAuthToken),
+		catalogs:    make(map[string]map[string]*model.ServerCatalog),
+	}
+}
+
+func (s *MemoryStore) UpsertMcpServer(server *model.McpServer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userServers, ok := s.servers[server.UserId]
+	if !ok {
+		userServers = make(map[string]*model.McpServer)
+		s.servers[server.UserId] = userServers
+	}
+	stored := *server
+	userServers[server.ServerName] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetMcpServer(userId, serverName string) (*model.McpServer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	server, ok := s.servers[userId][serverName]
+	if !ok {
+		return nil, fmt.Errorf("mcp server %s not found", serverName)
+	}
+	found := *server
+	return &found, nil
+}
+
+func (s *MemoryStore) ListMcpServers() ([]*model.McpServer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var servers []*model.McpServer
+	for _, userServers := range s.servers {
+		for _, server := range userServers {
+			found := *server
+			servers = append(servers, &found)
+		}
+	}
+	return servers, nil
+}
+
+func (s *MemoryStore) DeleteMcpServer(userId, serverName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.servers[userId], serverName)
+}
+
+func (s *MemoryStore) CreateClient(client *model.McpClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client.AccessToken]; ok {
+		return errors.New("access token already in use")
+	}
+	stored := *client
+	s.clients[client.AccessToken] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetClientByToken(token string) (*model.McpClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[token]
+	if !ok {
+		return nil, errors.New("client not found")
+	}
+	found := *client
+	return &found, nil
+}
+
+func (s *MemoryStore) IsServerAllowed(client *model.McpClient, serverName string) (bool, error) {
+	return allowListContains(client, serverName)
+}
+
+func (s *MemoryStore) UpsertOAuthToken(token *model.OAuthToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userTokens, ok := s.oauthTokens[token.UserId]
+	if !ok {
+		userTokens = make(map[string]*model.OAuthToken)
+		s.oauthTokens[token.UserId] = userTokens
+	}
+	stored := *token
+	userTokens[token.ServerName] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetOAuthToken(userId, serverName string) (*model.OAuthToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	token, ok := s.oauthTokens[userId][serverName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrOAuthTokenNotFound, serverName)
+	}
+	found := *token
+	return &found, nil
+}
+
+func (s *MemoryStore) UpsertServerCatalog(catalog *model.ServerCatalog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userCatalogs, ok := s.catalogs[catalog.UserId]
+	if !ok {
+		userCatalogs = make(map[string]*model.ServerCatalog)
+		s.catalogs[catalog.UserId] = userCatalogs
+	}
+	stored := *catalog
+	userCatalogs[catalog.ServerName] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetServerCatalog(userId, serverName string) (*model.ServerCatalog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	catalog, ok := s.catalogs[userId][serverName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrServerCatalogNotFound, serverName)
+	}
+	found := *catalog
+	return &found, nil
+}
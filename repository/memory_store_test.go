@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStoreServers(t *testing.T) {
+	s := NewMemoryStore()
+	server := &model.McpServer{UserId: "user:alice", ServerName: "github", ServerConfig: []byte(`{"url":"https://example.com"}`)}
+	if err := s.UpsertMcpServer(server); err != nil {
+		t.Fatal(err)
+	}
+	// the store keeps its own copy
+	server.ServerName = "changed"
+	found, err := s.GetMcpServer("user:alice", "github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found.UserId = "user:bob"
+	if again, _ := s.GetMcpServer("user:alice", "github"); again.UserId != "user:alice" {
+		t.Error("GetMcpServer() returned the stored server")
+	}
+
+	// the servers of a user are not visible to the others
+	if _, err := s.GetMcpServer("user:bob", "github"); err == nil {
+		t.Error("GetMcpServer() found the server of another user")
+	}
+	if _, err := s.GetMcpServer(model.DefaultUserId, "github"); err == nil {
+		t.Error("GetMcpServer() found a user server as a shared one")
+	}
+
+	if err := s.UpsertMcpServer(&model.McpServer{UserId: "user:alice", ServerName: "github", ServerConfig: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := s.ListMcpServers(); len(servers) != 1 || string(servers[0].ServerConfig) != `{}` {
+		t.Errorf("ListMcpServers() after an update = %v", servers)
+	}
+	s.DeleteMcpServer("user:alice", "github")
+	if servers, _ := s.ListMcpServers(); len(servers) != 0 {
+		t.Errorf("ListMcpServers() after a delete = %v", servers)
+	}
+}
+
+func TestMemoryStoreClients(t *testing.T) {
+	s := NewMemoryStore()
+	client := &model.McpClient{Name: "ci", AccessToken: "token", AllowList: []byte(`["github"]`)}
+	if err := s.CreateClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateClient(&model.McpClient{Name: "other", AccessToken: "token"}); err == nil {
+		t.Error("CreateClient() reused an access token")
+	}
+	if _, err := s.GetClientByToken("wrong"); err == nil {
+		t.Error("GetClientByToken() found an unknown token")
+	}
+	found, err := s.GetClientByToken("token")
+	if err != nil || found.Name != "ci" {
+		t.Fatalf("GetClientByToken() = %v, %v", found, err)
+	}
+
+	tests := []struct {
+		name      string
+		allowList string
+		server    string
+		want      bool
+	}{
+		{"listed", `["github","slack"]`, "slack", true},
+		{"not listed", `["github"]`, "slack", false},
+		{"empty list", `[]`, "github", false},
+		{"no list", ``, "github", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := s.IsServerAllowed(&model.McpClient{AllowList: []byte(tt.allowList)}, tt.server)
+			if err != nil || allowed != tt.want {
+				t.Errorf("IsServerAllowed() = %v, %v, want %v", allowed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	s := NewMemoryStore()
+	if err := s.UpsertOAuthToken(&model.OAuthToken{UserId: "user:alice", ServerName: "github"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpsertServerCatalog(&model.ServerCatalog{UserId: "user:alice", ServerName: "github"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetOAuthToken("user:alice", "github"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetServerCatalog("user:alice", "github"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetOAuthToken("user:bob", "github"); !errors.Is(err, ErrOAuthTokenNotFound) {
+		t.Errorf("GetOAuthToken() error = %v, want ErrOAuthTokenNotFound", err)
+	}
+	if _, err := s.GetServerCatalog("user:bob", "github"); !errors.Is(err, ErrServerCatalogNotFound) {
+		t.Errorf("GetServerCatalog() error = %v, want ErrServerCatalogNotFound", err)
+	}
+}
+
+func TestMemoryStoreConcurrentAccess(t *testing.T) {
+	s := NewMemoryStore()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userId := fmt.Sprintf("user:%d", i)
+			for j := range 50 {
+				name := fmt.Sprintf("server-%d", j)
+				if err := s.UpsertMcpServer(&model.McpServer{UserId: userId, ServerName: name}); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.GetMcpServer(userId, name); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.ListMcpServers(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if servers, _ := s.ListMcpServers(); len(servers) != 8*50 {
+		t.Errorf("ListMcpServers() = %d servers, want %d", len(servers), 8*50)
+	}
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OAuthTokenService struct {
+	db     *gorm.DB
+	cipher *secret.Cipher
+}
+
+func NewOAuthTokenService(db *gorm.DB, cipher *secret.Cipher) *OAuthTokenService {
+	return &OAuthTokenService{db: db, cipher: cipher}
+}
+
+// UpsertOAuthToken stores the client registration and the token encrypted,
+// replacing those of the same user and server.
+func (s *OAuthTokenService) UpsertOAuthToken(token *model.OAuthToken) error {
+	stored := *token
+	var err error
+	if stored.ClientSecret != "" {
+		if stored.ClientSecret, err = s.cipher.Encrypt(stored.ClientSecret); err != nil {
+			return err
+		}
+	}
+	if stored.Token != "" {
+		if stored.Token, err = s.cipher.Encrypt(stored.Token); err != nil {
+			return err
+		}
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "server_name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"client_id":     stored.ClientId,
+			"client_secret": stored.ClientSecret,
+			"token":         stored.Token,
+			"updated_at":    gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(&stored).Error
+}
+
+// GetOAuthToken returns the client registration and the token as stored, encrypted when a master key is configured.
+func (s *OAuthTokenService) GetOAuthToken(userId, serverName string) (*model.OAuthToken, error) {
+	var found model.OAuthToken
+	if err := s.db.Where("user_id = ? AND server_name = ?", userId, serverName).First(&found).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrOAuthTokenNotFound, serverName)
+		}
+		return nil, err
+	}
+	return &found, nil
+}
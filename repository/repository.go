@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/bytedance/sonic"
+	"github.com/tomeai/mcp-gateway/model"
+)
+
+// McpServerRepository is the source of truth for the upstream MCP servers registered in the gateway.
+type McpServerRepository interface {
+	GetMcpServer(userId, serverName string) (*model.McpServer, error)
+}
+
+// McpServerLister is implemented by server repositories that can list the servers registered.
+type McpServerLister interface {
+	ListMcpServers() ([]*model.McpServer, error)
+}
+
+// HealthChecker is implemented by server repositories backed by a database, which the gateway needs to serve.
+type HealthChecker interface {
+	// CheckHealth returns an error unless the database is reachable and its schema up to date.
+	CheckHealth(ctx context.Context) error
+}
+
+// McpServerWriter is implemented by server repositories that can register servers.
+type McpServerWriter interface {
+	UpsertMcpServer(server *model.McpServer) error
+}
+
+// ServerTemplateRepository is implemented by server repositories supporting templates.
+// It manages the server templates and the credentials users bind to them.
+type ServerTemplateRepository interface {
+	UpsertServerTemplate(template *model.ServerTemplate) error
+	GetServerTemplate(name string) (*model.ServerTemplate, error)
+	UpsertCredentialBinding(binding *model.CredentialBinding) error
+}
+
+// McpServerWatcher is implemented by server repositories that can report changes of their entries.
+type McpServerWatcher interface {
+	// Watch registers a callback invoked with the names of the servers whose entries changed or were removed.
+	Watch(onChange func(serverNames []string))
+}
+
+// McpClientRepository is the source of truth for the MCP clients allowed to access the gateway.
+type McpClientRepository interface {
+	GetClientByToken(token string) (*model.McpClient, error)
+}
+
+// AclRepository decides which upstream servers an MCP client may access.
+type AclRepository interface {
+	IsServerAllowed(client *model.McpClient, serverName string) (bool, error)
+}
+
+// ServerConfigRepository holds the server config singleton that tells the mode of the gateway
+// and whether it has been initialized.
+type ServerConfigRepository interface {
+	GetServerConfig() (*model.ServerConfig, error)
+	Initialize(mode model.ServerMode) (*model.ServerConfig, *model.User, error)
+}
+
+// UserRepository is the source of truth for the users operating the gateway.
+type UserRepository interface {
+	GetUserByToken(token string) (*model.User, error)
+}
+
+// SecretRepository stores the named secrets referenced by server configs.
+// The values it returns may be encrypted.
+type SecretRepository interface {
+	GetSecret(name string) (string, error)
+}
+
+// SecretWriter is implemented by secret repositories that can store secrets.
+type SecretWriter interface {
+	UpsertSecret(name, value string) error
+}
+
+// ErrOAuthTokenNotFound is returned when the gateway holds no OAuth token of a user for a server.
+var ErrOAuthTokenNotFound = errors.New("oauth token not found")
+
+// OAuthTokenRepository persists the OAuth client registrations and tokens of the upstream servers.
+// The client secrets and tokens it returns may be encrypted.
+type OAuthTokenRepository interface {
+	GetOAuthToken(userId, serverName string) (*model.OAuthToken, error)
+	UpsertOAuthToken(token *model.OAuthToken) error
+}
+
+// ErrServerCatalogNotFound is returned when no catalog of a server has been stored for a user.
+var ErrServerCatalogNotFound = errors.New("server catalog not found")
+
+// ServerCatalogRepository persists the tools, prompts and resources last listed from the upstream servers.
+type ServerCatalogRepository interface {
+	GetServerCatalog(userId, serverName string) (*model.ServerCatalog, error)
+	UpsertServerCatalog(catalog *model.ServerCatalog) error
+}
+
+// allowListContains checks the server name against the allow list stored on the client.
+// It backs the AclRepository implementations until ACLs get a table of their own.
+func allowListContains(client *model.McpClient, serverName string) (bool, error) {
+	if len(client.AllowList) == 0 {
+		return false, nil
+	}
+	var allowList []string
+	if err := sonic.Unmarshal(client.AllowList, &allowList); err != nil {
+		return false, err
+	}
+	for _, name := range allowList {
+		if name == serverName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var (
+	_ McpServerRepository      = (*McpServerService)(nil)
+	_ McpServerWriter          = (*McpServerService)(nil)
+	_ McpServerLister          = (*McpServerService)(nil)
+	_ ServerTemplateRepository = (*McpServerService)(nil)
+	_ McpClientRepository      = (*McpClientService)(nil)
+	_ AclRepository            = (*McpClientService)(nil)
+
+	_ ServerConfigRepository = (*ServerConfigService)(nil)
+	_ UserRepository         = (*UserService)(nil)
+	_ SecretRepository       = (*SecretService)(nil)
+	_ SecretWriter           = (*SecretService)(nil)
+	_ OAuthTokenRepository   = (*OAuthTokenService)(nil)
+
+	_ ServerCatalogRepository = (*ServerCatalogService)(nil)
+	_ ServerCatalogRepository = (*CatalogFileStore)(nil)
+
+	_ McpServerRepository    = (*ConfigFileStore)(nil)
+	_ McpServerWatcher       = (*ConfigFileStore)(nil)
+	_ McpServerLister        = (*ConfigFileStore)(nil)
+	_ McpClientRepository    = (*ConfigFileStore)(nil)
+	_ AclRepository          = (*ConfigFileStore)(nil)
+	_ ServerConfigRepository = (*ConfigFileStore)(nil)
+	_ UserRepository         = (*ConfigFileStore)(nil)
+	_ SecretRepository       = (*ConfigFileStore)(nil)
+
+	_ McpServerRepository  = (*MemoryStore)(nil)
+	_ McpServerWriter      = (*MemoryStore)(nil)
+	_ McpServerLister      = (*MemoryStore)(nil)
+	_ McpClientRepository  = (*MemoryStore)(nil)
+	_ AclRepository        = (*MemoryStore)(nil)
+	_ OAuthTokenRepository = (*MemoryStore)(nil)
+
+	_ ServerCatalogRepository = (*MemoryStore)(nil)
+)
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SecretService struct {
+	db     *gorm.DB
+	cipher *secret.Cipher
+}
+
+func NewSecretService(db *gorm.DB, cipher *secret.Cipher) *SecretService {
+	return &SecretService{db: db, cipher: cipher}
+}
+
+// UpsertSecret stores the secret encrypted, replacing the value of an existing one.
+func (s *SecretService) UpsertSecret(name, value string) error {
+	encrypted, err := s.cipher.Encrypt(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"value":      encrypted,
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(&model.Secret{Name: name, Value: encrypted}).Error
+}
+
+// GetSecret returns the value of the secret as stored, encrypted when a master key is configured.
+func (s *SecretService) GetSecret(name string) (string, error) {
+	var found model.Secret
+	if err := s.db.Where("name = ?", name).First(&found).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("secret %s not found", name)
+		}
+		return "", err
+	}
+	return found.Value, nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ServerCatalogService struct {
+	db *gorm.DB
+}
+
+func NewServerCatalogService(db *gorm.DB) *ServerCatalogService {
+	return &ServerCatalogService{db: db}
+}
+
+// UpsertServerCatalog stores the catalog, replacing the one of the same user and server.
+func (s *ServerCatalogService) UpsertServerCatalog(catalog *model.ServerCatalog) error {
+	stored := *catalog
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "server_name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"config_key": stored.ConfigKey,
+			"catalog":    stored.Catalog,
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(&stored).Error
+}
+
+func (s *ServerCatalogService) GetServerCatalog(userId, serverName string) (*model.ServerCatalog, error) {
+	var found model.ServerCatalog
+	if err := s.db.Where("user_id = ? AND server_name = ?", userId, serverName).First(&found).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrServerCatalogNotFound, serverName)
+		}
+		return nil, err
+	}
+	return &found, nil
+}
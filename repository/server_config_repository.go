@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"errors"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/utils"
+	"gorm.io/gorm"
+)
+
+// ErrAlreadyInitialized is returned when initializing a server that has already been initialized.
+var ErrAlreadyInitialized = errors.New("server is already initialized")
+
+type ServerConfigService struct {
+	db *gorm.DB
+}
+
+func NewServerConfigService(db *gorm.DB) *ServerConfigService {
+	return &ServerConfigService{db: db}
+}
+
+// GetServerConfig returns the server config, an uninitialized one if the server has never been initialized.
+func (s *ServerConfigService) GetServerConfig() (*model.ServerConfig, error) {
+	var config model.ServerConfig
+	err := s.db.Order("id").First(&config).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &model.ServerConfig{Mode: model.ModeDev}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Initialize creates the server config singleton in the given mode.
+// The first admin user is created too and returned along with its access token, the admin API
+// requiring it in both modes.
+func (s *ServerConfigService) Initialize(mode model.ServerMode) (*model.ServerConfig, *model.User, error) {
+	config := &model.ServerConfig{Mode: mode, Initialized: true}
+	var admin *model.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&model.ServerConfig{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyInitialized
+		}
+		if err := tx.Create(config).Error; err != nil {
+			return err
+		}
+		token, err := utils.GenerateToken()
+		if err != nil {
+			return err
+		}
+		admin = &model.User{
+			Username:    "admin",
+			Role:        model.UserRoleAdmin,
+			AccessToken: token,
+		}
+		return tx.Create(admin).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, admin, nil
+}
+
+type UserService struct {
+	db *gorm.DB
+}
+
+func NewUserService(db *gorm.DB) *UserService {
+	return &UserService{db: db}
+}
+
+// GetUserByToken retrieves a user by its access token from the database.
+// It returns an error if no such user is found.
+func (u *UserService) GetUserByToken(token string) (*model.User, error) {
+	var user model.User
+	if err := u.db.Where("access_token = ?", token).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"errors"
+	"github.com/tomeai/mcp-gateway/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoCredentialBinding is returned when a user reaches a server template without having bound credentials to it.
+var ErrNoCredentialBinding = errors.New("no credentials bound to the server template")
+
+// UpsertServerTemplate stores the template with the env and header values of its config encrypted.
+func (ms *McpServerService) UpsertServerTemplate(template *model.ServerTemplate) error {
+	if ms.cipher != nil {
+		serverConfig, err := encryptServerConfig(ms.cipher, template.ServerConfig)
+		if err != nil {
+			return err
+		}
+		template.ServerConfig = serverConfig
+	}
+	return ms.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"description":   template.Description,
+			"server_config": template.ServerConfig,
+			"updated_at":    gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(template).Error
+}
+
+func (ms *McpServerService) GetServerTemplate(name string) (*model.ServerTemplate, error) {
+	var template model.ServerTemplate
+	if err := ms.db.Where("name = ?", name).First(&template).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// UpsertCredentialBinding stores the credentials of a user for a template, encrypted.
+func (ms *McpServerService) UpsertCredentialBinding(binding *model.CredentialBinding) error {
+	if _, err := ms.GetServerTemplate(binding.TemplateName); err != nil {
+		return err
+	}
+	if ms.cipher != nil {
+		var err error
+		if binding.Env, err = encryptJSONMap(ms.cipher, binding.Env); err != nil {
+			return err
+		}
+		if binding.Headers, err = encryptJSONMap(ms.cipher, binding.Headers); err != nil {
+			return err
+		}
+	}
+	return ms.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "template_name"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"env":        binding.Env,
+			"headers":    binding.Headers,
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(binding).Error
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"sync/atomic"
+	"time"
+)
+
+// errCallTimeout is the cause of the contexts cancelled by the timeout of a call,
+// telling it apart from the deadline of the downstream request
+var errCallTimeout = errors.New("call timeout")
+
+// CallLimitError tells that a call to an upstream server exceeded one of the limits of its config.
+type CallLimitError struct {
+	ServerName string
+	Limit      telemetry.CallLimit
+	message    string
+}
+
+func (e *CallLimitError) Error() string {
+	return fmt.Sprintf("mcp server %s: %s", e.ServerName, e.message)
+}
+
+// callLimiter enforces the call limits of an upstream server, shared by the instances serving it.
+type callLimiter struct {
+	serverName string
+	limits     model.CallLimits
+	metrics    telemetry.CustomMetrics
+	// slots holds a token per call in flight, nil when the concurrency is unlimited
+	slots  chan struct{}
+	queued atomic.Int64
+}
+
+func newCallLimiter(serverName string, limits *model.CallLimits, metrics telemetry.CustomMetrics) *callLimiter {
+	l := &callLimiter{serverName: serverName, metrics: metrics}
+	if limits != nil {
+		l.limits = *limits
+	}
+	if l.limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, l.limits.MaxConcurrent)
+	}
+	return l
+}
+
+// exceeded records the violation of a limit and returns the error answered to the client.
+func (l *callLimiter) exceeded(ctx context.Context, method mcp.MCPMethod, limit telemetry.CallLimit, message string) error {
+	l.metrics.RecordCallLimitExceeded(ctx, l.serverName, string(method), limit)
+	return &CallLimitError{ServerName: l.serverName, Limit: limit, message: message}
+}
+
+// timeout returns the timeout of a call, the one of the tool when it has its own.
+func (l *callLimiter) timeout(toolName string) time.Duration {
+	if seconds, ok := l.limits.ToolTimeoutSeconds[toolName]; ok && toolName != "" {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Duration(l.limits.TimeoutSeconds) * time.Second
+}
+
+// acquire waits for a free call slot, queueing the call unless the queue is full.
+func (l *callLimiter) acquire(ctx context.Context, method mcp.MCPMethod) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	queued := l.queued.Add(1)
+	defer l.queued.Add(-1)
+	if l.limits.MaxQueued > 0 && queued > int64(l.limits.MaxQueued) {
+		return nil, l.exceeded(ctx, method, telemetry.CallLimitConcurrency,
+			fmt.Sprintf("%s rejected, %d calls in flight and %d queued", method, l.limits.MaxConcurrent, l.limits.MaxQueued))
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		if errors.Is(context.Cause(ctx), errCallTimeout) {
+			return nil, l.exceeded(ctx, method, telemetry.CallLimitConcurrency,
+				fmt.Sprintf("%s timed out waiting for one of %d call slots", method, l.limits.MaxConcurrent))
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// checkRequestSize returns an error when a client message of size bytes exceeds the limit.
+func (l *callLimiter) checkRequestSize(ctx context.Context, size int64) error {
+	if l.limits.MaxRequestBytes > 0 && size > l.limits.MaxRequestBytes {
+		return l.exceeded(ctx, "", telemetry.CallLimitRequestSize,
+			fmt.Sprintf("request exceeds the limit of %d bytes", l.limits.MaxRequestBytes))
+	}
+	return nil
+}
+
+// limitCall runs a call to the upstream server within the limits: it waits for a free slot,
+// bounds the call with the timeout of the method or tool and checks the size of the result.
+func limitCall[T any](ctx context.Context, l *callLimiter, method mcp.MCPMethod, toolName string, call func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	callCtx := ctx
+	timeout := l.timeout(toolName)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeoutCause(ctx, timeout, errCallTimeout)
+		defer cancel()
+	}
+	release, err := l.acquire(callCtx, method)
+	if err != nil {
+		return zero, err
+	}
+	defer release()
+
+	result, err := call(callCtx)
+	if err != nil {
+		if errors.Is(context.Cause(callCtx), errCallTimeout) {
+			return zero, l.exceeded(ctx, method, telemetry.CallLimitTimeout, fmt.Sprintf("%s timed out after %s", method, timeout))
+		}
+		return zero, err
+	}
+	if l.limits.MaxResponseBytes > 0 {
+		data, err := sonic.Marshal(result)
+		if err != nil {
+			return zero, err
+		}
+		if size := int64(len(data)); size > l.limits.MaxResponseBytes {
+			return zero, l.exceeded(ctx, method, telemetry.CallLimitResponseSize,
+				fmt.Sprintf("%s result of %d bytes exceeds the limit of %d bytes", method, size, l.limits.MaxResponseBytes))
+		}
+	}
+	return result, nil
+}
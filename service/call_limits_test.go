@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(limits *model.CallLimits) *callLimiter {
+	return newCallLimiter("weather", limits, telemetry.NewNoopCustomMetrics())
+}
+
+func wantLimitError(t *testing.T, err error, limit telemetry.CallLimit) {
+	t.Helper()
+	var limitErr *CallLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Fatalf("error = %v, want %s limit error", err, limit)
+	}
+}
+
+func TestLimitCallTimeout(t *testing.T) {
+	l := newTestLimiter(&model.CallLimits{TimeoutSeconds: 60, ToolTimeoutSeconds: map[string]uint64{"slow": 1}})
+	if got := l.timeout("forecast"); got != time.Minute {
+		t.Errorf("timeout(forecast) = %s", got)
+	}
+	if got := l.timeout("slow"); got != time.Second {
+		t.Errorf("timeout(slow) = %s", got)
+	}
+
+	_, err := limitCall(context.Background(), l, mcp.MethodToolsCall, "slow", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	wantLimitError(t, err, telemetry.CallLimitTimeout)
+
+	// the deadline of the downstream request is not a limit of the server
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limitCall(ctx, l, mcp.MethodToolsCall, "slow", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestLimitCallResponseSize(t *testing.T) {
+	l := newTestLimiter(&model.CallLimits{MaxResponseBytes: 16})
+	if _, err := limitCall(context.Background(), l, mcp.MethodToolsCall, "", func(ctx context.Context) (string, error) {
+		return "short", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := limitCall(context.Background(), l, mcp.MethodToolsCall, "", func(ctx context.Context) (string, error) {
+		return strings.Repeat("x", 32), nil
+	})
+	wantLimitError(t, err, telemetry.CallLimitResponseSize)
+}
+
+func TestCheckRequestSize(t *testing.T) {
+	l := newTestLimiter(&model.CallLimits{MaxRequestBytes: 100})
+	if err := l.checkRequestSize(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+	wantLimitError(t, l.checkRequestSize(context.Background(), 101), telemetry.CallLimitRequestSize)
+	if err := newTestLimiter(nil).checkRequestSize(context.Background(), 1<<30); err != nil {
+		t.Fatalf("unlimited checkRequestSize() error = %v", err)
+	}
+}
+
+func TestLimitCallConcurrency(t *testing.T) {
+	l := newTestLimiter(&model.CallLimits{MaxConcurrent: 1, MaxQueued: 1, TimeoutSeconds: 1})
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 2)
+	go func() {
+		_, err := limitCall(context.Background(), l, mcp.MethodToolsCall, "", func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			return 0, nil
+		})
+		done <- err
+	}()
+	<-started
+
+	// the second call waits in the queue, the third one is rejected
+	queued := make(chan struct{})
+	go func() {
+		_, err := limitCall(context.Background(), l, mcp.MethodToolsCall, "", func(ctx context.Context) (int, error) {
+			close(queued)
+			return 0, nil
+		})
+		done <- err
+	}()
+	for l.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := limitCall(context.Background(), l, mcp.MethodToolsCall, "", func(ctx context.Context) (int, error) {
+		t.Error("call over the queue limit ran")
+		return 0, nil
+	})
+	wantLimitError(t, err, telemetry.CallLimitConcurrency)
+
+	close(release)
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-queued
+}
+
+func TestLimitCallWaitTimesOut(t *testing.T) {
+	l := newTestLimiter(&model.CallLimits{MaxConcurrent: 1, TimeoutSeconds: 1})
+	release, err := l.acquire(context.Background(), mcp.MethodToolsCall)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	_, err = limitCall(context.Background(), l, mcp.MethodToolsCall, "", func(ctx context.Context) (int, error) {
+		t.Error("call without a free slot ran")
+		return 0, nil
+	})
+	wantLimitError(t, err, telemetry.CallLimitConcurrency)
+}
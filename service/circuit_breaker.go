@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 30 * time.Second
+)
+
+// CircuitOpenError tells that the calls to an upstream server fail fast, its circuit being open.
+type CircuitOpenError struct {
+	ServerName string
+	Failures   int
+	LastError  string
+	// RetryAt is the time the circuit lets a probe through, zero while a probe is in flight
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.RetryAt.IsZero() {
+		return fmt.Sprintf("mcp server %s is unavailable after %d consecutive failures (last: %s), probing its recovery",
+			e.ServerName, e.Failures, e.LastError)
+	}
+	return fmt.Sprintf("mcp server %s is unavailable after %d consecutive failures (last: %s), retrying in %s",
+		e.ServerName, e.Failures, e.LastError, time.Until(e.RetryAt).Round(time.Second))
+}
+
+// CircuitStatus is the state of the circuit breaker of an upstream server instance.
+type CircuitStatus struct {
+	State               telemetry.CircuitState `json:"state"`
+	ConsecutiveFailures int                    `json:"consecutive_failures"`
+	OpenedAt            *time.Time             `json:"opened_at,omitempty"`
+	LastError           string                 `json:"last_error,omitempty"`
+}
+
+// callOutcome classifies the result of a call for the circuit breaker.
+type callOutcome int
+
+const (
+	outcomeSuccess callOutcome = iota
+	outcomeFailure
+	// outcomeNeutral tells nothing about the health of the server, e.g. a call cancelled by the client
+	outcomeNeutral
+)
+
+// classifyCall tells whether the error of a call is a failure of the upstream server. The errors answered
+// by the server itself (JSON-RPC errors) prove it healthy, only the transport errors and timeouts count.
+func classifyCall(err error) callOutcome {
+	if err == nil {
+		return outcomeSuccess
+	}
+	var limitErr *CallLimitError
+	if errors.As(err, &limitErr) {
+		if limitErr.Limit == telemetry.CallLimitTimeout {
+			return outcomeFailure
+		}
+		return outcomeNeutral
+	}
+	if errors.Is(err, context.Canceled) || client.IsOAuthAuthorizationRequiredError(err) ||
+		errors.Is(err, transport.ErrOAuthAuthorizationRequired) {
+		return outcomeNeutral
+	}
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) || errors.Is(err, context.DeadlineExceeded) {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// circuitBreaker fails the calls to an upstream server instance fast once it failed repeatedly.
+// It opens after threshold consecutive failures, lets a single probe through once openDuration elapsed
+// and closes again when the probe succeeds.
+type circuitBreaker struct {
+	serverName   string
+	threshold    int
+	openDuration time.Duration
+	disabled     bool
+	metrics      telemetry.CustomMetrics
+	logger       *zap.Logger
+
+	mu       sync.Mutex
+	state    telemetry.CircuitState
+	failures int
+	openedAt time.Time
+	lastErr  string
+	// probing is set while the probe of the half open state is in flight
+	probing bool
+}
+
+func newCircuitBreaker(serverName string, conf *model.CircuitBreaker, metrics telemetry.CustomMetrics, logger *zap.Logger) *circuitBreaker {
+	b := &circuitBreaker{
+		serverName:   serverName,
+		threshold:    defaultFailureThreshold,
+		openDuration: defaultOpenDuration,
+		metrics:      metrics,
+		logger:       logger,
+		state:        telemetry.CircuitClosed,
+	}
+	if conf != nil {
+		if conf.FailureThreshold > 0 {
+			b.threshold = conf.FailureThreshold
+		}
+		if conf.OpenSeconds > 0 {
+			b.openDuration = time.Duration(conf.OpenSeconds) * time.Second
+		}
+		b.disabled = conf.Disabled
+	}
+	return b
+}
+
+// allow returns an error while the circuit is open, switching it to half open once the open duration elapsed.
+func (b *circuitBreaker) allow(ctx context.Context) error {
+	if b.disabled {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case telemetry.CircuitOpen:
+		retryAt := b.openedAt.Add(b.openDuration)
+		if time.Now().Before(retryAt) {
+			return &CircuitOpenError{ServerName: b.serverName, Failures: b.failures, LastError: b.lastErr, RetryAt: retryAt}
+		}
+		b.setState(ctx, telemetry.CircuitHalfOpen)
+		b.probing = true
+	case telemetry.CircuitHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{ServerName: b.serverName, Failures: b.failures, LastError: b.lastErr}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// available tells whether the circuit lets a call through, without switching it to half open.
+func (b *circuitBreaker) available() bool {
+	if b.disabled {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case telemetry.CircuitOpen:
+		return !time.Now().Before(b.openedAt.Add(b.openDuration))
+	case telemetry.CircuitHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// record updates the circuit with the result of a call or ping.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b.disabled {
+		return
+	}
+	outcome := classifyCall(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch outcome {
+	case outcomeSuccess:
+		if b.state == telemetry.CircuitOpen {
+			// a call let through before the circuit opened
+			return
+		}
+		b.failures = 0
+		if b.state == telemetry.CircuitHalfOpen {
+			b.probing = false
+			b.setState(ctx, telemetry.CircuitClosed)
+		}
+	case outcomeFailure:
+		b.failures++
+		b.lastErr = err.Error()
+		switch {
+		case b.state == telemetry.CircuitHalfOpen,
+			b.state == telemetry.CircuitClosed && b.failures >= b.threshold:
+			b.probing = false
+			b.openedAt = time.Now()
+			b.setState(ctx, telemetry.CircuitOpen)
+		}
+	case outcomeNeutral:
+		if b.state == telemetry.CircuitHalfOpen {
+			b.probing = false
+		}
+	}
+}
+
+func (b *circuitBreaker) setState(ctx context.Context, state telemetry.CircuitState) {
+	b.state = state
+	b.metrics.RecordCircuitState(ctx, b.serverName, state)
+	if state == telemetry.CircuitOpen {
+		b.logger.Warn("Circuit opened", zap.String("mcpServerName", b.serverName), zap.Int("failures", b.failures), zap.String("lastError", b.lastErr))
+	} else {
+		b.logger.Info("Circuit state changed", zap.String("mcpServerName", b.serverName), zap.String("state", string(state)))
+	}
+}
+
+// status returns the state of the circuit.
+func (b *circuitBreaker) status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := CircuitStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastErr,
+	}
+	if b.state != telemetry.CircuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
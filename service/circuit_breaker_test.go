@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+var errTransport = transport.NewError(errors.New("connection refused"))
+
+func newTestBreaker(conf *model.CircuitBreaker) *circuitBreaker {
+	return newCircuitBreaker("weather", conf, telemetry.NewNoopCustomMetrics(), zap.NewNop())
+}
+
+func TestClassifyCall(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want callOutcome
+	}{
+		{"success", nil, outcomeSuccess},
+		{"transport error", errTransport, outcomeFailure},
+		{"wrapped transport error", fmt.Errorf("call: %w", errTransport), outcomeFailure},
+		{"deadline", context.DeadlineExceeded, outcomeFailure},
+		{"cancelled", context.Canceled, outcomeNeutral},
+		{"call timeout", &CallLimitError{Limit: telemetry.CallLimitTimeout}, outcomeFailure},
+		{"concurrency limit", &CallLimitError{Limit: telemetry.CallLimitConcurrency}, outcomeNeutral},
+		{"authorization required", transport.ErrOAuthAuthorizationRequired, outcomeNeutral},
+		// the server answered, it is healthy
+		{"json-rpc error", errors.New("tool not found"), outcomeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyCall(tt.err); got != tt.want {
+				t.Errorf("classifyCall(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBreaker(&model.CircuitBreaker{FailureThreshold: 3})
+	for range 2 {
+		b.record(ctx, errTransport)
+	}
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("allow() below the threshold error = %v", err)
+	}
+	// a success resets the count of consecutive failures
+	b.record(ctx, nil)
+	for range 2 {
+		b.record(ctx, errTransport)
+	}
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("allow() after a success error = %v", err)
+	}
+	b.record(ctx, errTransport)
+
+	var openErr *CircuitOpenError
+	if err := b.allow(ctx); !errors.As(err, &openErr) {
+		t.Fatalf("allow() of an open circuit error = %v, want CircuitOpenError", err)
+	}
+	if openErr.Failures != 3 || openErr.RetryAt.IsZero() {
+		t.Errorf("CircuitOpenError = %+v", openErr)
+	}
+	if b.available() {
+		t.Error("available() of an open circuit")
+	}
+
+	// once the open duration elapsed, a single probe is let through
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.openDuration)
+	b.mu.Unlock()
+	if !b.available() {
+		t.Fatal("available() once the open duration elapsed = false")
+	}
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("allow() of the probe error = %v", err)
+	}
+	if err := b.allow(ctx); !errors.As(err, &openErr) {
+		t.Fatalf("allow() while probing error = %v, want CircuitOpenError", err)
+	}
+	b.record(ctx, nil)
+	if status := b.status(); status.State != telemetry.CircuitClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("status after a successful probe = %+v", status)
+	}
+}
+
+func TestCircuitBreakerFailedProbeReopens(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBreaker(&model.CircuitBreaker{FailureThreshold: 1})
+	b.record(ctx, errTransport)
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.openDuration)
+	b.mu.Unlock()
+	if err := b.allow(ctx); err != nil {
+		t.Fatal(err)
+	}
+	b.record(ctx, errTransport)
+	if status := b.status(); status.State != telemetry.CircuitOpen {
+		t.Fatalf("state after a failed probe = %s, want open", status.State)
+	}
+	if err := b.allow(ctx); err == nil {
+		t.Fatal("allow() after a failed probe succeeded")
+	}
+}
+
+func TestCircuitBreakerNeutralProbe(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBreaker(&model.CircuitBreaker{FailureThreshold: 1})
+	b.record(ctx, errTransport)
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.openDuration)
+	b.mu.Unlock()
+	if err := b.allow(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// a cancelled probe tells nothing, the next call probes again
+	b.record(ctx, context.Canceled)
+	if err := b.allow(ctx); err != nil {
+		t.Fatalf("allow() after a cancelled probe error = %v", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBreaker(&model.CircuitBreaker{FailureThreshold: 1, Disabled: true})
+	for range 3 {
+		b.record(ctx, errTransport)
+	}
+	if err := b.allow(ctx); err != nil || !b.available() {
+		t.Fatalf("allow() of a disabled breaker error = %v", err)
+	}
+}
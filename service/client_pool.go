@@ -0,0 +1,784 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultHealthCheckInterval is the interval of the pings of the members of a pool
+const defaultHealthCheckInterval = 10 * time.Second
+
+// connectTimeout bounds the connection of the members of a pool and the listing of their catalog
+const connectTimeout = time.Minute
+
+// reconnectInterval is the interval of the attempts to connect the members of a pool not connected
+const reconnectInterval = 30 * time.Second
+
+// releaseSessionTimeout bounds the release of the upstream subscriptions of a downstream session gone
+const releaseSessionTimeout = 30 * time.Second
+
+// errPoolClosed fails the connection and the calls of a pool closed before its members connected
+var errPoolClosed = errors.New("upstream pool closed")
+
+// upstreamTiers returns the config of a server followed by the configs of its fallbacks, in the order of preference.
+// The fallbacks without circuit breaker or retry policy of their own inherit the ones of the server,
+// the call limits of the server bound its fallbacks as well.
+func upstreamTiers(conf *model.MCPClientConfig) []*model.MCPClientConfig {
+	tiers := []*model.MCPClientConfig{conf}
+	for _, fallback := range conf.Fallbacks {
+		fallback.CircuitBreaker = cmp.Or(fallback.CircuitBreaker, conf.CircuitBreaker)
+		fallback.Retry = cmp.Or(fallback.Retry, conf.Retry)
+		fallback.Fallbacks = nil
+		tiers = append(tiers, &fallback)
+	}
+	return tiers
+}
+
+// memberConfigs splits the config of a server into the configs of the members of its pool,
+// a single member unless the config declares a pool.
+func memberConfigs(conf *model.MCPClientConfig) ([]*model.MCPClientConfig, error) {
+	if conf.Pool == nil {
+		return []*model.MCPClientConfig{conf}, nil
+	}
+	var configs []*model.MCPClientConfig
+	if conf.Command != "" || conf.TransportType == model.MCPClientTypeStdio {
+		for range max(conf.Pool.Size, 1) {
+			member := *conf
+			member.Pool = nil
+			configs = append(configs, &member)
+		}
+		return configs, nil
+	}
+	urls := conf.Pool.Endpoints
+	if conf.URL != "" {
+		urls = append([]string{conf.URL}, urls...)
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("url or pool endpoints are required")
+	}
+	for _, u := range urls {
+		member := *conf
+		member.URL = u
+		member.Pool = nil
+		configs = append(configs, &member)
+	}
+	return configs, nil
+}
+
+// memberEndpoint describes a member of a pool in the status and logs, without the credentials of its url.
+func memberEndpoint(conf *model.MCPClientConfig, index int) string {
+	if conf.URL == "" {
+		return fmt.Sprintf("%s #%d", conf.Command, index)
+	}
+	if u, err := url.Parse(conf.URL); err == nil {
+		return u.Redacted()
+	}
+	return conf.URL
+}
+
+// memberSpec describes an upstream server instance of a pool, its client is built anew on every attempt to connect it.
+type memberSpec struct {
+	endpoint string
+	// tier is 0 for the instances of the server, i for the ones of its i-th fallback
+	tier      int
+	newClient func() (*MCPClient, error)
+}
+
+// poolMember is a connected upstream server instance of a pool.
+type poolMember struct {
+	spec     *memberSpec
+	client   *MCPClient
+	endpoint string
+	tier     int
+	inFlight atomic.Int64
+}
+
+// MemberStatus is the state of a member of the pool of an upstream server.
+type MemberStatus struct {
+	Endpoint string `json:"endpoint"`
+	// Connected is false for the instances of the server waiting to be connected again
+	Connected    bool                `json:"connected"`
+	Transport    model.MCPClientType `json:"transport,omitempty"`
+	Tier         int                 `json:"tier"`
+	InFlight     int64               `json:"in_flight"`
+	LastPing     *time.Time          `json:"last_ping,omitempty"`
+	PingFailures int64               `json:"ping_failures"`
+	Circuit      CircuitStatus       `json:"circuit"`
+}
+
+// UpstreamState summarizes the state of the members of the pool of an upstream server.
+type UpstreamState string
+
+const (
+	// UpstreamConnecting is the state of a pool whose members are connecting
+	UpstreamConnecting UpstreamState = "connecting"
+	// UpstreamFailed is the state of a pool none of whose members connected
+	UpstreamFailed UpstreamState = "failed"
+	// UpstreamDown is the state of a pool whose members all have an open circuit
+	UpstreamDown UpstreamState = "down"
+	// UpstreamDegraded is the state of a pool some of whose members have a circuit not closed or are not connected
+	UpstreamDegraded UpstreamState = "degraded"
+	// UpstreamUp is the state of a pool whose members all have a closed circuit
+	UpstreamUp UpstreamState = "up"
+)
+
+// clientPool serves an upstream server from one or more instances, selecting the instance of each call
+// among those whose circuit is not open, in the lowest tier having one: the fallbacks only serve while
+// the instances of the server are down. The resource subscriptions stick to the instance holding them.
+// The calls wait for the members to connect, the pool may be served from a persisted catalog meanwhile.
+// A fallback tier is only connected when the tiers before it have no member serving. The members failing
+// to connect are retried in the background, the calls going back to the instances of the server once they
+// connected; the fallbacks started stay connected until the pool is closed.
+type clientPool struct {
+	name     string
+	strategy string
+	specs    []*memberSpec
+	// members are the connected members, replaced as a whole under mu
+	members atomic.Pointer[[]*poolMember]
+	next    atomic.Uint64
+	// tier is the tier of the last selected member, logging the failovers
+	tier atomic.Int64
+	// limiter holds the call limits of the server, shared by the members of every tier
+	limiter *callLimiter
+	// cache answers the calls of the read-only tools, nil unless enabled
+	cache *toolCache
+	// catalog is the catalog registered on the downstream server
+	catalog atomic.Pointer[upstreamCatalog]
+	// ready is closed once the members connected, connectErr telling why none did
+	ready      chan struct{}
+	connectErr error
+	// pingInterval is the interval of the pings of the members, which eject the members failing them
+	pingInterval time.Duration
+	logger       *zap.Logger
+	// ctx bounds the reconnections of the members, it is cancelled on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// pins maps the subscribed uris to the member holding the upstream subscription
+	pins map[string]*poolMember
+	// logSessions are the downstream sessions the upstream log messages are relayed to
+	logSessions map[string]struct{}
+	// mcpServer is the downstream server the members relay their notifications to, set on connect
+	mcpServer *server.MCPServer
+	// connectedAt is the time the members connected
+	connectedAt time.Time
+}
+
+func newClientPool(name string, conf *model.UpstreamPool, specs []*memberSpec, limiter *callLimiter, cache *toolCache, logger *zap.Logger) (*clientPool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &clientPool{
+		name:        name,
+		strategy:    model.PoolStrategyRoundRobin,
+		specs:       specs,
+		limiter:     limiter,
+		cache:       cache,
+		ready:       make(chan struct{}),
+		logger:      logger,
+		ctx:         ctx,
+		cancel:      cancel,
+		pins:        make(map[string]*poolMember),
+		logSessions: make(map[string]struct{}),
+	}
+	p.members.Store(&[]*poolMember{})
+	interval := defaultHealthCheckInterval
+	if conf != nil {
+		switch conf.Strategy {
+		case "":
+		case model.PoolStrategyRoundRobin, model.PoolStrategyLeastInFlight:
+			p.strategy = conf.Strategy
+		default:
+			cancel()
+			return nil, fmt.Errorf("unknown pool strategy %s", conf.Strategy)
+		}
+		if conf.HealthCheckSeconds > 0 {
+			interval = time.Duration(conf.HealthCheckSeconds) * time.Second
+		}
+	}
+	if len(specs) > 1 {
+		p.pingInterval = interval
+	}
+	return p, nil
+}
+
+// connected returns the connected members.
+func (p *clientPool) connected() []*poolMember {
+	return *p.members.Load()
+}
+
+// AddToMCPServer connects the members and registers the tools, prompts and resources of the upstream server
+// on mcpServer, as listed by the first member connected. The tiers are connected in turn until one has a member
+// connected, it fails when none has.
+func (p *clientPool) AddToMCPServer(ctx context.Context, mcpServer *server.MCPServer) error {
+	_, err := p.connect(ctx, mcpServer)
+	return err
+}
+
+// AddToMCPServerLazily registers a catalog persisted from a former connection on mcpServer and connects
+// the members in the background, the calls waiting for them. The catalog listed by the members replaces
+// the persisted one once connected, onConnected is called with it or with the error of the connection.
+// Closing the pool cancels the connection.
+func (p *clientPool) AddToMCPServerLazily(mcpServer *server.MCPServer, catalog *upstreamCatalog, onConnected func(*upstreamCatalog, error)) {
+	p.register(mcpServer, catalog)
+	go func() {
+		ctx, cancel := context.WithTimeout(p.ctx, connectTimeout)
+		defer cancel()
+		onConnected(p.connect(ctx, mcpServer))
+	}()
+}
+
+// connect connects the members of the first tier having one connected and registers the catalog listed by
+// the first of them, which it returns. The members not connected are retried in the background from then on.
+func (p *clientPool) connect(ctx context.Context, mcpServer *server.MCPServer) (*upstreamCatalog, error) {
+	defer close(p.ready)
+	p.mu.Lock()
+	p.mcpServer = mcpServer
+	p.mu.Unlock()
+
+	var (
+		errs    []error
+		catalog *upstreamCatalog
+	)
+	for tier := 0; tier <= p.lastTier() && catalog == nil && p.ctx.Err() == nil; tier++ {
+		var tierErrs []error
+		catalog, tierErrs = p.connectSpecs(ctx, p.tierSpecs(tier, nil), nil)
+		errs = append(errs, tierErrs...)
+	}
+	members := p.connected()
+	if len(members) == 0 {
+		if p.ctx.Err() != nil || len(errs) == 0 {
+			// the pool has been closed meanwhile, the members connected were closed instead of added
+			errs = append(errs, errPoolClosed)
+		}
+		p.connectErr = asAuthorizationRequired(errors.Join(errs...), p.name)
+		return nil, p.connectErr
+	}
+	p.mu.Lock()
+	p.connectedAt = time.Now()
+	p.mu.Unlock()
+	p.tier.Store(int64(members[0].tier))
+	if members[0].tier > 0 {
+		p.logger.Warn("Serve mcp server from fallback", zap.String("mcpServerName", p.name),
+			zap.String("endpoint", members[0].endpoint), zap.Int("tier", members[0].tier))
+	}
+	p.register(mcpServer, catalog)
+	go p.reconnect()
+	return catalog, nil
+}
+
+// lastTier returns the tier of the last fallback, 0 without fallback.
+func (p *clientPool) lastTier() int {
+	return p.specs[len(p.specs)-1].tier
+}
+
+// tierSpecs returns the members of tier not in connected.
+func (p *clientPool) tierSpecs(tier int, connected map[*memberSpec]struct{}) []*memberSpec {
+	var specs []*memberSpec
+	for _, spec := range p.specs {
+		if _, ok := connected[spec]; !ok && spec.tier == tier {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// connectSpecs connects the members of specs concurrently and adds the ones connected to the pool. It returns the
+// catalog listed by the first one connected, nil when none did, and the errors of the others. The tools of the members
+// connected are compared to the ones of base, of the catalog returned when nil.
+func (p *clientPool) connectSpecs(ctx context.Context, specs []*memberSpec, base *upstreamCatalog) (*upstreamCatalog, []error) {
+	members := make([]*poolMember, len(specs))
+	catalogs := make([]*upstreamCatalog, len(specs))
+	errs := make([]error, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			members[i], catalogs[i], errs[i] = p.connectSpec(ctx, spec)
+		}()
+	}
+	wg.Wait()
+
+	var catalog *upstreamCatalog
+	var failed []error
+	for i, spec := range specs {
+		if errs[i] != nil {
+			if len(p.specs) > 1 {
+				p.logger.Warn("Connect pool member failed", zap.String("mcpServerName", p.name),
+					zap.String("endpoint", spec.endpoint), zap.Int("tier", spec.tier), zap.Error(errs[i]))
+			}
+			failed = append(failed, errs[i])
+			continue
+		}
+		if catalog == nil {
+			catalog = catalogs[i]
+		}
+		p.add(members[i])
+	}
+	if catalog == nil {
+		return nil, failed
+	}
+	if base == nil {
+		base = catalog
+	}
+	for i, spec := range specs {
+		if errs[i] != nil || catalogs[i] == base {
+			continue
+		}
+		// the calls may be served by any member, their tools should match the registered ones
+		if missing, extra, changed := toolSetDiff(base.Tools, catalogs[i].Tools); len(missing)+len(extra)+len(changed) > 0 {
+			p.logger.Warn("Upstream tool sets differ", zap.String("mcpServerName", p.name), zap.String("endpoint", spec.endpoint),
+				zap.Int("tier", spec.tier), zap.Strings("missing", missing), zap.Strings("extra", extra), zap.Strings("changed", changed))
+		}
+	}
+	return catalog, failed
+}
+
+// connectSpec builds the client of a member, connects it and lists its catalog.
+func (p *clientPool) connectSpec(ctx context.Context, spec *memberSpec) (*poolMember, *upstreamCatalog, error) {
+	client, err := spec.newClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pingInterval > 0 {
+		// the stdio members are pinged as well, their failed pings eject them
+		client.needPing = true
+		client.pingInterval = p.pingInterval
+	}
+	p.mu.Lock()
+	mcpServer := p.mcpServer
+	p.mu.Unlock()
+	initResult, err := client.connect(ctx, mcpServer)
+	var catalog *upstreamCatalog
+	if err == nil {
+		catalog, err = client.listCatalog(ctx, initResult.Capabilities)
+	}
+	if err != nil {
+		_ = client.Close()
+		return nil, nil, err
+	}
+	return &poolMember{spec: spec, client: client, endpoint: spec.endpoint, tier: spec.tier}, catalog, nil
+}
+
+// add adds a connected member to the pool, closing it when the pool has been closed meanwhile.
+func (p *clientPool) add(member *poolMember) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		_ = member.client.Close()
+		return
+	}
+	for sessionID := range p.logSessions {
+		member.client.AddLogSession(sessionID)
+	}
+	members := append(slices.Clone(p.connected()), member)
+	// the members are kept in the order of their tier, the first one serving the completions
+	slices.SortStableFunc(members, func(a, b *poolMember) int {
+		return cmp.Compare(a.tier, b.tier)
+	})
+	p.members.Store(&members)
+}
+
+// reconnect attempts to connect the members not connected every reconnectInterval, until the pool is closed.
+func (p *clientPool) reconnect() {
+	ticker := time.NewTicker(reconnectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.reconnectMembers()
+		}
+	}
+}
+
+// reconnectMembers connects the members not connected of the tiers up to the lowest one serving. When no member
+// is serving, the tiers are tried in turn as on the first connection, until one has a member connected.
+// The calls fail back to a lower tier as soon as its members are added.
+func (p *clientPool) reconnectMembers() {
+	connected := make(map[*memberSpec]struct{})
+	serving := p.lastTier() + 1
+	for _, member := range p.connected() {
+		connected[member.spec] = struct{}{}
+		if member.client.breaker.available() {
+			serving = min(serving, member.tier)
+		}
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, connectTimeout)
+	defer cancel()
+	if serving <= p.lastTier() {
+		var specs []*memberSpec
+		for tier := 0; tier <= serving; tier++ {
+			specs = append(specs, p.tierSpecs(tier, connected)...)
+		}
+		if len(specs) > 0 {
+			p.connectSpecs(ctx, specs, p.catalog.Load())
+		}
+		return
+	}
+	for tier := 0; tier <= p.lastTier(); tier++ {
+		specs := p.tierSpecs(tier, connected)
+		if len(specs) == 0 {
+			continue
+		}
+		if catalog, _ := p.connectSpecs(ctx, specs, p.catalog.Load()); catalog != nil {
+			return
+		}
+	}
+}
+
+// register registers catalog on mcpServer in place of the catalog registered, unless they list the same entries.
+func (p *clientPool) register(mcpServer *server.MCPServer, catalog *upstreamCatalog) {
+	previous := p.catalog.Load()
+	if previous != nil && previous.equal(catalog) {
+		return
+	}
+	p.catalog.Store(catalog)
+	if p.cache != nil {
+		p.cache.setTools(catalog.Tools)
+	}
+	catalog.register(mcpServer, p, previous)
+}
+
+// wait blocks until the members connected, returning the error of the connection when none did.
+func (p *clientPool) wait(ctx context.Context) error {
+	select {
+	case <-p.ready:
+		return p.connectErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// failed tells whether the connection of the members is over and none connected.
+func (p *clientPool) failed() bool {
+	select {
+	case <-p.ready:
+		return p.connectErr != nil
+	default:
+		return false
+	}
+}
+
+// toolSetDiff compares the tools of an upstream instance to the base ones, returning the names of the base tools
+// it lacks, of the tools it adds and of the tools whose schema or annotations differ.
+func toolSetDiff(base, tools []mcp.Tool) (missing, extra, changed []string) {
+	byName := make(map[string]mcp.Tool, len(tools))
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+	for _, baseTool := range base {
+		tool, ok := byName[baseTool.Name]
+		if !ok {
+			missing = append(missing, baseTool.Name)
+			continue
+		}
+		delete(byName, baseTool.Name)
+		if toolSignature(baseTool) != toolSignature(tool) {
+			changed = append(changed, baseTool.Name)
+		}
+	}
+	for name := range byName {
+		extra = append(extra, name)
+	}
+	slices.Sort(extra)
+	return missing, extra, changed
+}
+
+// toolSignature serializes the tool without its description, the map keys sorted.
+func toolSignature(tool mcp.Tool) string {
+	tool.Description = ""
+	data, err := sonic.ConfigStd.Marshal(tool)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// pick selects the member serving a call among those of the lowest tier whose circuit lets calls through.
+// When every circuit is open, the call goes to a member anyway and fails fast.
+// It returns nil when no member is connected, as in a pool closed while connecting.
+func (p *clientPool) pick() *poolMember {
+	members := p.connected()
+	if len(members) == 0 {
+		return nil
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+	start := int((p.next.Add(1) - 1) % uint64(len(members)))
+	var picked *poolMember
+	for i := range members {
+		member := members[(start+i)%len(members)]
+		if !member.client.breaker.available() {
+			continue
+		}
+		switch {
+		case picked == nil, member.tier < picked.tier:
+			picked = member
+		case member.tier == picked.tier && p.strategy == model.PoolStrategyLeastInFlight &&
+			member.inFlight.Load() < picked.inFlight.Load():
+			picked = member
+		}
+	}
+	if picked == nil {
+		return members[start]
+	}
+	if previous := p.tier.Swap(int64(picked.tier)); previous != int64(picked.tier) {
+		if picked.tier > 0 {
+			p.logger.Warn("Fail over to fallback", zap.String("mcpServerName", p.name),
+				zap.String("endpoint", picked.endpoint), zap.Int("tier", picked.tier))
+		} else {
+			p.logger.Info("Fail back to primary", zap.String("mcpServerName", p.name), zap.String("endpoint", picked.endpoint))
+		}
+	}
+	return picked
+}
+
+// dispatch runs a call on the member selected for it, once the members connected.
+func dispatch[T any](ctx context.Context, p *clientPool, call func(client *MCPClient) (T, error)) (T, error) {
+	if err := p.wait(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+	member := p.pick()
+	if member == nil {
+		var zero T
+		return zero, errPoolClosed
+	}
+	member.inFlight.Add(1)
+	defer member.inFlight.Add(-1)
+	return call(member.client)
+}
+
+func (p *clientPool) callTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if p.cache != nil {
+		return p.cache.call(ctx, request, p.forwardToolCall)
+	}
+	return p.forwardToolCall(ctx, request)
+}
+
+func (p *clientPool) forwardToolCall(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return dispatch(ctx, p, func(client *MCPClient) (*mcp.CallToolResult, error) {
+		return client.callTool(ctx, request)
+	})
+}
+
+func (p *clientPool) getPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	return dispatch(ctx, p, func(client *MCPClient) (*mcp.GetPromptResult, error) {
+		return client.getPrompt(ctx, request)
+	})
+}
+
+func (p *clientPool) readResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	return dispatch(ctx, p, func(client *MCPClient) ([]mcp.ResourceContents, error) {
+		return client.readResource(ctx, request)
+	})
+}
+
+// limits returns the call limits of the members, which share the config of the server.
+func (p *clientPool) limits() *callLimiter {
+	return p.limiter
+}
+
+// supportsCompletions reports whether the catalog registered declares the completions capability.
+func (p *clientPool) supportsCompletions() bool {
+	catalog := p.catalog.Load()
+	return catalog != nil && catalog.Completions
+}
+
+// OwnsCompletionRef reports whether the prompt or resource (template) referenced has been registered
+// from the upstream server, the members must be connected.
+func (p *clientPool) OwnsCompletionRef(ref any) bool {
+	members := p.connected()
+	return len(members) > 0 && members[0].client.OwnsCompletionRef(ref)
+}
+
+func (p *clientPool) Complete(ctx context.Context, request mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+	return dispatch(ctx, p, func(client *MCPClient) (*mcp.CompleteResult, error) {
+		return client.Complete(ctx, request)
+	})
+}
+
+// Subscribe subscribes a downstream session to updates of a resource on the member already holding
+// the subscription of uri, or on the member selected for it.
+func (p *clientPool) Subscribe(ctx context.Context, sessionID, uri string) error {
+	if err := p.wait(ctx); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	member, ok := p.pins[uri]
+	if !ok {
+		if member = p.pick(); member == nil {
+			p.mu.Unlock()
+			return errPoolClosed
+		}
+		p.pins[uri] = member
+	}
+	p.mu.Unlock()
+	err := member.client.Subscribe(ctx, sessionID, uri)
+	if err != nil {
+		p.unpin(uri, member)
+	}
+	return err
+}
+
+// Unsubscribe cancels the subscription of a downstream session on the member holding it.
+func (p *clientPool) Unsubscribe(ctx context.Context, sessionID, uri string) error {
+	p.mu.Lock()
+	member, ok := p.pins[uri]
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	err := member.client.Unsubscribe(ctx, sessionID, uri)
+	p.unpin(uri, member)
+	return err
+}
+
+// unpin releases the pin of uri to member once the member holds no subscription of uri anymore.
+func (p *clientPool) unpin(uri string, member *poolMember) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pins[uri] == member && !member.client.subscriptions.hasSubscribers(uri) {
+		delete(p.pins, uri)
+	}
+}
+
+// AddLogSession relays the upstream log messages to a downstream session, once the members connected.
+func (p *clientPool) AddLogSession(sessionID string) {
+	select {
+	case <-p.ready:
+		p.addLogSession(sessionID)
+	default:
+		// the session set its level while the members connect
+		go func() {
+			<-p.ready
+			p.addLogSession(sessionID)
+		}()
+	}
+}
+
+func (p *clientPool) addLogSession(sessionID string) {
+	if p.connectErr != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// the members connected later relay the messages to the session as well
+	p.logSessions[sessionID] = struct{}{}
+	for _, member := range p.connected() {
+		member.client.AddLogSession(sessionID)
+	}
+}
+
+// RemoveSession drops the resource subscriptions and log relays held by a downstream session.
+func (p *clientPool) RemoveSession(ctx context.Context, sessionID string) {
+	if p.wait(ctx) != nil {
+		return
+	}
+	p.mu.Lock()
+	delete(p.logSessions, sessionID)
+	p.mu.Unlock()
+	for _, member := range p.connected() {
+		member.client.RemoveSession(ctx, sessionID)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for uri, member := range p.pins {
+		if !member.client.subscriptions.hasSubscribers(uri) {
+			delete(p.pins, uri)
+		}
+	}
+}
+
+// Status returns the state of the members connected and of the instances of the server not connected.
+func (p *clientPool) Status() []MemberStatus {
+	members := p.connected()
+	connected := make(map[*memberSpec]struct{}, len(members))
+	statuses := make([]MemberStatus, 0, len(members))
+	for _, member := range members {
+		connected[member.spec] = struct{}{}
+		status := MemberStatus{
+			Endpoint:     member.endpoint,
+			Connected:    true,
+			Transport:    member.client.transportType,
+			Tier:         member.tier,
+			InFlight:     member.inFlight.Load(),
+			PingFailures: member.client.pingFailures.Load(),
+			Circuit:      member.client.CircuitStatus(),
+		}
+		if lastPing := member.client.lastPing.Load(); lastPing > 0 {
+			at := time.Unix(0, lastPing)
+			status.LastPing = &at
+		}
+		statuses = append(statuses, status)
+	}
+	for _, spec := range p.tierSpecs(0, connected) {
+		statuses = append(statuses, MemberStatus{Endpoint: spec.endpoint})
+	}
+	return statuses
+}
+
+// state summarizes the state of the pool from the status of its members, and returns the time they connected,
+// zero until they did.
+func (p *clientPool) state(members []MemberStatus) (UpstreamState, time.Time) {
+	select {
+	case <-p.ready:
+	default:
+		return UpstreamConnecting, time.Time{}
+	}
+	if p.connectErr != nil {
+		return UpstreamFailed, time.Time{}
+	}
+	p.mu.Lock()
+	connectedAt := p.connectedAt
+	p.mu.Unlock()
+	open, notClosed := 0, 0
+	for _, member := range members {
+		if !member.Connected {
+			open++
+			notClosed++
+			continue
+		}
+		switch member.Circuit.State {
+		case telemetry.CircuitOpen:
+			open++
+			notClosed++
+		case telemetry.CircuitHalfOpen:
+			notClosed++
+		}
+	}
+	switch {
+	case open == len(members):
+		return UpstreamDown, connectedAt
+	case notClosed > 0:
+		return UpstreamDegraded, connectedAt
+	}
+	return UpstreamUp, connectedAt
+}
+
+func (p *clientPool) Close() error {
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, member := range p.connected() {
+		errs = append(errs, member.client.Close())
+	}
+	return errors.Join(errs...)
+}
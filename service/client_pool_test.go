@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// testUpstream is an in-process streamable http mcp server whose echo tool answers with its name.
+// It answers 503 while it is down.
+type testUpstream struct {
+	*httptest.Server
+	down atomic.Bool
+}
+
+func newTestUpstream(t *testing.T, name string) *testUpstream {
+	t.Helper()
+	mcpServer := server.NewMCPServer(name, "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("echo", mcp.WithReadOnlyHintAnnotation(true)),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(name), nil
+		})
+	httpServer := server.NewStreamableHTTPServer(mcpServer)
+	upstream := &testUpstream{}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upstream.down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		httpServer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// testSpec describes a member connecting to upstream, starts counts the clients built for it.
+func testSpec(t *testing.T, upstream *testUpstream, tier int, limiter *callLimiter, starts *atomic.Int64) *memberSpec {
+	t.Helper()
+	policy, err := egress.NewPolicyFromConfig(egress.Config{AllowCIDRs: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &model.MCPClientConfig{TransportType: model.MCPClientTypeStreamable, URL: upstream.URL}
+	return &memberSpec{
+		endpoint: upstream.URL,
+		tier:     tier,
+		newClient: func() (*MCPClient, error) {
+			starts.Add(1)
+			return NewMCPClientService("echo", conf, secret.NewResolver(nil, nil), nil, limiter, policy, nil,
+				telemetry.NewNoopCustomMetrics(), zap.NewNop())
+		},
+	}
+}
+
+func callEcho(t *testing.T, pool *clientPool) string {
+	t.Helper()
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "echo"
+	result, err := pool.callTool(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Content[0].(mcp.TextContent).Text
+}
+
+func newTestPool(t *testing.T, limiter *callLimiter, specs ...*memberSpec) *clientPool {
+	t.Helper()
+	pool, err := newClientPool("echo", nil, specs, limiter, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+	if err := pool.AddToMCPServer(context.Background(), server.NewMCPServer("echo", "1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestClientPoolStartsFallbacksOnlyWhenNeeded(t *testing.T) {
+	primary, fallback := newTestUpstream(t, "primary"), newTestUpstream(t, "fallback")
+	limiter := newCallLimiter("echo", nil, telemetry.NewNoopCustomMetrics())
+	var primaryStarts, fallbackStarts atomic.Int64
+	pool := newTestPool(t, limiter, testSpec(t, primary, 0, limiter, &primaryStarts), testSpec(t, fallback, 1, limiter, &fallbackStarts))
+
+	if got := callEcho(t, pool); got != "primary" {
+		t.Fatalf("call served by %s, want primary", got)
+	}
+	pool.reconnectMembers()
+	if fallbackStarts.Load() != 0 {
+		t.Fatalf("fallback started %d times while the primary serves", fallbackStarts.Load())
+	}
+	if primaryStarts.Load() != 1 {
+		t.Fatalf("primary started %d times, want 1", primaryStarts.Load())
+	}
+}
+
+func TestClientPoolRestoresPrimary(t *testing.T) {
+	primary, fallback := newTestUpstream(t, "primary"), newTestUpstream(t, "fallback")
+	primary.down.Store(true)
+	limiter := newCallLimiter("echo", nil, telemetry.NewNoopCustomMetrics())
+	var primaryStarts, fallbackStarts atomic.Int64
+	pool := newTestPool(t, limiter, testSpec(t, primary, 0, limiter, &primaryStarts), testSpec(t, fallback, 1, limiter, &fallbackStarts))
+
+	if got := callEcho(t, pool); got != "fallback" {
+		t.Fatalf("call served by %s, want fallback", got)
+	}
+	if state, _ := pool.state(pool.Status()); state != UpstreamDegraded {
+		t.Errorf("state = %s, want %s", state, UpstreamDegraded)
+	}
+
+	// the primary is retried in the background, still down
+	pool.reconnectMembers()
+	if got := callEcho(t, pool); got != "fallback" {
+		t.Fatalf("call served by %s, want fallback", got)
+	}
+
+	primary.down.Store(false)
+	pool.reconnectMembers()
+	if got := callEcho(t, pool); got != "primary" {
+		t.Fatalf("call served by %s after the primary recovered, want primary", got)
+	}
+	if state, _ := pool.state(pool.Status()); state != UpstreamUp {
+		t.Errorf("state = %s, want %s", state, UpstreamUp)
+	}
+	if primaryStarts.Load() != 3 || fallbackStarts.Load() != 1 {
+		t.Errorf("started primary %d and fallback %d times, want 3 and 1", primaryStarts.Load(), fallbackStarts.Load())
+	}
+}
+
+func TestClientPoolReconnectsReplicas(t *testing.T) {
+	first, second := newTestUpstream(t, "first"), newTestUpstream(t, "second")
+	second.down.Store(true)
+	limiter := newCallLimiter("echo", nil, telemetry.NewNoopCustomMetrics())
+	var starts atomic.Int64
+	pool := newTestPool(t, limiter, testSpec(t, first, 0, limiter, &starts), testSpec(t, second, 0, limiter, &starts))
+	if members := pool.connected(); len(members) != 1 {
+		t.Fatalf("%d members connected, want 1", len(members))
+	}
+
+	second.down.Store(false)
+	pool.reconnectMembers()
+	if members := pool.connected(); len(members) != 2 {
+		t.Fatalf("%d members connected after the replica recovered, want 2", len(members))
+	}
+	served := map[string]bool{}
+	for range 4 {
+		served[callEcho(t, pool)] = true
+	}
+	if !served["first"] || !served["second"] {
+		t.Errorf("calls served by %v, want both replicas", served)
+	}
+}
+
+func TestClientPoolClosedWhileConnecting(t *testing.T) {
+	upstream := newTestUpstream(t, "primary")
+	limiter := newCallLimiter("echo", nil, telemetry.NewNoopCustomMetrics())
+	var starts atomic.Int64
+	spec := testSpec(t, upstream, 0, limiter, &starts)
+	newClient := spec.newClient
+	connecting, release := make(chan struct{}), make(chan struct{})
+	spec.newClient = func() (*MCPClient, error) {
+		close(connecting)
+		<-release
+		return newClient()
+	}
+	pool, err := newClientPool("echo", nil, []*memberSpec{spec}, limiter, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected := make(chan error, 1)
+	catalog := &upstreamCatalog{Tools: []mcp.Tool{mcp.NewTool("echo")}}
+	pool.AddToMCPServerLazily(server.NewMCPServer("echo", "1.0.0"), catalog, func(catalog *upstreamCatalog, err error) {
+		connected <- err
+	})
+	<-connecting
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-connected; !errors.Is(err, errPoolClosed) {
+		t.Fatalf("connect error = %v, want errPoolClosed", err)
+	}
+	if members := pool.connected(); len(members) != 0 {
+		t.Fatalf("%d members added to the closed pool", len(members))
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "echo"
+	if _, err := pool.callTool(context.Background(), request); !errors.Is(err, errPoolClosed) {
+		t.Fatalf("callTool() error = %v, want errPoolClosed", err)
+	}
+}
+
+func TestClientPoolWithoutMembers(t *testing.T) {
+	upstream := newTestUpstream(t, "primary")
+	limiter := newCallLimiter("echo", nil, telemetry.NewNoopCustomMetrics())
+	var starts atomic.Int64
+	pool, err := newClientPool("echo", nil, []*memberSpec{testSpec(t, upstream, 0, limiter, &starts)}, limiter, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+	// the connection is over without any member added, as when the pool closed meanwhile
+	close(pool.ready)
+	if member := pool.pick(); member != nil {
+		t.Fatalf("pick() = %v, want nil", member)
+	}
+	if pool.OwnsCompletionRef(mcp.PromptReference{Type: "ref/prompt", Name: "greet"}) {
+		t.Error("OwnsCompletionRef() without member = true")
+	}
+	if _, err := pool.Complete(context.Background(), mcp.CompleteRequest{}); !errors.Is(err, errPoolClosed) {
+		t.Errorf("Complete() error = %v, want errPoolClosed", err)
+	}
+	if err := pool.Subscribe(context.Background(), "session", "file:///readme"); !errors.Is(err, errPoolClosed) {
+		t.Errorf("Subscribe() error = %v, want errPoolClosed", err)
+	}
+	if starts.Load() != 0 {
+		t.Errorf("started %d clients, want 0", starts.Load())
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"net/http"
+	"strings"
+)
+
+// reservedHeaders are managed by the transports and cannot be forwarded upstream
+var reservedHeaders = map[string]struct{}{
+	"Accept":               {},
+	"Connection":           {},
+	"Content-Length":       {},
+	"Content-Type":         {},
+	"Host":                 {},
+	"Mcp-Protocol-Version": {},
+	"Mcp-Session-Id":       {},
+	"Transfer-Encoding":    {},
+}
+
+type incomingHeadersKey struct{}
+
+// withIncomingHeaders stores the headers of the downstream request in the context of the calls it triggers.
+func withIncomingHeaders(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, incomingHeadersKey{}, header)
+}
+
+func incomingHeaders(ctx context.Context) http.Header {
+	header, _ := ctx.Value(incomingHeadersKey{}).(http.Header)
+	return header
+}
+
+// headerForwarder builds the headers passed through to the upstream server on each call.
+// The upstream client is shared by all the downstream sessions, so the headers are taken from
+// the context of every call rather than set once on the transport.
+type headerForwarder struct {
+	rules []model.ForwardHeader
+}
+
+func newHeaderForwarder(rules []model.ForwardHeader) (*headerForwarder, error) {
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("forwarded header has no name")
+		}
+		name := rule.As
+		if name == "" {
+			name = rule.Name
+		}
+		if _, ok := reservedHeaders[http.CanonicalHeaderKey(name)]; ok {
+			return nil, fmt.Errorf("header %s cannot be forwarded", name)
+		}
+	}
+	return &headerForwarder{rules: rules}, nil
+}
+
+func (f *headerForwarder) headers(ctx context.Context) map[string]string {
+	incoming := incomingHeaders(ctx)
+	if incoming == nil {
+		return nil
+	}
+	headers := make(map[string]string, len(f.rules))
+	for _, rule := range f.rules {
+		value := incoming.Get(rule.Name)
+		if value == "" {
+			continue
+		}
+		if rule.Template != "" {
+			value = strings.ReplaceAll(rule.Template, "${value}", value)
+		}
+		name := rule.As
+		if name == "" {
+			name = rule.Name
+		}
+		headers[name] = value
+	}
+	return headers
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"github.com/tomeai/mcp-gateway/model"
+	"maps"
+	"net/http"
+	"testing"
+)
+
+func TestHeaderForwarder(t *testing.T) {
+	incoming := http.Header{"X-Api-Key": {"key"}, "X-Tenant": {"acme"}, "Cookie": {"session=1"}}
+	tests := []struct {
+		name  string
+		rules []model.ForwardHeader
+		want  map[string]string
+	}{
+		{"allow list", []model.ForwardHeader{{Name: "x-api-key"}}, map[string]string{"x-api-key": "key"}},
+		{"rename", []model.ForwardHeader{{Name: "X-Api-Key", As: "Authorization"}}, map[string]string{"Authorization": "key"}},
+		{"template", []model.ForwardHeader{{Name: "X-Api-Key", As: "Authorization", Template: "Bearer ${value}"}},
+			map[string]string{"Authorization": "Bearer key"}},
+		{"missing header", []model.ForwardHeader{{Name: "X-Missing"}, {Name: "X-Tenant"}}, map[string]string{"X-Tenant": "acme"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarder, err := newHeaderForwarder(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := forwarder.headers(withIncomingHeaders(context.Background(), incoming))
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("headers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderForwarderRejectsRules(t *testing.T) {
+	for _, rule := range []model.ForwardHeader{{}, {Name: "mcp-session-id"}, {Name: "X-Host", As: "Host"}} {
+		if _, err := newHeaderForwarder([]model.ForwardHeader{rule}); err == nil {
+			t.Errorf("newHeaderForwarder accepted %+v", rule)
+		}
+	}
+}
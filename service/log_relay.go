@@ -0,0 +1,43 @@
+package service
+
+import (
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"sync"
+)
+
+// logRelay forwards upstream log messages to the downstream sessions that called logging/setLevel.
+// The level each session asked for is kept by the streamable http server and applied on sending.
+type logRelay struct {
+	mu        sync.RWMutex
+	mcpServer *server.MCPServer
+	sessions  map[string]struct{}
+}
+
+func newLogRelay(mcpServer *server.MCPServer) *logRelay {
+	return &logRelay{
+		mcpServer: mcpServer,
+		sessions:  make(map[string]struct{}),
+	}
+}
+
+func (l *logRelay) addSession(sessionID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessions[sessionID] = struct{}{}
+}
+
+func (l *logRelay) removeSession(sessionID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, sessionID)
+}
+
+func (l *logRelay) relay(message mcp.LoggingMessageNotification) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for sessionID := range l.sessions {
+		// sessions without an open listening stream simply miss the message
+		_ = l.mcpServer.SendLogMessageToSpecificClient(sessionID, message)
+	}
+}
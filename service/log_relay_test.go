@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"testing"
+	"time"
+)
+
+func setLevel(t *testing.T, session *client.Client, level mcp.LoggingLevel) {
+	t.Helper()
+	request := mcp.SetLevelRequest{}
+	request.Params.Level = level
+	if err := session.SetLevel(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogRelayHonoursSessionLevels(t *testing.T) {
+	mcpServer := server.NewMCPServer("upstream", "1.0.0", server.WithLogging())
+	upstream := newInterceptingUpstream(t, mcpServer, nil, nil)
+	_, url := serveTestProxy(t, upstream.URL)
+	debug, debugMessages := newTestSession(t, url)
+	setLevel(t, debug, mcp.LoggingLevelDebug)
+	errorOnly, errorMessages := newTestSession(t, url)
+	setLevel(t, errorOnly, mcp.LoggingLevelError)
+	// a session that never set a level gets no log messages
+	_, silentMessages := newTestSession(t, url)
+
+	emit := func() {
+		for _, level := range []mcp.LoggingLevel{mcp.LoggingLevelWarning, mcp.LoggingLevelError} {
+			mcpServer.SendNotificationToAllClients(methodNotificationMessage, map[string]any{
+				"level":  level,
+				"logger": "upstream",
+				"data":   string(level) + " message",
+			})
+		}
+	}
+	tests := []struct {
+		name     string
+		messages <-chan mcp.JSONRPCNotification
+		want     mcp.LoggingLevel
+	}{
+		{"debug level", debugMessages, mcp.LoggingLevelWarning},
+		{"error level", errorMessages, mcp.LoggingLevelError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first message relayed is the first one at or above the level of the session
+			notification := awaitNotification(t, tt.messages, methodNotificationMessage, emit)
+			if level := notification.Params.AdditionalFields["level"]; level != string(tt.want) {
+				t.Errorf("level = %v, want %s", level, tt.want)
+			}
+			if data := notification.Params.AdditionalFields["data"]; data != string(tt.want)+" message" {
+				t.Errorf("data = %v, want %s message", data, tt.want)
+			}
+		})
+	}
+
+	select {
+	case notification := <-silentMessages:
+		if notification.Method == methodNotificationMessage {
+			t.Errorf("session without a level received %v", notification.Params.AdditionalFields)
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
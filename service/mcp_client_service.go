@@ -8,18 +8,60 @@ import (
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/sandbox"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
 	"github.com/tomeai/mcp-gateway/model"
 	"go.uber.org/zap"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// methodNotificationMessage is the method of the log message notifications sent by servers
+const methodNotificationMessage = "notifications/message"
+
+// defaultPingInterval is the interval of the pings of the SSE and streamable HTTP servers
+const defaultPingInterval = 30 * time.Second
+
 type MCPClient struct {
-	name            string
-	needPing        bool
-	needManualStart bool
-	client          *client.Client
-	logger          *zap.Logger
+	name         string
+	needPing     bool
+	pingInterval time.Duration
+	// lastPing is the time of the last successful ping in unix nanoseconds, pingFailures counts the failed ones
+	lastPing      atomic.Int64
+	pingFailures  atomic.Int64
+	client        *client.Client
+	transport     *upstreamTransport
+	transportType model.MCPClientType
+	// sandbox of the command of a stdio server
+	sandbox *sandbox.Sandbox
+	// limits bounds the tool calls, prompt gets and resource reads, shared by the instances of the server
+	limits        *callLimiter
+	breaker       *circuitBreaker
+	retry         *retryPolicy
+	metrics       telemetry.CustomMetrics
+	subscriptions *resourceSubscriptions
+	logs          *logRelay
+	// logLevel is the minimum level of the upstream log messages the gateway captures
+	logLevel mcp.LoggingLevel
+
+	// names of the prompts and uris of the resources (templates) registered from the upstream,
+	// used to route completion requests, and of the tools whose calls are retried
+	mu                sync.RWMutex
+	prompts           map[string]struct{}
+	resourceTemplates map[string]struct{}
+	retryTools        map[string]struct{}
+	logger            *zap.Logger
+
+	// ctx bounds the lifetime of the upstream connection, it is cancelled on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 func parseMCPClientConfig(conf *model.MCPClientConfig) (any, error) {
@@ -31,91 +73,180 @@ func parseMCPClientConfig(conf *model.MCPClientConfig) (any, error) {
 			Command: conf.Command,
 			Env:     conf.Env,
 			Args:    conf.Args,
+			Sandbox: conf.Sandbox,
 		}, nil
 	}
 	if conf.URL != "" {
 		if conf.TransportType == model.MCPClientTypeStreamable {
 			return &model.StreamableMCPClientConfig{
-				URL:     conf.URL,
-				Headers: conf.Headers,
-				Timeout: conf.Timeout,
+				URL:            conf.URL,
+				Headers:        conf.Headers,
+				ForwardHeaders: conf.ForwardHeaders,
+				OAuth:          conf.OAuth,
+				TLS:            conf.TLS,
+				Proxy:          conf.Proxy,
+				Timeout:        conf.Timeout,
 			}, nil
 		} else {
 			return &model.SSEMCPClientConfig{
-				URL:     conf.URL,
-				Headers: conf.Headers,
+				URL:            conf.URL,
+				Headers:        conf.Headers,
+				ForwardHeaders: conf.ForwardHeaders,
+				OAuth:          conf.OAuth,
+				TLS:            conf.TLS,
+				Proxy:          conf.Proxy,
 			}, nil
 		}
 	}
 	return nil, errors.New("invalid server type")
 }
 
-func NewMCPClientService(name string, conf *model.MCPClientConfig, logger *zap.Logger) (*MCPClient, error) {
+// NewMCPClientService builds the client of an upstream server, decrypting and interpolating its config.
+// tokens persists the OAuth tokens of the servers configured for oauth,
+// the egress policy is checked again here as the interpolated url may differ from the saved one,
+// the commands of stdio servers run in a sandbox of the sandbox policy.
+// limits enforces the call limits of the server, shared by all the instances serving it.
+func NewMCPClientService(name string, conf *model.MCPClientConfig, secrets *secret.Resolver, tokens *oauthTokenStore, limits *callLimiter, egress *egress.Policy, sandboxPolicy *sandbox.Policy, metrics telemetry.CustomMetrics, logger *zap.Logger) (*MCPClient, error) {
+	conf, err := resolveMCPClientConfig(conf, secrets)
+	if err != nil {
+		return nil, err
+	}
 	clientInfo, pErr := parseMCPClientConfig(conf)
 	if pErr != nil {
 		return nil, pErr
 	}
+	var (
+		upstream      *upstreamTransport
+		transportType model.MCPClientType
+		box           *sandbox.Sandbox
+		needPing      bool
+	)
 	switch v := clientInfo.(type) {
 	case *model.StdioMCPClientConfig:
 		envs := make([]string, 0, len(v.Env))
 		for kk, vv := range v.Env {
 			envs = append(envs, fmt.Sprintf("%s=%s", kk, vv))
 		}
-		mcpClient, err := client.NewStdioMCPClient(v.Command, envs, v.Args...)
+		box, err = sandboxPolicy.Prepare(name, v.Command, v.Sandbox)
 		if err != nil {
 			return nil, err
 		}
-
-		return &MCPClient{
-			name:   name,
-			client: mcpClient,
-			logger: logger,
-		}, nil
+		stdioTransport := transport.NewStdioWithOptions(v.Command, envs, v.Args, transport.WithCommandFunc(box.Command))
+		if err := stdioTransport.Start(context.Background()); err != nil {
+			_ = box.Close()
+			return nil, fmt.Errorf("failed to start stdio transport: %w", err)
+		}
+		upstream = newUpstreamTransport(stdioTransport, true)
+		transportType = model.MCPClientTypeStdio
 	case *model.SSEMCPClientConfig:
-		var options []transport.ClientOption
+		httpClient, err := newUpstreamHTTPClient(v.URL, v.Proxy, v.TLS, egress)
+		if err != nil {
+			return nil, err
+		}
+		options := []transport.ClientOption{transport.WithHTTPClient(httpClient)}
 		if len(v.Headers) > 0 {
 			options = append(options, client.WithHeaders(v.Headers))
 		}
-		mcpClient, err := client.NewSSEMCPClient(v.URL, options...)
+		if len(v.ForwardHeaders) > 0 {
+			forwarder, err := newHeaderForwarder(v.ForwardHeaders)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, client.WithHeaderFunc(forwarder.headers))
+		}
+		if v.OAuth != nil {
+			if tokens == nil {
+				return nil, errors.New("oauth requires a token store")
+			}
+			options = append(options, transport.WithOAuth(tokens.oauthConfig(v.OAuth, httpClient)))
+		}
+		sseTransport, err := transport.NewSSE(v.URL, options...)
 		if err != nil {
 			return nil, err
 		}
-		return &MCPClient{
-			name:            name,
-			needPing:        true,
-			needManualStart: true,
-			client:          mcpClient,
-			logger:          logger,
-		}, nil
+		upstream = newUpstreamTransport(sseTransport, false)
+		transportType = model.MCPClientTypeSSE
+		needPing = true
 	case *model.StreamableMCPClientConfig:
-		var options []transport.StreamableHTTPCOption
+		httpClient, err := newUpstreamHTTPClient(v.URL, v.Proxy, v.TLS, egress)
+		if err != nil {
+			return nil, err
+		}
+		options := []transport.StreamableHTTPCOption{
+			// keep a listening stream open so that server initiated notifications
+			// (e.g. resource updates) reach the gateway
+			transport.WithContinuousListening(),
+			// the client comes before WithHTTPTimeout, which sets its timeout
+			transport.WithHTTPBasicClient(httpClient),
+		}
 		if len(v.Headers) > 0 {
 			options = append(options, transport.WithHTTPHeaders(v.Headers))
 		}
+		if len(v.ForwardHeaders) > 0 {
+			forwarder, err := newHeaderForwarder(v.ForwardHeaders)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, transport.WithHTTPHeaderFunc(forwarder.headers))
+		}
+		if v.OAuth != nil {
+			if tokens == nil {
+				return nil, errors.New("oauth requires a token store")
+			}
+			options = append(options, transport.WithHTTPOAuth(tokens.oauthConfig(v.OAuth, httpClient)))
+		}
 		if v.Timeout > 0 {
 			options = append(options, transport.WithHTTPTimeout(v.Timeout))
 		}
-		mcpClient, err := client.NewStreamableHttpClient(v.URL, options...)
+		streamableTransport, err := transport.NewStreamableHTTP(v.URL, options...)
 		if err != nil {
 			return nil, err
 		}
-		return &MCPClient{
-			name:            name,
-			needPing:        true,
-			needManualStart: true,
-			client:          mcpClient,
-			logger:          logger,
-		}, nil
+		upstream = newUpstreamTransport(streamableTransport, false)
+		transportType = model.MCPClientTypeStreamable
+		needPing = true
+	default:
+		return nil, errors.New("invalid client type")
 	}
-	return nil, errors.New("invalid client type")
+	if metrics == nil {
+		metrics = telemetry.NewNoopCustomMetrics()
+	}
+	// the upstream connection outlives the ctx used to set it up
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MCPClient{
+		name:          name,
+		needPing:      needPing,
+		pingInterval:  defaultPingInterval,
+		client:        client.NewClient(upstream),
+		transport:     upstream,
+		transportType: transportType,
+		sandbox:       box,
+		limits:        limits,
+		breaker:       newCircuitBreaker(name, conf.CircuitBreaker, metrics, logger),
+		retry:         newRetryPolicy(conf.Retry),
+		metrics:       metrics,
+		logLevel:      mcp.LoggingLevel(conf.LogLevel),
+		logger:        logger,
+		ctx:           ctx,
+		cancel:        cancel,
+	}, nil
 }
 
-func (c *MCPClient) AddToMCPServer(ctx context.Context, mcpServer *server.MCPServer) error {
-	if c.needManualStart {
-		err := c.client.Start(ctx)
-		if err != nil {
-			return err
-		}
+// connect starts the connection to the upstream server and initializes it.
+// The notifications of the upstream server are relayed to the sessions of mcpServer.
+func (c *MCPClient) connect(ctx context.Context, mcpServer *server.MCPServer) (*mcp.InitializeResult, error) {
+	c.subscriptions = newResourceSubscriptions(c, mcpServer)
+	c.logs = newLogRelay(mcpServer)
+	c.prompts = make(map[string]struct{})
+	c.resourceTemplates = make(map[string]struct{})
+	c.retryTools = make(map[string]struct{})
+	c.client.OnNotification(c.handleNotification)
+
+	// the stdio transport is already running at this point, Start only wires
+	// up the notification handler for it
+	err := c.client.Start(c.ctx)
+	if err != nil {
+		return nil, err
 	}
 	initRequest := mcp.InitializeRequest{}
 	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
@@ -127,29 +258,296 @@ func (c *MCPClient) AddToMCPServer(ctx context.Context, mcpServer *server.MCPSer
 		Roots:        nil,
 		Sampling:     nil,
 	}
-	_, err := c.client.Initialize(ctx, initRequest)
+	initResult, err := c.client.Initialize(ctx, initRequest)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	c.logger.Info("Successfully initialized MCP client", zap.String("name", c.name))
 
-	err = c.addToolsToServer(ctx, mcpServer)
-	if err != nil {
-		return err
+	if initResult.Capabilities.Logging != nil && c.logLevel != "" {
+		setLevelRequest := mcp.SetLevelRequest{}
+		setLevelRequest.Params.Level = c.logLevel
+		if err := c.client.SetLevel(ctx, setLevelRequest); err != nil {
+			c.logger.Warn("Set upstream log level failed", zap.String("name", c.name), zap.Error(err))
+		}
 	}
-	_ = c.addPromptsToServer(ctx, mcpServer)
-	_ = c.addResourcesToServer(ctx, mcpServer)
-	_ = c.addResourceTemplatesToServer(ctx, mcpServer)
 
 	if c.needPing {
-		go c.startPingTask(ctx)
+		go c.startPingTask(c.ctx)
+	}
+	return initResult, nil
+}
+
+// upstreamCatalog holds the tools, prompts, resources and resource templates listed from an upstream server.
+type upstreamCatalog struct {
+	Tools             []mcp.Tool             `json:"tools,omitempty"`
+	Prompts           []mcp.Prompt           `json:"prompts,omitempty"`
+	Resources         []mcp.Resource         `json:"resources,omitempty"`
+	ResourceTemplates []mcp.ResourceTemplate `json:"resourceTemplates,omitempty"`
+	// Completions tells whether the upstream server declared the completions capability
+	Completions bool `json:"completions,omitempty"`
+}
+
+// upstreamCaller serves the calls of the tools, prompts and resources registered from an upstream server.
+type upstreamCaller interface {
+	callTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)
+	getPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error)
+	readResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error)
+}
+
+// register adds the tools, prompts, resources and resource templates of the catalog to mcpServer, served by caller,
+// in place of those of the previous catalog when it is not nil. The kinds empty in both are left unregistered,
+// mcp-go declaring the capability of a kind once registered.
+func (catalog *upstreamCatalog) register(mcpServer *server.MCPServer, caller upstreamCaller, previous *upstreamCatalog) {
+	if previous == nil {
+		previous = &upstreamCatalog{}
+	}
+	if len(catalog.Tools) > 0 || len(previous.Tools) > 0 {
+		tools := make([]server.ServerTool, 0, len(catalog.Tools))
+		for _, tool := range catalog.Tools {
+			tools = append(tools, server.ServerTool{Tool: tool, Handler: caller.callTool})
+		}
+		mcpServer.SetTools(tools...)
+	}
+	if len(catalog.Prompts) > 0 || len(previous.Prompts) > 0 {
+		prompts := make([]server.ServerPrompt, 0, len(catalog.Prompts))
+		for _, prompt := range catalog.Prompts {
+			prompts = append(prompts, server.ServerPrompt{Prompt: prompt, Handler: caller.getPrompt})
+		}
+		mcpServer.SetPrompts(prompts...)
+	}
+	if len(catalog.Resources) > 0 || len(previous.Resources) > 0 {
+		resources := make([]server.ServerResource, 0, len(catalog.Resources))
+		for _, resource := range catalog.Resources {
+			resources = append(resources, server.ServerResource{Resource: resource, Handler: caller.readResource})
+		}
+		mcpServer.SetResources(resources...)
+	}
+	if len(catalog.ResourceTemplates) > 0 || len(previous.ResourceTemplates) > 0 {
+		resourceTemplates := make([]server.ServerResourceTemplate, 0, len(catalog.ResourceTemplates))
+		for _, resourceTemplate := range catalog.ResourceTemplates {
+			resourceTemplates = append(resourceTemplates, server.ServerResourceTemplate{Template: resourceTemplate, Handler: caller.readResource})
+		}
+		mcpServer.SetResourceTemplates(resourceTemplates...)
+	}
+}
+
+// listCatalog lists the tools, prompts, resources and resource templates of the upstream server declared
+// in its capabilities, recording the completion refs and the tools retried. Failing to list the tools fails,
+// failing to list the prompts or resources only logs a warning.
+func (c *MCPClient) listCatalog(ctx context.Context, capabilities mcp.ServerCapabilities) (*upstreamCatalog, error) {
+	catalog := &upstreamCatalog{Completions: c.supportsCompletions()}
+	var err error
+	if catalog.Tools, err = c.listTools(ctx); err != nil {
+		return nil, err
+	}
+	if capabilities.Prompts != nil {
+		if catalog.Prompts, err = c.listPrompts(ctx); err != nil {
+			c.logger.Warn("List upstream prompts failed", zap.String("name", c.name), zap.Error(err))
+		}
+	}
+	if capabilities.Resources != nil {
+		if catalog.Resources, err = c.listResources(ctx); err != nil {
+			c.logger.Warn("List upstream resources failed", zap.String("name", c.name), zap.Error(err))
+		}
+		if catalog.ResourceTemplates, err = c.listResourceTemplates(ctx); err != nil {
+			c.logger.Warn("List upstream resource templates failed", zap.String("name", c.name), zap.Error(err))
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tool := range catalog.Tools {
+		if c.retry.retriesTool(tool) {
+			c.retryTools[tool.Name] = struct{}{}
+		}
+	}
+	for _, prompt := range catalog.Prompts {
+		c.prompts[prompt.Name] = struct{}{}
+	}
+	for _, resource := range catalog.Resources {
+		c.resourceTemplates[resource.URI] = struct{}{}
+	}
+	for _, resourceTemplate := range catalog.ResourceTemplates {
+		if resourceTemplate.URITemplate != nil {
+			c.resourceTemplates[resourceTemplate.URITemplate.Raw()] = struct{}{}
+		}
+	}
+	return catalog, nil
+}
+
+// forward runs a call to the upstream server: it fails fast while the circuit is open, applies the call limits,
+// retries the idempotent calls and tells the user to authorize the gateway again once the upstream server
+// rejected its OAuth tokens.
+func forward[T any](ctx context.Context, c *MCPClient, method mcp.MCPMethod, toolName string, idempotent bool, call func(ctx context.Context) (T, error)) (T, error) {
+	if err := c.breaker.allow(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+	if idempotent {
+		attempt := call
+		call = func(ctx context.Context) (T, error) {
+			return withRetry(ctx, c.retry, c.logger, c.name, method, attempt)
+		}
+	}
+	result, err := limitCall(ctx, c.limits, method, toolName, call)
+	c.breaker.record(ctx, err)
+	return result, asAuthorizationRequired(err, c.name)
+}
+
+// callTool forwards a tool call to the upstream server.
+func (c *MCPClient) callTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	start := time.Now()
+	result, err := forward(ctx, c, mcp.MethodToolsCall, request.Params.Name, c.retriesTool(request.Params.Name), func(ctx context.Context) (*mcp.CallToolResult, error) {
+		return c.client.CallTool(ctx, request)
+	})
+	outcome := telemetry.ToolCallOutcomeSuccess
+	if err != nil || result.IsError {
+		outcome = telemetry.ToolCallOutcomeError
+	}
+	c.metrics.RecordToolCall(ctx, c.name, request.Params.Name, outcome, time.Since(start))
+	return result, err
+}
+
+// getPrompt forwards a prompt get to the upstream server.
+func (c *MCPClient) getPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	return forward(ctx, c, mcp.MethodPromptsGet, "", true, func(ctx context.Context) (*mcp.GetPromptResult, error) {
+		return c.client.GetPrompt(ctx, request)
+	})
+}
+
+// readResource forwards a resource read to the upstream server.
+func (c *MCPClient) readResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	result, err := forward(ctx, c, mcp.MethodResourcesRead, "", true, func(ctx context.Context) (*mcp.ReadResourceResult, error) {
+		return c.client.ReadResource(ctx, request)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Contents, nil
+}
+
+// retriesTool tells whether the calls of a tool are retried.
+func (c *MCPClient) retriesTool(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.retryTools[name]
+	return ok
+}
+
+// CircuitStatus returns the state of the circuit breaker of the upstream server.
+func (c *MCPClient) CircuitStatus() CircuitStatus {
+	return c.breaker.status()
+}
+
+// handleNotification dispatches the notifications sent by the upstream server.
+func (c *MCPClient) handleNotification(notification mcp.JSONRPCNotification) {
+	switch notification.Method {
+	case mcp.MethodNotificationResourceUpdated:
+		uri, _ := notification.Params.AdditionalFields["uri"].(string)
+		if uri != "" {
+			c.subscriptions.notifyUpdated(uri)
+		}
+	case methodNotificationMessage:
+		c.handleLogMessage(notification)
+	}
+}
+
+// handleLogMessage captures a log message emitted by the upstream server and relays it
+// to the downstream sessions that asked for log messages.
+func (c *MCPClient) handleLogMessage(notification mcp.JSONRPCNotification) {
+	message := mcp.LoggingMessageNotification{}
+	message.Method = notification.Method
+	level, _ := notification.Params.AdditionalFields["level"].(string)
+	message.Params.Level = mcp.LoggingLevel(level)
+	message.Params.Logger, _ = notification.Params.AdditionalFields["logger"].(string)
+	message.Params.Data = notification.Params.AdditionalFields["data"]
+
+	if c.logLevel != "" && !message.Params.Level.ShouldSendTo(c.logLevel) {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("name", c.name),
+		zap.String("logLevel", level),
+		zap.String("loggerName", message.Params.Logger),
+		zap.Any("data", message.Params.Data),
+	}
+	switch message.Params.Level {
+	case mcp.LoggingLevelDebug:
+		c.logger.Debug("Upstream log message", fields...)
+	case mcp.LoggingLevelInfo, mcp.LoggingLevelNotice:
+		c.logger.Info("Upstream log message", fields...)
+	case mcp.LoggingLevelWarning:
+		c.logger.Warn("Upstream log message", fields...)
+	default:
+		c.logger.Error("Upstream log message", fields...)
+	}
+	c.logs.relay(message)
+}
+
+func (c *MCPClient) supportsSubscribe() bool {
+	resources := c.client.GetServerCapabilities().Resources
+	return resources != nil && resources.Subscribe
+}
+
+// Subscribe subscribes a downstream session to updates of the resource identified by uri.
+func (c *MCPClient) Subscribe(ctx context.Context, sessionID, uri string) error {
+	return c.subscriptions.subscribe(ctx, sessionID, uri)
+}
+
+// Unsubscribe cancels the subscription of a downstream session to the resource identified by uri.
+func (c *MCPClient) Unsubscribe(ctx context.Context, sessionID, uri string) error {
+	return c.subscriptions.unsubscribe(ctx, sessionID, uri)
+}
+
+// AddLogSession relays the upstream log messages to a downstream session from now on.
+func (c *MCPClient) AddLogSession(sessionID string) {
+	c.logs.addSession(sessionID)
+}
+
+// RemoveSession drops all the resource subscriptions and log relays held by a downstream session.
+func (c *MCPClient) RemoveSession(ctx context.Context, sessionID string) {
+	c.subscriptions.removeSession(ctx, sessionID)
+	c.logs.removeSession(sessionID)
+}
+
+// supportsCompletions reports whether the upstream server declared the completions capability.
+func (c *MCPClient) supportsCompletions() bool {
+	return c.transport.hasCapability("completions")
+}
+
+// OwnsCompletionRef reports whether the prompt or resource (template) referenced by a
+// completion/complete request has been registered from this upstream.
+func (c *MCPClient) OwnsCompletionRef(ref any) bool {
+	fields, ok := ref.(map[string]any)
+	if !ok {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch fields["type"] {
+	case "ref/prompt":
+		name, _ := fields["name"].(string)
+		_, ok = c.prompts[name]
+	case "ref/resource":
+		uri, _ := fields["uri"].(string)
+		_, ok = c.resourceTemplates[uri]
+	default:
+		ok = false
 	}
-	return nil
+	return ok
+}
+
+// Complete forwards an argument autocompletion request to the upstream server.
+func (c *MCPClient) Complete(ctx context.Context, request mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+	if !c.supportsCompletions() {
+		return nil, errors.New("upstream server does not support completions")
+	}
+	return c.client.Complete(ctx, request)
 }
 
 func (c *MCPClient) startPingTask(ctx context.Context) {
-	interval := 30 * time.Second
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(c.pingInterval)
 	defer ticker.Stop()
 
 	failCount := 0
@@ -159,13 +557,19 @@ func (c *MCPClient) startPingTask(ctx context.Context) {
 			c.logger.Info("Stopping ping", zap.String("name", c.name))
 			return
 		case <-ticker.C:
-			if err := c.client.Ping(ctx); err != nil {
-				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-					return
-				}
+			err := c.client.Ping(ctx)
+			if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+				return
+			}
+			c.breaker.record(ctx, err)
+			if err != nil {
+				c.pingFailures.Add(1)
 				failCount++
 				c.logger.Info("Ping failed", zap.String("name", c.name), zap.String("count", strconv.Itoa(failCount)))
-			} else if failCount > 0 {
+				continue
+			}
+			c.lastPing.Store(time.Now().UnixNano())
+			if failCount > 0 {
 				c.logger.Info("Stopping ping", zap.String("name", c.name), zap.Int("failCount", failCount))
 				failCount = 0
 			}
@@ -173,112 +577,88 @@ func (c *MCPClient) startPingTask(ctx context.Context) {
 	}
 }
 
-func (c *MCPClient) addToolsToServer(ctx context.Context, mcpServer *server.MCPServer) error {
+func (c *MCPClient) listTools(ctx context.Context) ([]mcp.Tool, error) {
+	var all []mcp.Tool
 	toolsRequest := mcp.ListToolsRequest{}
-	filterFunc := func(toolName string) bool {
-		return true
-	}
-
 	for {
-		tools, err := c.client.ListTools(ctx, toolsRequest)
+		tools, err := withRetry(ctx, c.retry, c.logger, c.name, mcp.MethodToolsList, func(ctx context.Context) (*mcp.ListToolsResult, error) {
+			return c.client.ListTools(ctx, toolsRequest)
+		})
 		if err != nil {
-			return err
-		}
-		if len(tools.Tools) == 0 {
-			break
-		}
-		for _, tool := range tools.Tools {
-			if filterFunc(tool.Name) {
-				mcpServer.AddTool(tool, c.client.CallTool)
-			}
+			return nil, err
 		}
-		if tools.NextCursor == "" {
-			break
+		all = append(all, tools.Tools...)
+		if len(tools.Tools) == 0 || tools.NextCursor == "" {
+			return all, nil
 		}
 		toolsRequest.Params.Cursor = tools.NextCursor
 	}
-	return nil
 }
 
-func (c *MCPClient) addPromptsToServer(ctx context.Context, mcpServer *server.MCPServer) error {
+func (c *MCPClient) listPrompts(ctx context.Context) ([]mcp.Prompt, error) {
+	var all []mcp.Prompt
 	promptsRequest := mcp.ListPromptsRequest{}
 	for {
-		prompts, err := c.client.ListPrompts(ctx, promptsRequest)
+		prompts, err := withRetry(ctx, c.retry, c.logger, c.name, mcp.MethodPromptsList, func(ctx context.Context) (*mcp.ListPromptsResult, error) {
+			return c.client.ListPrompts(ctx, promptsRequest)
+		})
 		if err != nil {
-			return err
-		}
-		if len(prompts.Prompts) == 0 {
-			break
-		}
-		for _, prompt := range prompts.Prompts {
-			mcpServer.AddPrompt(prompt, c.client.GetPrompt)
+			return nil, err
 		}
-		if prompts.NextCursor == "" {
-			break
+		all = append(all, prompts.Prompts...)
+		if len(prompts.Prompts) == 0 || prompts.NextCursor == "" {
+			return all, nil
 		}
 		promptsRequest.Params.Cursor = prompts.NextCursor
 	}
-	return nil
 }
 
-func (c *MCPClient) addResourcesToServer(ctx context.Context, mcpServer *server.MCPServer) error {
+func (c *MCPClient) listResources(ctx context.Context) ([]mcp.Resource, error) {
+	var all []mcp.Resource
 	resourcesRequest := mcp.ListResourcesRequest{}
 	for {
-		resources, err := c.client.ListResources(ctx, resourcesRequest)
+		resources, err := withRetry(ctx, c.retry, c.logger, c.name, mcp.MethodResourcesList, func(ctx context.Context) (*mcp.ListResourcesResult, error) {
+			return c.client.ListResources(ctx, resourcesRequest)
+		})
 		if err != nil {
-			return err
-		}
-		if len(resources.Resources) == 0 {
-			break
-		}
-		for _, resource := range resources.Resources {
-			mcpServer.AddResource(resource, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
-				readResource, e := c.client.ReadResource(ctx, request)
-				if e != nil {
-					return nil, e
-				}
-				return readResource.Contents, nil
-			})
+			return nil, err
 		}
-		if resources.NextCursor == "" {
-			break
+		all = append(all, resources.Resources...)
+		if len(resources.Resources) == 0 || resources.NextCursor == "" {
+			return all, nil
 		}
 		resourcesRequest.Params.Cursor = resources.NextCursor
-
 	}
-	return nil
 }
 
-func (c *MCPClient) addResourceTemplatesToServer(ctx context.Context, mcpServer *server.MCPServer) error {
+func (c *MCPClient) listResourceTemplates(ctx context.Context) ([]mcp.ResourceTemplate, error) {
+	var all []mcp.ResourceTemplate
 	resourceTemplatesRequest := mcp.ListResourceTemplatesRequest{}
 	for {
-		resourceTemplates, err := c.client.ListResourceTemplates(ctx, resourceTemplatesRequest)
+		resourceTemplates, err := withRetry(ctx, c.retry, c.logger, c.name, mcp.MethodResourcesTemplatesList, func(ctx context.Context) (*mcp.ListResourceTemplatesResult, error) {
+			return c.client.ListResourceTemplates(ctx, resourceTemplatesRequest)
+		})
 		if err != nil {
-			return err
-		}
-		if len(resourceTemplates.ResourceTemplates) == 0 {
-			break
-		}
-		for _, resourceTemplate := range resourceTemplates.ResourceTemplates {
-			mcpServer.AddResourceTemplate(resourceTemplate, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
-				readResource, e := c.client.ReadResource(ctx, request)
-				if e != nil {
-					return nil, e
-				}
-				return readResource.Contents, nil
-			})
+			return nil, err
 		}
-		if resourceTemplates.NextCursor == "" {
-			break
+		all = append(all, resourceTemplates.ResourceTemplates...)
+		if len(resourceTemplates.ResourceTemplates) == 0 || resourceTemplates.NextCursor == "" {
+			return all, nil
 		}
 		resourceTemplatesRequest.Params.Cursor = resourceTemplates.NextCursor
 	}
-	return nil
 }
 
+// Close stops the upstream connection and command, it may be called more than once.
 func (c *MCPClient) Close() error {
-	if c.client != nil {
-		return c.client.Close()
-	}
-	return nil
+	c.closeOnce.Do(func() {
+		c.cancel()
+		if c.client != nil {
+			c.closeErr = c.client.Close()
+		}
+		if c.sandbox != nil {
+			c.closeErr = errors.Join(c.closeErr, c.sandbox.Close())
+		}
+	})
+	return c.closeErr
 }
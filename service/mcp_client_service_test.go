@@ -0,0 +1,25 @@
+package service
+
+import (
+	"errors"
+	"github.com/tomeai/mcp-gateway/internal/sandbox"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestStdioCommandNotAllowed(t *testing.T) {
+	t.Setenv("SERVER_COMMAND", "bash")
+	policy := sandbox.NewPolicyFromConfig(sandbox.Config{AllowCommands: []string{"npx", "uvx"}, WorkDir: t.TempDir()})
+	// the command is checked once interpolated, before it runs
+	for _, command := range []string{"bash", "${SERVER_COMMAND}", "/usr/bin/npx"} {
+		conf := &model.MCPClientConfig{TransportType: model.MCPClientTypeStdio, Command: command, Args: []string{"-c", "exit 1"}}
+		_, err := NewMCPClientService("stdio", conf, secret.NewResolver(nil, nil), nil, nil, nil, policy,
+			telemetry.NewNoopCustomMetrics(), zap.NewNop())
+		if !errors.Is(err, sandbox.ErrCommandNotAllowed) {
+			t.Errorf("NewMCPClientService(%s) error = %v, want ErrCommandNotAllowed", command, err)
+		}
+	}
+}
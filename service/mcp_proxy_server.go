@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"io"
+	"net/http"
+)
+
+// methods not implemented by mcp-go's server
+const (
+	methodResourcesSubscribe   mcp.MCPMethod = "resources/subscribe"
+	methodResourcesUnsubscribe mcp.MCPMethod = "resources/unsubscribe"
+	methodCompletionComplete   mcp.MCPMethod = "completion/complete"
+)
+
+// jsonRPCMessage holds the fields of an incoming JSON-RPC message the proxy needs for routing.
+type jsonRPCMessage struct {
+	ID     mcp.RequestId   `json:"id"`
+	Method mcp.MCPMethod   `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// mcpProxyServer is the downstream streamable http endpoint built for one upstream server.
+// It handles the few JSON-RPC methods mcp-go's server does not implement itself and hands
+// everything else over to the streamable http server.
+type mcpProxyServer struct {
+	serverName string
+	userId     string
+	// critical tells whether the server is critical to the readiness of the gateway
+	critical   bool
+	mcpServer  *server.MCPServer
+	httpServer *server.StreamableHTTPServer
+	// sessions tracks the sessions of the streamable http server, for the methods the proxy handles itself
+	sessions server.SessionIdManager
+	client   *clientPool
+}
+
+func newMCPProxyServer(serverName, userId string, critical bool, mcpServer *server.MCPServer, pool *clientPool) *mcpProxyServer {
+	sessions := &server.InsecureStatefulSessionIdManager{}
+	return &mcpProxyServer{
+		serverName: serverName,
+		userId:     userId,
+		critical:   critical,
+		mcpServer:  mcpServer,
+		// the server is stateful so that sessions (and the subscriptions they hold)
+		// survive across requests
+		httpServer: server.NewStreamableHTTPServer(mcpServer, server.WithSessionIdManager(sessions)),
+		sessions:   sessions,
+		client:     pool,
+	}
+}
+
+func (p *mcpProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// make the request headers available to the upstream calls for header forwarding
+	r = r.WithContext(withIncomingHeaders(r.Context(), r.Header))
+	switch r.Method {
+	case http.MethodPost:
+		if p.handleProxyMethod(w, r) {
+			return
+		}
+	case http.MethodDelete:
+		if sessionID := r.Header.Get(server.HeaderKeySessionID); sessionID != "" {
+			p.client.RemoveSession(r.Context(), sessionID)
+		}
+	}
+	p.httpServer.ServeHTTP(w, r)
+}
+
+// handleProxyMethod answers the request if it is one of the methods handled by the proxy itself.
+// It reports whether a response has been written; otherwise the request body is left intact.
+func (p *mcpProxyServer) handleProxyMethod(w http.ResponseWriter, r *http.Request) bool {
+	var body io.Reader = r.Body
+	if maxBytes := p.client.limits().limits.MaxRequestBytes; maxBytes > 0 {
+		// read one byte past the limit to tell an oversized message apart
+		body = io.LimitReader(r.Body, maxBytes+1)
+	}
+	rawData, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	if err := p.client.limits().checkRequestSize(r.Context(), int64(len(rawData))); err != nil {
+		// the id of a truncated message is unknown, answer with a null id
+		writeJSONRPCError(w, mcp.NewRequestId(nil), mcp.INVALID_REQUEST, err.Error())
+		return true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(rawData))
+
+	var message jsonRPCMessage
+	if err := sonic.Unmarshal(rawData, &message); err != nil || message.ID.IsNil() {
+		// leave malformed messages and notifications to the streamable http server
+		return false
+	}
+
+	sessionID := r.Header.Get(server.HeaderKeySessionID)
+	switch message.Method {
+	case mcp.MethodInitialize:
+		if !p.client.supportsCompletions() {
+			return false
+		}
+		p.serveInitialize(w, r)
+		return true
+	case methodCompletionComplete:
+		request := mcp.CompleteRequest{}
+		if err := sonic.Unmarshal(message.Params, &request.Params); err != nil {
+			writeJSONRPCError(w, message.ID, mcp.INVALID_PARAMS, err.Error())
+			return true
+		}
+		if err := p.client.wait(r.Context()); err != nil {
+			writeJSONRPCError(w, message.ID, mcp.INTERNAL_ERROR, err.Error())
+			return true
+		}
+		if !p.client.OwnsCompletionRef(request.Params.Ref) {
+			writeJSONRPCError(w, message.ID, mcp.INVALID_PARAMS, "unknown prompt or resource reference")
+			return true
+		}
+		result, err := p.client.Complete(r.Context(), request)
+		if err != nil {
+			writeJSONRPCError(w, message.ID, mcp.INTERNAL_ERROR, err.Error())
+			return true
+		}
+		writeJSONRPCResult(w, message.ID, result)
+		return true
+	case methodResourcesSubscribe, methodResourcesUnsubscribe:
+		var params mcp.SubscribeParams
+		if err := sonic.Unmarshal(message.Params, &params); err != nil || params.URI == "" {
+			writeJSONRPCError(w, message.ID, mcp.INVALID_PARAMS, "uri is required")
+			return true
+		}
+		// subscriptions are held by a session, answer unknown ones the way the streamable http server does
+		isTerminated, err := p.sessions.Validate(sessionID)
+		if err != nil {
+			http.Error(w, "Invalid session ID", http.StatusBadRequest)
+			return true
+		}
+		if isTerminated {
+			http.Error(w, "Session terminated", http.StatusNotFound)
+			return true
+		}
+		if message.Method == methodResourcesSubscribe {
+			err = p.client.Subscribe(r.Context(), sessionID, params.URI)
+		} else {
+			err = p.client.Unsubscribe(r.Context(), sessionID, params.URI)
+		}
+		if err != nil {
+			writeJSONRPCError(w, message.ID, mcp.INTERNAL_ERROR, err.Error())
+			return true
+		}
+		writeJSONRPCResult(w, message.ID, mcp.EmptyResult{})
+		return true
+	}
+	return false
+}
+
+// serveInitialize lets the streamable http server answer the initialize request and declares
+// the completions capability on top, which mcp-go's server has no option for.
+func (p *mcpProxyServer) serveInitialize(w http.ResponseWriter, r *http.Request) {
+	recorder := &responseRecorder{header: w.Header(), status: http.StatusOK}
+	p.httpServer.ServeHTTP(recorder, r)
+
+	body := recorder.body.Bytes()
+	var response map[string]any
+	if recorder.status == http.StatusOK && sonic.Unmarshal(body, &response) == nil {
+		if result, ok := response["result"].(map[string]any); ok {
+			if capabilities, ok := result["capabilities"].(map[string]any); ok {
+				capabilities["completions"] = map[string]any{}
+				if data, err := sonic.Marshal(response); err == nil {
+					body = data
+				}
+			}
+		}
+	}
+	w.WriteHeader(recorder.status)
+	_, _ = w.Write(body)
+}
+
+func (p *mcpProxyServer) Close() error {
+	return p.client.Close()
+}
+
+func writeJSONRPCResult(w http.ResponseWriter, id mcp.RequestId, result any) {
+	writeJSONRPCMessage(w, mcp.JSONRPCResponse{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      id,
+		Result:  result,
+	})
+}
+
+func writeJSONRPCError(w http.ResponseWriter, id mcp.RequestId, code int, message string) {
+	writeJSONRPCMessage(w, mcp.NewJSONRPCError(id, code, message, nil))
+}
+
+func writeJSONRPCMessage(w http.ResponseWriter, message any) {
+	data, err := sonic.Marshal(message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// responseRecorder buffers a response body so that it can be rewritten before being sent.
+// Headers are written through to the wrapped response writer.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	return r.body.Write(data)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newInterceptingUpstream serves mcpServer over streamable http, the JSON-RPC requests for which intercept
+// reports true are answered by intercept instead, when set. The initialize result declares the capabilities on top,
+// and an echo tool is added, the gateway lists the tools on connect.
+func newInterceptingUpstream(t *testing.T, mcpServer *server.MCPServer, capabilities []string, intercept func(w http.ResponseWriter, r *http.Request, message jsonRPCMessage) bool) *httptest.Server {
+	t.Helper()
+	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("upstream"), nil
+	})
+	httpServer := server.NewStreamableHTTPServer(mcpServer)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpServer.ServeHTTP(w, r)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		var message jsonRPCMessage
+		if err := sonic.Unmarshal(data, &message); err == nil && intercept != nil && intercept(w, r, message) {
+			return
+		}
+		if message.Method != mcp.MethodInitialize || len(capabilities) == 0 {
+			httpServer.ServeHTTP(w, r)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		httpServer.ServeHTTP(recorder, r)
+		var response map[string]any
+		if err := sonic.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Error(err)
+			return
+		}
+		for _, name := range capabilities {
+			response["result"].(map[string]any)["capabilities"].(map[string]any)[name] = map[string]any{}
+		}
+		maps.Copy(w.Header(), recorder.Header())
+		w.WriteHeader(recorder.Code)
+		_ = sonic.ConfigDefault.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// newCompletionUpstream is an upstream server with a prompt and a resource template whose arguments it completes
+// with the name of the reference, declaring the completions capability mcp-go's server has no option for.
+func newCompletionUpstream(t *testing.T, completions bool) *httptest.Server {
+	t.Helper()
+	mcpServer := server.NewMCPServer("upstream", "1.0.0")
+	mcpServer.AddPrompt(mcp.NewPrompt("greeting", mcp.WithArgument("name")),
+		func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			return mcp.NewGetPromptResult("greeting", nil), nil
+		})
+	mcpServer.AddResourceTemplate(mcp.NewResourceTemplate("file:///{path}", "files"),
+		func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return nil, nil
+		})
+	var capabilities []string
+	if completions {
+		capabilities = []string{"completions"}
+	}
+	return newInterceptingUpstream(t, mcpServer, capabilities, func(w http.ResponseWriter, r *http.Request, message jsonRPCMessage) bool {
+		if completions && message.Method == methodCompletionComplete {
+			var params mcp.CompleteParams
+			_ = sonic.Unmarshal(message.Params, &params)
+			ref, _ := params.Ref.(map[string]any)
+			result := &mcp.CompleteResult{}
+			result.Completion.Values = []string{params.Argument.Value + " from " + ref["type"].(string)}
+			writeJSONRPCResult(w, message.ID, result)
+			return true
+		}
+		return false
+	})
+}
+
+// initializeCapabilities initializes a session on the proxy at url and returns the capabilities it declared.
+func initializeCapabilities(t *testing.T, url string) map[string]any {
+	t.Helper()
+	body := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"` + mcp.LATEST_PROTOCOL_VERSION +
+		`","capabilities":{},"clientInfo":{"name":"test","version":"1.0.0"}}}`
+	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Result struct {
+			Capabilities map[string]any `json:"capabilities"`
+		} `json:"result"`
+	}
+	if err := sonic.ConfigDefault.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Result.Capabilities
+}
+
+func TestProxyCompletion(t *testing.T) {
+	_, url := serveTestProxy(t, newCompletionUpstream(t, true).URL)
+	if _, ok := initializeCapabilities(t, url)["completions"]; !ok {
+		t.Error("the proxy does not declare the completions capability of the upstream server")
+	}
+	session, _ := newTestSession(t, url)
+
+	tests := []struct {
+		name    string
+		ref     any
+		want    string
+		wantErr bool
+	}{
+		{"prompt", mcp.PromptReference{Type: "ref/prompt", Name: "greeting"}, "al from ref/prompt", false},
+		{"resource template", mcp.ResourceReference{Type: "ref/resource", URI: "file:///{path}"}, "al from ref/resource", false},
+		{"unknown prompt", mcp.PromptReference{Type: "ref/prompt", Name: "farewell"}, "", true},
+		{"unknown resource", mcp.ResourceReference{Type: "ref/resource", URI: "https://example.com/{path}"}, "", true},
+		{"unknown reference", map[string]any{"type": "ref/tool", "name": "echo"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CompleteRequest{}
+			request.Params.Ref = tt.ref
+			request.Params.Argument.Name = "name"
+			request.Params.Argument.Value = "al"
+			result, err := session.Complete(context.Background(), request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Complete() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !slices.Equal(result.Completion.Values, []string{tt.want}) {
+				t.Errorf("values = %q, want %q", result.Completion.Values, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyWithoutCompletion(t *testing.T) {
+	_, url := serveTestProxy(t, newCompletionUpstream(t, false).URL)
+	if _, ok := initializeCapabilities(t, url)["completions"]; ok {
+		t.Error("the proxy declares the completions capability the upstream server lacks")
+	}
+	session, _ := newTestSession(t, url)
+	request := mcp.CompleteRequest{}
+	request.Params.Ref = mcp.PromptReference{Type: "ref/prompt", Name: "greeting"}
+	if _, err := session.Complete(context.Background(), request); err == nil {
+		t.Error("Complete() succeeded without the completions capability")
+	}
+}
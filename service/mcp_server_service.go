@@ -1,95 +1,346 @@
 package service
 
 import (
+	"cmp"
 	"context"
-	"github.com/bytedance/sonic"
+	"errors"
+	"fmt"
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"github.com/tomeai/mcp-gateway/internal/auth"
+	"github.com/tomeai/mcp-gateway/internal/cache"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/sandbox"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
 	"github.com/tomeai/mcp-gateway/model"
 	"github.com/tomeai/mcp-gateway/repository"
 	"github.com/tomeai/mcp-gateway/utils"
 	"go.uber.org/zap"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type DynamicMCPServer struct {
-	mcpServerService *repository.McpServerService
+	mcpServerService repository.McpServerRepository
+	secrets          *secret.Resolver
+	oauthTokens      repository.OAuthTokenRepository
+	catalogs         repository.ServerCatalogRepository
+	egress           *egress.Policy
+	sandbox          *sandbox.Policy
+	metrics          telemetry.CustomMetrics
+	toolCache        cache.Store
 	mcpServerMcp     sync.Map
-	logger           *zap.Logger
+	// serverKeys maps a server name to the keys of the proxies cached for it, one per user
+	// for the instances of a server template
+	keysMu     sync.Mutex
+	serverKeys map[string]map[string]struct{}
+	// pendingAuthorizations maps the state of the upstream authorizations in progress to their flow
+	pendingAuthorizations sync.Map
+	// warming counts the critical servers the pre-warming has not connected yet
+	warming atomic.Int64
+	// prewarmed tells whether the servers are pre-warmed, rewarming holds the keys of the critical servers
+	// connected again in the background
+	prewarmed atomic.Bool
+	rewarming sync.Map
+	logger    *zap.Logger
 }
 
-func NewDynamicMCPServer(mcpServerService *repository.McpServerService, logger *zap.Logger) *DynamicMCPServer {
+func NewDynamicMCPServer(mcpServerService repository.McpServerRepository, secretService repository.SecretRepository, oauthTokens repository.OAuthTokenRepository, catalogs repository.ServerCatalogRepository, egress *egress.Policy, sandbox *sandbox.Policy, metrics telemetry.CustomMetrics, toolCache cache.Store, cipher *secret.Cipher, logger *zap.Logger) *DynamicMCPServer {
 	// load from db by uid && mcpServerName
-	return &DynamicMCPServer{
+	m := &DynamicMCPServer{
 		mcpServerService: mcpServerService,
+		secrets:          secret.NewResolver(cipher, secretService),
+		oauthTokens:      oauthTokens,
+		catalogs:         catalogs,
+		egress:           egress,
+		sandbox:          sandbox,
+		metrics:          metrics,
+		toolCache:        toolCache,
+		serverKeys:       make(map[string]map[string]struct{}),
 		logger:           logger,
 	}
+	if watcher, ok := mcpServerService.(repository.McpServerWatcher); ok {
+		watcher.Watch(m.evict)
+	}
+	return m
 }
 
-func (m *DynamicMCPServer) buildMcpServer(mcpServer *model.McpServer) (*server.MCPServer, error) {
-	timeCtx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
-	defer cancel()
-	clientConfig := &model.MCPClientConfig{}
-	err := sonic.Unmarshal(mcpServer.ServerConfig, clientConfig)
+// Evict closes the proxies cached for a server whose entry was changed, e.g. through the admin API.
+func (m *DynamicMCPServer) Evict(serverName string) {
+	m.evict([]string{serverName})
+}
+
+// evict closes the proxies cached for the given servers, they are rebuilt on the next request.
+func (m *DynamicMCPServer) evict(serverNames []string) {
+	for _, name := range serverNames {
+		m.keysMu.Lock()
+		keys := m.serverKeys[name]
+		delete(m.serverKeys, name)
+		m.keysMu.Unlock()
+		for key := range keys {
+			if v, ok := m.mcpServerMcp.LoadAndDelete(key); ok {
+				m.logger.Info("Evict mcp server", zap.String("mcpServerName", name))
+				if err := v.(*mcpProxyServer).Close(); err != nil {
+					m.logger.Warn("Close mcp server failed", zap.String("mcpServerName", name), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+// Close closes all the cached proxies, stopping the upstream connections and commands.
+func (m *DynamicMCPServer) Close() error {
+	var errs []error
+	m.mcpServerMcp.Range(func(key, value any) bool {
+		m.mcpServerMcp.Delete(key)
+		errs = append(errs, value.(*mcpProxyServer).Close())
+		return true
+	})
+	m.keysMu.Lock()
+	clear(m.serverKeys)
+	m.keysMu.Unlock()
+	return errors.Join(errs...)
+}
+
+// UpstreamStatus is the state of a running upstream server instance.
+type UpstreamStatus struct {
+	ServerName    string         `json:"server_name"`
+	UserId        string         `json:"user_id"`
+	Critical      bool           `json:"critical,omitempty"`
+	State         UpstreamState  `json:"state"`
+	Tools         int            `json:"tools"`
+	ConnectedAt   *time.Time     `json:"connected_at,omitempty"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Members       []MemberStatus `json:"members"`
+}
+
+// UpstreamStatus returns the state of the running upstream server instances, sorted by server name and user.
+func (m *DynamicMCPServer) UpstreamStatus() []UpstreamStatus {
+	statuses := make([]UpstreamStatus, 0)
+	m.mcpServerMcp.Range(func(key, value any) bool {
+		proxy := value.(*mcpProxyServer)
+		members := proxy.client.Status()
+		state, connectedAt := proxy.client.state(members)
+		status := UpstreamStatus{
+			ServerName: proxy.serverName,
+			UserId:     proxy.userId,
+			Critical:   proxy.critical,
+			State:      state,
+			Members:    members,
+		}
+		// the tools of a persisted catalog are served while connecting
+		if catalog := proxy.client.catalog.Load(); catalog != nil {
+			status.Tools = len(catalog.Tools)
+		}
+		if !connectedAt.IsZero() {
+			status.ConnectedAt = &connectedAt
+			status.UptimeSeconds = int64(time.Since(connectedAt).Seconds())
+		}
+		statuses = append(statuses, status)
+		return true
+	})
+	slices.SortFunc(statuses, func(a, b UpstreamStatus) int {
+		return cmp.Or(cmp.Compare(a.ServerName, b.ServerName), cmp.Compare(a.UserId, b.UserId))
+	})
+	return statuses
+}
+
+// PurgeToolCache removes the cached tool results of a server, of one of its tools when toolName is set,
+// or of all the servers when serverName is empty. It returns the number of results removed.
+func (m *DynamicMCPServer) PurgeToolCache(ctx context.Context, serverName, toolName string) (int, error) {
+	prefix := ""
+	if serverName != "" {
+		prefix = toolCachePrefix(serverName, toolName)
+	}
+	purged, err := m.toolCache.Purge(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	m.logger.Info("Purge tool cache", zap.String("mcpServerName", serverName), zap.String("toolName", toolName), zap.Int("purged", purged))
+	return purged, nil
+}
+
+// cacheKey identifies the upstream instance of a server: its config and the fingerprint of its credentials.
+// The user is part of the key so that the instances of a server template are never shared across users.
+func cacheKey(mcpServer *model.McpServer) string {
+	credentials := utils.Md5String(string(mcpServer.Env) + "\n" + string(mcpServer.Headers))
+	return utils.Md5String(mcpServer.UserId + "\n" + string(mcpServer.ServerConfig) + "\n" + credentials)
+}
+
+// buildMcpServer builds the proxy of a server. When a catalog was persisted for its config, identified
+// by configKey, the proxy serves it right away while the upstream server connects in the background.
+func (m *DynamicMCPServer) buildMcpServer(mcpServer *model.McpServer, configKey string) (*mcpProxyServer, error) {
+	clientConfig, err := upstreamConfig(mcpServer)
 	if err != nil {
 		return nil, err
 	}
-	mcpClient, err := NewMCPClientService("wemcp-gateway", clientConfig, m.logger)
+	var tokens *oauthTokenStore
+	if clientConfig.OAuth != nil {
+		if tokens, err = newOAuthTokenStore(m.oauthTokens, m.secrets, mcpServer.UserId, mcpServer.ServerName); err != nil {
+			return nil, err
+		}
+	}
+	// the call limits bound the server as a whole, whichever of its replicas and fallbacks serves the calls
+	limiter := newCallLimiter(mcpServer.ServerName, clientConfig.Limits, m.metrics)
+	var (
+		// the clients of the members are built when they connect, the fallbacks only when needed
+		specs []*memberSpec
+		// forwarded lists the downstream headers passed through by any member, the cached results vary with them
+		forwarded []string
+	)
+	for tier, tierConfig := range upstreamTiers(clientConfig) {
+		if tier > 0 && tierConfig.OAuth != nil {
+			return nil, fmt.Errorf("fallback %d: oauth is only supported by the primary upstream", tier)
+		}
+		configs, err := memberConfigs(tierConfig)
+		if err != nil {
+			return nil, err
+		}
+		for i, conf := range configs {
+			if _, err := parseMCPClientConfig(conf); err != nil {
+				return nil, err
+			}
+			for _, rule := range conf.ForwardHeaders {
+				forwarded = append(forwarded, rule.Name)
+			}
+			specs = append(specs, &memberSpec{
+				endpoint: memberEndpoint(conf, i),
+				tier:     tier,
+				newClient: func() (*MCPClient, error) {
+					return NewMCPClientService(mcpServer.ServerName, conf, m.secrets, tokens, limiter, m.egress, m.sandbox, m.metrics, m.logger)
+				},
+			})
+		}
+	}
+	toolCache := newToolCache(mcpServer.ServerName, mcpServer.UserId, configKey, forwarded, clientConfig.Cache, m.toolCache, m.metrics, m.logger)
+	pool, err := newClientPool(mcpServer.ServerName, clientConfig.Pool, specs, limiter, toolCache, m.logger)
 	if err != nil {
 		return nil, err
 	}
+	hooks := &server.Hooks{}
+	hooks.AddAfterSetLevel(func(ctx context.Context, id any, message *mcp.SetLevelRequest, result *mcp.EmptyResult) {
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			pool.AddLogSession(session.SessionID())
+		}
+	})
+	// sessions are unregistered when their listening stream closes, whether or not they were terminated
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		go func() {
+			// the context of the listening stream is done already
+			ctx, cancel := context.WithTimeout(context.Background(), releaseSessionTimeout)
+			defer cancel()
+			pool.RemoveSession(ctx, session.SessionID())
+		}()
+	})
+
 	// server: streamable http
 	mcpProxyServer := server.NewMCPServer(
 		mcpServer.ServerName,
 		"0.0.1",
 		server.WithResourceCapabilities(true, true),
+		server.WithLogging(),
+		server.WithHooks(hooks),
 		server.WithRecovery(),
 	)
 
+	if catalog := m.loadCatalog(mcpServer, configKey); catalog != nil {
+		pool.AddToMCPServerLazily(mcpProxyServer, catalog, func(catalog *upstreamCatalog, err error) {
+			if errors.Is(err, errPoolClosed) {
+				// the proxy has been evicted, closed or lost the race to build the server meanwhile
+				return
+			}
+			if err != nil {
+				m.logger.Warn("Connect mcp server failed", zap.String("mcpServerName", mcpServer.ServerName), zap.Error(err))
+				return
+			}
+			m.saveCatalog(mcpServer, configKey, catalog)
+		})
+		return newMCPProxyServer(mcpServer.ServerName, mcpServer.UserId, clientConfig.Critical, mcpProxyServer, pool), nil
+	}
+
 	// add mcp server
-	err = mcpClient.AddToMCPServer(timeCtx, mcpProxyServer)
+	timeCtx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	err = pool.AddToMCPServer(timeCtx, mcpProxyServer)
 	if err != nil {
-		return nil, err
+		_ = pool.Close()
+		return nil, asAuthorizationRequired(err, mcpServer.ServerName)
 	}
-	return mcpProxyServer, nil
+	m.saveCatalog(mcpServer, configKey, pool.catalog.Load())
+	return newMCPProxyServer(mcpServer.ServerName, mcpServer.UserId, clientConfig.Critical, mcpProxyServer, pool), nil
 }
 
-func (m *DynamicMCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var (
-		mcpProxyServer *server.MCPServer
-		err            error
-	)
+// proxy returns the proxy cached for the server, building it on first use.
+func (m *DynamicMCPServer) proxy(mcpServer *model.McpServer) (*mcpProxyServer, error) {
+	serverMd5 := cacheKey(mcpServer)
+	// the server config holds secrets, never log it
+	m.logger.Debug("serverMd5", zap.String("serverMd5", serverMd5))
+	if v, ok := m.mcpServerMcp.Load(serverMd5); ok && v.(*mcpProxyServer).client.failed() {
+		// the proxy served a persisted catalog but the upstream server failed to connect, build it again
+		if m.mcpServerMcp.CompareAndDelete(serverMd5, v) {
+			_ = v.(*mcpProxyServer).Close()
+		}
+	}
+	var proxyServer *mcpProxyServer
+	if v, ok := m.mcpServerMcp.Load(serverMd5); !ok {
+		// 构建
+		built, err := m.buildMcpServer(mcpServer, serverMd5)
+		if err != nil {
+			return nil, err
+		}
+		proxyServer = built
+		if v, loaded := m.mcpServerMcp.LoadOrStore(serverMd5, proxyServer); loaded {
+			// another request built the same server concurrently, keep the stored one
+			_ = proxyServer.Close()
+			proxyServer = v.(*mcpProxyServer)
+		}
+	} else {
+		proxyServer = v.(*mcpProxyServer)
+	}
+
+	m.keysMu.Lock()
+	if m.serverKeys[mcpServer.ServerName] == nil {
+		m.serverKeys[mcpServer.ServerName] = make(map[string]struct{})
+	}
+	m.serverKeys[mcpServer.ServerName][serverMd5] = struct{}{}
+	m.keysMu.Unlock()
+	return proxyServer, nil
+}
 
+func (m *DynamicMCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mcpServerName := r.PathValue("name")
 	if mcpServerName == "" {
 		http.Error(w, "mcpServerName is nil", http.StatusBadRequest)
 		return
 	}
 
-	m.logger.Info("dynamic mcp", zap.String("mcpServerName", mcpServerName))
-	mcpServer, err := m.mcpServerService.GetMcpServer("gage", mcpServerName)
+	userId := model.DefaultUserId
+	if caller := auth.CallerFromContext(r.Context()); caller != nil {
+		userId = caller.UserId
+	}
+
+	m.logger.Info("dynamic mcp", zap.String("mcpServerName", mcpServerName), zap.String("userId", userId))
+	mcpServer, err := m.mcpServerService.GetMcpServer(userId, mcpServerName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	serverMd5 := utils.Md5String(string(mcpServer.ServerConfig))
-	m.logger.Info("serverMd5", zap.String("serverMd5", serverMd5), zap.String("serverConfig", string(mcpServer.ServerConfig)))
-	if v, ok := m.mcpServerMcp.Load(serverMd5); !ok {
-		// 构建
-		mcpProxyServer, err = m.buildMcpServer(mcpServer)
-		m.mcpServerMcp.Store(serverMd5, mcpProxyServer)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	proxyServer, err := m.proxy(mcpServer)
+	if err != nil {
+		var authErr *AuthorizationRequiredError
+		if errors.As(err, &authErr) {
+			// the user must authorize the gateway with the upstream server again
+			m.logger.Warn("Mcp server requires authorization", zap.String("mcpServerName", mcpServerName), zap.String("userId", mcpServer.UserId))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
-	} else {
-		mcpProxyServer = v.(*server.MCPServer)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	server.NewStreamableHTTPServer(
-		mcpProxyServer,
-		server.WithStateLess(true),
-	).ServeHTTP(w, r)
+	proxyServer.ServeHTTP(w, r)
 }
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+)
+
+func newTestDynamicMCPServer(t *testing.T) (*DynamicMCPServer, *repository.MemoryStore) {
+	t.Helper()
+	policy, err := egress.NewPolicyFromConfig(egress.Config{AllowCIDRs: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := repository.NewMemoryStore()
+	m := NewDynamicMCPServer(store, nil, store, store, policy, nil, telemetry.NewNoopCustomMetrics(), nil, nil, zap.NewNop())
+	t.Cleanup(func() { _ = m.Close() })
+	return m, store
+}
+
+// registerTestServer registers a server shared by all users, served by the upstream at url.
+func registerTestServer(t *testing.T, store *repository.MemoryStore, name, url string) *model.McpServer {
+	t.Helper()
+	mcpServer := &model.McpServer{
+		UserId:       model.DefaultUserId,
+		ServerName:   name,
+		ServerConfig: []byte(`{"transportType":"streamable-http","url":"` + url + `"}`),
+	}
+	if err := store.UpsertMcpServer(mcpServer); err != nil {
+		t.Fatal(err)
+	}
+	return mcpServer
+}
+
+// barrierCatalogs holds the catalog lookups until as many requests as the barrier counts looked their catalog up.
+type barrierCatalogs struct {
+	*repository.MemoryStore
+	barrier *sync.WaitGroup
+}
+
+func (c barrierCatalogs) GetServerCatalog(userId, serverName string) (*model.ServerCatalog, error) {
+	c.barrier.Done()
+	c.barrier.Wait()
+	return c.MemoryStore.GetServerCatalog(userId, serverName)
+}
+
+func TestDynamicMCPServerConcurrentColdStarts(t *testing.T) {
+	upstream := newTestUpstream(t, "upstream")
+	for range 10 {
+		m, store := newTestDynamicMCPServer(t)
+		mcpServer := registerTestServer(t, store, "echo", upstream.URL)
+		// the proxies built from a persisted catalog connect in the background
+		m.saveCatalog(mcpServer, cacheKey(mcpServer), &upstreamCatalog{Tools: []mcp.Tool{mcp.NewTool("echo")}})
+		// both requests build a proxy, the one losing the race closes it while it connects
+		var barrier sync.WaitGroup
+		barrier.Add(2)
+		m.catalogs = barrierCatalogs{MemoryStore: store, barrier: &barrier}
+
+		var wg sync.WaitGroup
+		proxies := make([]*mcpProxyServer, 2)
+		for i := range proxies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				proxy, err := m.proxy(mcpServer)
+				if err != nil {
+					t.Error(err)
+				}
+				proxies[i] = proxy
+			}()
+		}
+		wg.Wait()
+		if proxies[0] == nil || proxies[0] != proxies[1] {
+			t.Fatal("concurrent requests got different proxies")
+		}
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "echo"
+		result, err := proxies[0].client.callTool(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := result.Content[0].(mcp.TextContent).Text; got != "upstream" {
+			t.Fatalf("call served by %s, want upstream", got)
+		}
+	}
+}
+
+// watchedStore is a server repository reporting the changes of its entries to the watchers.
+type watchedStore struct {
+	*repository.MemoryStore
+	onChange func(serverNames []string)
+}
+
+func (s *watchedStore) Watch(onChange func(serverNames []string)) {
+	s.onChange = onChange
+}
+
+func TestDynamicMCPServerEvictsChangedServers(t *testing.T) {
+	upstream := newTestUpstream(t, "upstream")
+	store := &watchedStore{MemoryStore: repository.NewMemoryStore()}
+	m := NewDynamicMCPServer(store, nil, store, store, nil, nil, telemetry.NewNoopCustomMetrics(), nil, nil, zap.NewNop())
+	t.Cleanup(func() { _ = m.Close() })
+	if store.onChange == nil {
+		t.Fatal("the server does not watch the repository")
+	}
+	mcpServer := registerTestServer(t, store.MemoryStore, "echo", upstream.URL)
+	registerTestServer(t, store.MemoryStore, "other", upstream.URL)
+	evicted, err := m.proxy(mcpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.onChange([]string{"echo"})
+	if evicted.client.ctx.Err() == nil {
+		t.Error("the proxy of a changed server was not closed")
+	}
+	rebuilt, err := m.proxy(mcpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt == evicted {
+		t.Fatal("the proxy of a changed server was not rebuilt")
+	}
+	if got := callEcho(t, rebuilt.client); got != "upstream" {
+		t.Errorf("call served by %s, want upstream", got)
+	}
+	// the servers not reported are kept
+	store.onChange([]string{"other"})
+	if rebuilt.client.ctx.Err() != nil {
+		t.Error("the proxy of an unchanged server was closed")
+	}
+}
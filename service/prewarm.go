@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultPrewarmConcurrency bounds the servers connected at once by the pre-warming
+const defaultPrewarmConcurrency = 4
+
+// Prewarm builds in the background the proxies of the registered servers carrying one of tags, of all of them
+// when tags is empty, so that their first caller does not wait for the upstream connection. The critical servers
+// are always pre-warmed, Warming reports true until they are connected or failed.
+func (m *DynamicMCPServer) Prewarm(ctx context.Context, tags []string, concurrency int) error {
+	lister, ok := m.mcpServerService.(repository.McpServerLister)
+	if !ok {
+		return errors.New("the server repository does not support listing the servers")
+	}
+	mcpServers, err := lister.ListMcpServers()
+	if err != nil {
+		return err
+	}
+	type target struct {
+		mcpServer *model.McpServer
+		critical  bool
+	}
+	var targets []target
+	for _, mcpServer := range mcpServers {
+		conf, err := upstreamConfig(mcpServer)
+		if err != nil {
+			m.logger.Warn("Prewarm mcp server skipped", zap.String("mcpServerName", mcpServer.ServerName), zap.Error(err))
+			continue
+		}
+		if conf.Critical || len(tags) == 0 || slices.ContainsFunc(conf.Tags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+			targets = append(targets, target{mcpServer: mcpServer, critical: conf.Critical})
+		}
+	}
+	for _, t := range targets {
+		if t.critical {
+			m.warming.Add(1)
+		}
+	}
+	if concurrency <= 0 {
+		concurrency = defaultPrewarmConcurrency
+	}
+	m.prewarmed.Store(true)
+
+	m.logger.Info("Prewarm mcp servers", zap.Int("servers", len(targets)), zap.Int64("critical", m.warming.Load()))
+	go func() {
+		start := time.Now()
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, t := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				if t.critical {
+					defer m.warming.Add(-1)
+				}
+				if err := m.prewarm(ctx, t.mcpServer); err != nil {
+					m.logger.Warn("Prewarm mcp server failed", zap.String("mcpServerName", t.mcpServer.ServerName), zap.String("userId", t.mcpServer.UserId), zap.Error(err))
+				}
+			}()
+		}
+		wg.Wait()
+		m.logger.Info("Prewarm mcp servers done", zap.Int("servers", len(targets)), zap.Duration("elapsed", time.Since(start)))
+	}()
+	return nil
+}
+
+// prewarm builds the proxy of a server and waits until its upstream connected, the proxy serving a persisted
+// catalog connects in the background.
+func (m *DynamicMCPServer) prewarm(ctx context.Context, mcpServer *model.McpServer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	proxy, err := m.proxy(mcpServer)
+	if err != nil {
+		return err
+	}
+	return proxy.client.wait(ctx)
+}
+
+// Warming returns the number of critical servers the pre-warming is still connecting.
+func (m *DynamicMCPServer) Warming() int64 {
+	return m.warming.Load()
+}
+
+// CheckCritical returns an error unless the critical servers are up or degraded, some of their members serving.
+// When pre-warming, a critical server not connected counts as down and is connected again in the background,
+// otherwise it is connected by its first request.
+func (m *DynamicMCPServer) CheckCritical() error {
+	if pending := m.warming.Load(); pending > 0 {
+		return fmt.Errorf("%d critical servers warming", pending)
+	}
+	lister, ok := m.mcpServerService.(repository.McpServerLister)
+	if !ok {
+		return nil
+	}
+	mcpServers, err := lister.ListMcpServers()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, mcpServer := range mcpServers {
+		conf, err := upstreamConfig(mcpServer)
+		if err != nil || !conf.Critical {
+			continue
+		}
+		key := cacheKey(mcpServer)
+		v, ok := m.mcpServerMcp.Load(key)
+		if !ok {
+			if m.prewarmed.Load() {
+				m.rewarm(mcpServer, key)
+				errs = append(errs, fmt.Errorf("critical server %s is not connected", mcpServer.ServerName))
+			}
+			continue
+		}
+		proxy := v.(*mcpProxyServer)
+		switch state, _ := proxy.client.state(proxy.client.Status()); state {
+		case UpstreamUp, UpstreamDegraded:
+		case UpstreamFailed:
+			m.rewarm(mcpServer, key)
+			fallthrough
+		default:
+			errs = append(errs, fmt.Errorf("critical server %s is %s", mcpServer.ServerName, state))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rewarm connects a critical server again in the background, unless it is already being connected.
+func (m *DynamicMCPServer) rewarm(mcpServer *model.McpServer, key string) {
+	if !m.prewarmed.Load() {
+		return
+	}
+	if _, loaded := m.rewarming.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer m.rewarming.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		defer cancel()
+		if err := m.prewarm(ctx, mcpServer); err != nil {
+			m.logger.Warn("Prewarm mcp server failed", zap.String("mcpServerName", mcpServer.ServerName), zap.String("userId", mcpServer.UserId), zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.uber.org/zap"
+	"sync"
+)
+
+var errSubscribeUnsupported = errors.New("upstream server does not support resource subscriptions")
+
+// resourceSubscriptions tracks which downstream sessions are subscribed to which resource URIs.
+// Every URI maps to exactly one upstream subscription, no matter how many sessions subscribed to it.
+// The upstream is called without holding mu, a slow upstream only delays the sessions subscribing to it.
+type resourceSubscriptions struct {
+	mu        sync.Mutex
+	client    *MCPClient
+	mcpServer *server.MCPServer
+	subs      map[string]*uriSubscription
+	// releasing holds the subscriptions the upstream is dropping, the next subscription to their uri waits for it
+	releasing map[string]*uriSubscription
+}
+
+// uriSubscription is the upstream subscription of a uri and the downstream sessions sharing it.
+type uriSubscription struct {
+	sessions map[string]struct{}
+	// done is closed once the upstream answered the subscription, err telling why it failed
+	done chan struct{}
+	err  error
+	// released is closed once the upstream subscription has been dropped
+	released chan struct{}
+}
+
+func newResourceSubscriptions(client *MCPClient, mcpServer *server.MCPServer) *resourceSubscriptions {
+	return &resourceSubscriptions{
+		client:    client,
+		mcpServer: mcpServer,
+		subs:      make(map[string]*uriSubscription),
+		releasing: make(map[string]*uriSubscription),
+	}
+}
+
+// subscribe registers the session for updates of uri. The upstream is only
+// asked to subscribe when the first session shows interest in the uri.
+func (s *resourceSubscriptions) subscribe(ctx context.Context, sessionID, uri string) error {
+	s.mu.Lock()
+	for s.releasing[uri] != nil {
+		// an upstream unsubscription of uri still on its way would drop the new subscription
+		released := s.releasing[uri].released
+		s.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	if sub, ok := s.subs[uri]; ok {
+		sub.sessions[sessionID] = struct{}{}
+		s.mu.Unlock()
+		// the subscription may still be on its way upstream, its failure drops the session as well
+		<-sub.done
+		return sub.err
+	}
+	if !s.client.supportsSubscribe() {
+		s.mu.Unlock()
+		return errSubscribeUnsupported
+	}
+	sub := &uriSubscription{
+		sessions: map[string]struct{}{sessionID: {}},
+		done:     make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	s.subs[uri] = sub
+	s.mu.Unlock()
+
+	request := mcp.SubscribeRequest{}
+	request.Params.URI = uri
+	err := s.client.client.Subscribe(ctx, request)
+
+	s.mu.Lock()
+	if err != nil && s.subs[uri] == sub {
+		delete(s.subs, uri)
+	}
+	sub.err = err
+	close(sub.done)
+	s.mu.Unlock()
+	return err
+}
+
+// unsubscribe removes the session from the subscribers of uri and drops the
+// upstream subscription once the last session has left.
+func (s *resourceSubscriptions) unsubscribe(ctx context.Context, sessionID, uri string) error {
+	s.mu.Lock()
+	sub := s.removeLocked(sessionID, uri)
+	s.mu.Unlock()
+	if sub == nil {
+		return nil
+	}
+	return s.unsubscribeUpstream(ctx, uri, sub)
+}
+
+// removeSession drops every subscription held by a terminated session.
+func (s *resourceSubscriptions) removeSession(ctx context.Context, sessionID string) {
+	s.mu.Lock()
+	released := make(map[string]*uriSubscription)
+	for uri, sub := range s.subs {
+		if _, ok := sub.sessions[sessionID]; !ok {
+			continue
+		}
+		if sub := s.removeLocked(sessionID, uri); sub != nil {
+			released[uri] = sub
+		}
+	}
+	s.mu.Unlock()
+
+	for uri, sub := range released {
+		if err := s.unsubscribeUpstream(ctx, uri, sub); err != nil {
+			s.client.logger.Warn("Unsubscribe upstream resource failed",
+				zap.String("name", s.client.name), zap.String("uri", uri), zap.Error(err))
+		}
+	}
+}
+
+// removeLocked removes the session from the subscribers of uri, returning the subscription
+// when the session was the last one and the upstream subscription should be dropped.
+func (s *resourceSubscriptions) removeLocked(sessionID, uri string) *uriSubscription {
+	sub, ok := s.subs[uri]
+	if !ok {
+		return nil
+	}
+	delete(sub.sessions, sessionID)
+	if len(sub.sessions) > 0 {
+		return nil
+	}
+	delete(s.subs, uri)
+	s.releasing[uri] = sub
+	return sub
+}
+
+// unsubscribeUpstream drops the upstream subscription of uri once it has been made.
+func (s *resourceSubscriptions) unsubscribeUpstream(ctx context.Context, uri string, sub *uriSubscription) error {
+	defer func() {
+		s.mu.Lock()
+		delete(s.releasing, uri)
+		close(sub.released)
+		s.mu.Unlock()
+	}()
+	<-sub.done
+	if sub.err != nil {
+		return nil
+	}
+	request := mcp.UnsubscribeRequest{}
+	request.Params.URI = uri
+	return s.client.client.Unsubscribe(ctx, request)
+}
+
+// hasSubscribers reports whether a session is subscribed to uri.
+func (s *resourceSubscriptions) hasSubscribers(uri string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subs[uri] != nil
+}
+
+// notifyUpdated relays a notifications/resources/updated message to every subscribed session.
+func (s *resourceSubscriptions) notifyUpdated(uri string) {
+	s.mu.Lock()
+	var sessionIDs []string
+	if sub, ok := s.subs[uri]; ok {
+		sessionIDs = make([]string, 0, len(sub.sessions))
+		for sessionID := range sub.sessions {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+	s.mu.Unlock()
+
+	params := map[string]any{"uri": uri}
+	for _, sessionID := range sessionIDs {
+		err := s.mcpServer.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, params)
+		if err != nil {
+			// the session is not registered yet, its listening stream is about to open
+			s.client.logger.Debug("Relay resource update failed",
+				zap.String("name", s.client.name), zap.String("sessionId", sessionID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// subscriptionUpstream is an in-process streamable http mcp server supporting resource subscriptions,
+// which mcp-go's server does not implement. It records the subscription calls it answered.
+type subscriptionUpstream struct {
+	*httptest.Server
+	mcpServer *server.MCPServer
+
+	mu    sync.Mutex
+	calls []string
+	// unsubscribing, when set, receives the unsubscriptions before they are answered and holds them until released
+	unsubscribing chan string
+	release       chan struct{}
+}
+
+func newSubscriptionUpstream(t *testing.T) *subscriptionUpstream {
+	t.Helper()
+	upstream := &subscriptionUpstream{
+		mcpServer: server.NewMCPServer("upstream", "1.0.0", server.WithResourceCapabilities(true, false)),
+	}
+	upstream.Server = newInterceptingUpstream(t, upstream.mcpServer, nil, func(w http.ResponseWriter, r *http.Request, message jsonRPCMessage) bool {
+		if message.Method != methodResourcesSubscribe && message.Method != methodResourcesUnsubscribe {
+			return false
+		}
+		var params mcp.SubscribeParams
+		_ = sonic.Unmarshal(message.Params, &params)
+		upstream.mu.Lock()
+		unsubscribing, release := upstream.unsubscribing, upstream.release
+		upstream.mu.Unlock()
+		if message.Method == methodResourcesUnsubscribe && unsubscribing != nil {
+			unsubscribing <- params.URI
+			<-release
+		}
+		upstream.mu.Lock()
+		upstream.calls = append(upstream.calls, string(message.Method)+" "+params.URI)
+		upstream.mu.Unlock()
+		writeJSONRPCResult(w, message.ID, mcp.EmptyResult{})
+		return true
+	})
+	return upstream
+}
+
+func (u *subscriptionUpstream) recorded() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return slices.Clone(u.calls)
+}
+
+// serveTestProxy serves the proxy of a server shared by all users, connecting to the upstream at url.
+func serveTestProxy(t *testing.T, url string) (*mcpProxyServer, string) {
+	t.Helper()
+	m, store := newTestDynamicMCPServer(t)
+	proxy, err := m.proxy(registerTestServer(t, store, "upstream", url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(proxy)
+	t.Cleanup(proxyServer.Close)
+	return proxy, proxyServer.URL
+}
+
+// newTestSession opens a downstream session on the proxy at url, the notifications it receives are sent to the channel.
+func newTestSession(t *testing.T, url string) (*client.Client, <-chan mcp.JSONRPCNotification) {
+	t.Helper()
+	session, err := client.NewStreamableHttpClient(url, transport.WithContinuousListening())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = session.Close() })
+	notifications := make(chan mcp.JSONRPCNotification, 16)
+	session.OnNotification(func(notification mcp.JSONRPCNotification) {
+		notifications <- notification
+	})
+	ctx := context.Background()
+	if err := session.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	request := mcp.InitializeRequest{}
+	request.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	if _, err := session.Initialize(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+	return session, notifications
+}
+
+// awaitNotification repeats send until a notification of method reaches the channel, the streams
+// relaying it may still be opening.
+func awaitNotification(t *testing.T, notifications <-chan mcp.JSONRPCNotification, method string, send func()) mcp.JSONRPCNotification {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		send()
+		select {
+		case notification := <-notifications:
+			if notification.Method == method {
+				return notification
+			}
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no %s notification received", method)
+		}
+	}
+}
+
+func subscribe(t *testing.T, session *client.Client, uri string) {
+	t.Helper()
+	request := mcp.SubscribeRequest{}
+	request.Params.URI = uri
+	if err := session.Subscribe(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func unsubscribe(t *testing.T, session *client.Client, uri string) {
+	t.Helper()
+	request := mcp.UnsubscribeRequest{}
+	request.Params.URI = uri
+	if err := session.Unsubscribe(context.Background(), request); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResourceSubscriptionFanIn(t *testing.T) {
+	upstream := newSubscriptionUpstream(t)
+	_, url := serveTestProxy(t, upstream.URL)
+	first, firstNotifications := newTestSession(t, url)
+	second, secondNotifications := newTestSession(t, url)
+
+	subscribe(t, first, "file:///readme")
+	subscribe(t, second, "file:///readme")
+	if calls := upstream.recorded(); !slices.Equal(calls, []string{"resources/subscribe file:///readme"}) {
+		t.Fatalf("upstream calls = %q, want a single subscription", calls)
+	}
+
+	notifyUpdated := func() {
+		upstream.mcpServer.SendNotificationToAllClients(mcp.MethodNotificationResourceUpdated, map[string]any{"uri": "file:///readme"})
+	}
+	for _, notifications := range []<-chan mcp.JSONRPCNotification{firstNotifications, secondNotifications} {
+		notification := awaitNotification(t, notifications, string(mcp.MethodNotificationResourceUpdated), notifyUpdated)
+		if uri := notification.Params.AdditionalFields["uri"]; uri != "file:///readme" {
+			t.Errorf("updated uri = %v, want file:///readme", uri)
+		}
+	}
+
+	// the upstream subscription is dropped with the last session leaving
+	unsubscribe(t, first, "file:///readme")
+	if calls := upstream.recorded(); len(calls) != 1 {
+		t.Fatalf("upstream calls = %q, want the subscription kept for the second session", calls)
+	}
+	unsubscribe(t, second, "file:///readme")
+	want := []string{"resources/subscribe file:///readme", "resources/unsubscribe file:///readme"}
+	if calls := upstream.recorded(); !slices.Equal(calls, want) {
+		t.Errorf("upstream calls = %q, want %q", calls, want)
+	}
+}
+
+func TestResourceSubscriptionAfterTeardown(t *testing.T) {
+	upstream := newSubscriptionUpstream(t)
+	upstream.unsubscribing, upstream.release = make(chan string, 1), make(chan struct{})
+	_, url := serveTestProxy(t, upstream.URL)
+	first, _ := newTestSession(t, url)
+	second, _ := newTestSession(t, url)
+	subscribe(t, first, "file:///readme")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		unsubscribe(t, first, "file:///readme")
+	}()
+	<-upstream.unsubscribing
+	// the subscription waits for the upstream to drop the previous one
+	go func() {
+		defer wg.Done()
+		request := mcp.SubscribeRequest{}
+		request.Params.URI = "file:///readme"
+		if err := second.Subscribe(context.Background(), request); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+
+	want := []string{"resources/subscribe file:///readme", "resources/unsubscribe file:///readme", "resources/subscribe file:///readme"}
+	if calls := upstream.recorded(); !slices.Equal(calls, want) {
+		t.Errorf("upstream calls = %q, want %q", calls, want)
+	}
+}
+
+func TestResourceSubscriptionRequiresSession(t *testing.T) {
+	upstream := newSubscriptionUpstream(t)
+	_, url := serveTestProxy(t, upstream.URL)
+	session, _ := newTestSession(t, url)
+	sessionID := session.GetSessionId()
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sessionID string
+		status    int
+	}{
+		{"no session", "", http.StatusBadRequest},
+		{"unknown session", "mcp-session-00000000-0000-0000-0000-000000000000", http.StatusBadRequest},
+		{"invalid session", "unknown", http.StatusBadRequest},
+		{"terminated session", sessionID, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"file:///readme"}}`
+			request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Content-Type", "application/json")
+			if tt.sessionID != "" {
+				request.Header.Set(server.HeaderKeySessionID, tt.sessionID)
+			}
+			resp, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+	if calls := upstream.recorded(); len(calls) != 0 {
+		t.Errorf("upstream calls = %q, want none", calls)
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+)
+
+// retryPolicy retries the idempotent calls to an upstream server that failed with a transient error.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// tools opted in for retries, allTools when "*" is listed
+	tools    map[string]struct{}
+	allTools bool
+}
+
+func newRetryPolicy(conf *model.RetryPolicy) *retryPolicy {
+	p := &retryPolicy{
+		maxAttempts:    defaultMaxAttempts,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		tools:          make(map[string]struct{}),
+	}
+	if conf == nil {
+		return p
+	}
+	if conf.MaxAttempts > 0 {
+		p.maxAttempts = conf.MaxAttempts
+	}
+	if conf.InitialBackoffMs > 0 {
+		p.initialBackoff = time.Duration(conf.InitialBackoffMs) * time.Millisecond
+	}
+	if conf.MaxBackoffMs > 0 {
+		p.maxBackoff = time.Duration(conf.MaxBackoffMs) * time.Millisecond
+	}
+	for _, tool := range conf.Tools {
+		if tool == "*" {
+			p.allTools = true
+		}
+		p.tools[tool] = struct{}{}
+	}
+	return p
+}
+
+// retriesTool tells whether the calls of a tool may be retried: it must be opted in and annotated idempotent.
+func (p *retryPolicy) retriesTool(tool mcp.Tool) bool {
+	if _, ok := p.tools[tool.Name]; !ok && !p.allTools {
+		return false
+	}
+	return tool.Annotations.IdempotentHint != nil && *tool.Annotations.IdempotentHint
+}
+
+// backoff returns the delay before the given retry, doubling from the initial backoff with a jitter of up to half of it.
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	delay := p.initialBackoff << (retry - 1)
+	if delay <= 0 || delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// isTransient tells whether a call failed on the way to the server, the errors answered by the server are final.
+func isTransient(err error) bool {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+		!client.IsOAuthAuthorizationRequiredError(err) && !errors.Is(err, transport.ErrOAuthAuthorizationRequired)
+}
+
+// withRetry runs an idempotent call, retrying it while it fails with a transient error
+// and neither the attempts nor ctx are exhausted.
+func withRetry[T any](ctx context.Context, p *retryPolicy, logger *zap.Logger, serverName string, method mcp.MCPMethod, call func(ctx context.Context) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := call(ctx)
+		if err == nil || attempt >= p.maxAttempts || !isTransient(err) {
+			return result, err
+		}
+		delay := p.backoff(attempt)
+		logger.Info("Retry upstream call", zap.String("mcpServerName", serverName), zap.String("method", string(method)),
+			zap.Int("attempt", attempt), zap.Duration("backoff", delay), zap.Error(err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
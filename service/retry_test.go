@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestRetriesTool(t *testing.T) {
+	idempotent := mcp.NewTool("get", mcp.WithIdempotentHintAnnotation(true))
+	notIdempotent := mcp.NewTool("post", mcp.WithIdempotentHintAnnotation(false))
+	unannotated := mcp.Tool{Name: "get"}
+
+	p := newRetryPolicy(&model.RetryPolicy{Tools: []string{"get"}})
+	if !p.retriesTool(idempotent) {
+		t.Error("opted in idempotent tool not retried")
+	}
+	if p.retriesTool(unannotated) {
+		t.Error("tool without idempotent hint retried")
+	}
+	if p.retriesTool(notIdempotent) {
+		t.Error("tool not opted in retried")
+	}
+	if all := newRetryPolicy(&model.RetryPolicy{Tools: []string{"*"}}); all.retriesTool(notIdempotent) {
+		t.Error("tool not idempotent retried")
+	}
+	if none := newRetryPolicy(nil); none.retriesTool(idempotent) {
+		t.Error("tool retried without opting in")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := newRetryPolicy(&model.RetryPolicy{InitialBackoffMs: 100, MaxBackoffMs: 300})
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 40: 300 * time.Millisecond} {
+		for range 20 {
+			if delay := p.backoff(retry); delay < max/2 || delay > max {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", retry, delay, max/2, max)
+			}
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	p := newRetryPolicy(&model.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 1})
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"transient then success", []error{errTransport, nil}, 2, false},
+		{"attempts exhausted", []error{errTransport, errTransport, errTransport, nil}, 3, true},
+		{"final error", []error{errors.New("invalid arguments"), nil}, 1, true},
+		{"deadline", []error{context.DeadlineExceeded, nil}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			_, err := withRetry(context.Background(), p, zap.NewNop(), "weather", mcp.MethodToolsCall, func(ctx context.Context) (int, error) {
+				err := tt.errs[calls]
+				calls++
+				return calls, err
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenCancelled(t *testing.T) {
+	p := newRetryPolicy(&model.RetryPolicy{MaxAttempts: 5, InitialBackoffMs: 60_000, MaxBackoffMs: 60_000})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls := 0
+	_, err := withRetry(ctx, p, zap.NewNop(), "weather", mcp.MethodToolsCall, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errTransport
+	})
+	if calls != 1 || !errors.Is(err, errTransport) {
+		t.Fatalf("calls = %d, error = %v", calls, err)
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"github.com/bytedance/sonic"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+)
+
+// equal tells whether two catalogs list the same entries.
+func (catalog *upstreamCatalog) equal(other *upstreamCatalog) bool {
+	data, err := sonic.ConfigStd.Marshal(catalog)
+	if err != nil {
+		return false
+	}
+	otherData, err := sonic.ConfigStd.Marshal(other)
+	return err == nil && bytes.Equal(data, otherData)
+}
+
+// loadCatalog returns the catalog persisted for the server, nil when there is none or it was listed
+// with another config.
+func (m *DynamicMCPServer) loadCatalog(mcpServer *model.McpServer, configKey string) *upstreamCatalog {
+	stored, err := m.catalogs.GetServerCatalog(mcpServer.UserId, mcpServer.ServerName)
+	if err != nil {
+		if !errors.Is(err, repository.ErrServerCatalogNotFound) {
+			m.logger.Warn("Load server catalog failed", zap.String("mcpServerName", mcpServer.ServerName), zap.Error(err))
+		}
+		return nil
+	}
+	if stored.ConfigKey != configKey {
+		return nil
+	}
+	var catalog upstreamCatalog
+	if err := sonic.Unmarshal(stored.Catalog, &catalog); err != nil {
+		m.logger.Warn("Load server catalog failed", zap.String("mcpServerName", mcpServer.ServerName), zap.Error(err))
+		return nil
+	}
+	return &catalog
+}
+
+// saveCatalog persists the catalog listed from the server, failing only logs a warning.
+func (m *DynamicMCPServer) saveCatalog(mcpServer *model.McpServer, configKey string, catalog *upstreamCatalog) {
+	data, err := sonic.Marshal(catalog)
+	if err == nil {
+		err = m.catalogs.UpsertServerCatalog(&model.ServerCatalog{
+			UserId:     mcpServer.UserId,
+			ServerName: mcpServer.ServerName,
+			ConfigKey:  configKey,
+			Catalog:    data,
+		})
+	}
+	if err != nil {
+		m.logger.Warn("Save server catalog failed", zap.String("mcpServerName", mcpServer.ServerName), zap.Error(err))
+	}
+}
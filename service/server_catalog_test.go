@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServePersistedCatalogBeforeConnect(t *testing.T) {
+	mcpServer := server.NewMCPServer("upstream", "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("echo", mcp.WithDescription("listed")),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("upstream"), nil
+		})
+	httpServer := server.NewStreamableHTTPServer(mcpServer)
+	// the upstream server holds every request until it is released
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			httpServer.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	m, store := newTestDynamicMCPServer(t)
+	registered := registerTestServer(t, store, "echo", upstream.URL)
+	m.saveCatalog(registered, cacheKey(registered), &upstreamCatalog{Tools: []mcp.Tool{mcp.NewTool("echo", mcp.WithDescription("persisted"))}})
+	proxy, err := m.proxy(registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(proxy)
+	t.Cleanup(proxyServer.Close)
+	session, _ := newTestSession(t, proxyServer.URL)
+
+	listDescription := func() string {
+		t.Helper()
+		result, err := session.ListTools(context.Background(), mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Tools) != 1 {
+			t.Fatalf("tools = %v, want echo", result.Tools)
+		}
+		return result.Tools[0].Description
+	}
+	if got := listDescription(); got != "persisted" {
+		t.Errorf("tool listed before connect = %q, want the persisted one", got)
+	}
+
+	// the calls wait for the upstream server to connect
+	called := make(chan string, 1)
+	go func() {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "echo"
+		result, err := session.CallTool(context.Background(), request)
+		if err != nil {
+			t.Error(err)
+			called <- ""
+			return
+		}
+		called <- result.Content[0].(mcp.TextContent).Text
+	}()
+	select {
+	case got := <-called:
+		t.Fatalf("call answered %q before the upstream server connected", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if got := <-called; got != "upstream" {
+		t.Errorf("call served by %q, want upstream", got)
+	}
+
+	// the catalog listed by the upstream server replaces the persisted one
+	if got := listDescription(); got != "listed" {
+		t.Errorf("tool listed once connected = %q, want the upstream one", got)
+	}
+	// it is persisted once the connection completed, after the calls waiting for it went through
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		catalog := m.loadCatalog(registered, cacheKey(registered))
+		if catalog != nil && catalog.Tools[0].Description == "listed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("persisted catalog = %+v, want the upstream one", catalog)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/internal/auth"
+	"github.com/tomeai/mcp-gateway/internal/cache"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/utils"
+	"go.uber.org/zap"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is the lifetime of the cached tool results
+const defaultCacheTTL = 60 * time.Second
+
+// toolCache answers the calls of the read-only tools of an upstream server from the results cached in store.
+// The results are cached per tool, arguments, server instance config and user, caller and forwarded headers.
+// The calls of anonymous callers are never cached, nothing tells them apart.
+type toolCache struct {
+	serverName string
+	userId     string
+	// configKey identifies the config and credentials of the server instance
+	configKey string
+	// forwarded are the canonical names of the downstream headers passed through to the upstream
+	forwarded []string
+	store     cache.Store
+	metrics   telemetry.CustomMetrics
+	logger    *zap.Logger
+	ttl       time.Duration
+	toolTTL   map[string]time.Duration
+	// tools opted in for caching, allTools when "*" is listed
+	tools    map[string]struct{}
+	allTools bool
+
+	mu sync.RWMutex
+	// cached maps the read-only tools opted in to the lifetime of their results, set once the tools are listed
+	cached map[string]time.Duration
+}
+
+// newToolCache returns nil unless the config of the server enables the cache.
+func newToolCache(serverName, userId, configKey string, forwarded []string, conf *model.ToolCache, store cache.Store, metrics telemetry.CustomMetrics, logger *zap.Logger) *toolCache {
+	if conf == nil || store == nil {
+		return nil
+	}
+	c := &toolCache{
+		serverName: serverName,
+		userId:     userId,
+		configKey:  configKey,
+		store:      store,
+		metrics:    metrics,
+		logger:     logger,
+		ttl:        defaultCacheTTL,
+		toolTTL:    make(map[string]time.Duration),
+		tools:      make(map[string]struct{}),
+	}
+	if conf.TTLSeconds > 0 {
+		c.ttl = time.Duration(conf.TTLSeconds) * time.Second
+	}
+	for _, name := range forwarded {
+		c.forwarded = append(c.forwarded, http.CanonicalHeaderKey(name))
+	}
+	slices.Sort(c.forwarded)
+	c.forwarded = slices.Compact(c.forwarded)
+	for tool, seconds := range conf.ToolTTLSeconds {
+		c.toolTTL[tool] = time.Duration(seconds) * time.Second
+	}
+	for _, tool := range conf.Tools {
+		if tool == "*" {
+			c.allTools = true
+		}
+		c.tools[tool] = struct{}{}
+	}
+	return c
+}
+
+// setTools records the tools whose results are cached among those listed: they must be opted in and annotated
+// read-only. A tool whose ttl is 0 is not cached.
+func (c *toolCache) setTools(tools []mcp.Tool) {
+	cached := make(map[string]time.Duration)
+	for _, tool := range tools {
+		if _, ok := c.tools[tool.Name]; !ok && !c.allTools {
+			continue
+		}
+		if tool.Annotations.ReadOnlyHint == nil || !*tool.Annotations.ReadOnlyHint {
+			continue
+		}
+		ttl, ok := c.toolTTL[tool.Name]
+		if !ok {
+			ttl = c.ttl
+		}
+		if ttl > 0 {
+			cached[tool.Name] = ttl
+		}
+	}
+	c.mu.Lock()
+	c.cached = cached
+	c.mu.Unlock()
+}
+
+// toolCachePrefix returns the prefix of the cache keys of the results of a server, of one of its tools when toolName is set.
+func toolCachePrefix(serverName, toolName string) string {
+	if toolName == "" {
+		return serverName + "/"
+	}
+	return serverName + "/" + toolName + "/"
+}
+
+// key identifies the result of a call by its tool, its arguments with the map keys sorted, the config of the server
+// instance, the user and caller it is served to and the values of the headers forwarded upstream.
+func (c *toolCache) key(ctx context.Context, caller *auth.Caller, request mcp.CallToolRequest) (string, error) {
+	arguments, err := sonic.ConfigStd.Marshal(request.Params.Arguments)
+	if err != nil {
+		return "", err
+	}
+	var scope strings.Builder
+	scope.WriteString(c.configKey + "\n" + c.userId + "\n")
+	if caller != nil {
+		scope.WriteString(caller.UserId)
+	}
+	scope.WriteString("\n")
+	incoming := incomingHeaders(ctx)
+	for _, name := range c.forwarded {
+		// the values are quoted, they cannot spill into the next header
+		scope.WriteString(name + ": " + strconv.Quote(strings.Join(incoming.Values(name), ", ")) + "\n")
+	}
+	return toolCachePrefix(c.serverName, request.Params.Name) + utils.Md5String(scope.String()+string(arguments)), nil
+}
+
+// call answers a tool call from the cache, forwarding it on a miss and caching its result unless it is an error.
+// The failures of the store only log a warning, the call is forwarded.
+func (c *toolCache) call(ctx context.Context, request mcp.CallToolRequest, forward func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)) (*mcp.CallToolResult, error) {
+	toolName := request.Params.Name
+	c.mu.RLock()
+	ttl, ok := c.cached[toolName]
+	c.mu.RUnlock()
+	caller := auth.CallerFromContext(ctx)
+	if !ok || (caller != nil && caller.Anonymous) {
+		return forward(ctx, request)
+	}
+	key, err := c.key(ctx, caller, request)
+	if err != nil {
+		return forward(ctx, request)
+	}
+	data, found, err := c.store.Get(ctx, key)
+	if err != nil {
+		c.logger.Warn("Get cached tool result failed", zap.String("mcpServerName", c.serverName), zap.String("toolName", toolName), zap.Error(err))
+	} else if found {
+		var result mcp.CallToolResult
+		if err := sonic.Unmarshal(data, &result); err == nil {
+			c.metrics.RecordToolCacheLookup(ctx, c.serverName, toolName, telemetry.CacheHit)
+			return &result, nil
+		}
+	}
+	c.metrics.RecordToolCacheLookup(ctx, c.serverName, toolName, telemetry.CacheMiss)
+
+	result, err := forward(ctx, request)
+	if err != nil || result.IsError {
+		return result, err
+	}
+	if data, err = sonic.Marshal(result); err == nil {
+		err = c.store.Set(ctx, key, data, ttl)
+	}
+	if err != nil {
+		c.logger.Warn("Cache tool result failed", zap.String("mcpServerName", c.serverName), zap.String("toolName", toolName), zap.Error(err))
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/tomeai/mcp-gateway/internal/auth"
+	"github.com/tomeai/mcp-gateway/internal/cache"
+	"github.com/tomeai/mcp-gateway/internal/telemetry"
+	"github.com/tomeai/mcp-gateway/model"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+)
+
+func newTestToolCache(configKey string, forwarded []string, store cache.Store) *toolCache {
+	c := newToolCache("weather", "user:alice", configKey, forwarded, &model.ToolCache{Tools: []string{"*"}},
+		store, telemetry.NewNoopCustomMetrics(), zap.NewNop())
+	c.setTools([]mcp.Tool{
+		mcp.NewTool("forecast", mcp.WithReadOnlyHintAnnotation(true)),
+		mcp.NewTool("book", mcp.WithReadOnlyHintAnnotation(false)),
+	})
+	return c
+}
+
+// countingForward answers every call with a fresh result, counting the calls forwarded upstream.
+func countingForward(calls *int) func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		*calls++
+		return mcp.NewToolResultText("sunny"), nil
+	}
+}
+
+func callerContext(caller *auth.Caller, header http.Header) context.Context {
+	return withIncomingHeaders(auth.WithCaller(context.Background(), caller), header)
+}
+
+func toolRequest(name string) mcp.CallToolRequest {
+	request := mcp.CallToolRequest{}
+	request.Params.Name = name
+	request.Params.Arguments = map[string]any{"city": "Paris"}
+	return request
+}
+
+func TestToolCacheScopes(t *testing.T) {
+	alice := &auth.Caller{UserId: auth.UserIdOf("alice")}
+	bob := &auth.Caller{UserId: auth.UserIdOf("bob")}
+	tests := []struct {
+		name      string
+		forwarded []string
+		first     context.Context
+		second    context.Context
+		// otherKey serves the second call from a cache built for another config
+		otherKey bool
+		want     int
+	}{
+		{"same caller", nil, callerContext(alice, nil), callerContext(alice, nil), false, 1},
+		{"other caller", nil, callerContext(alice, nil), callerContext(bob, nil), false, 2},
+		{"other config", nil, callerContext(alice, nil), callerContext(alice, nil), true, 2},
+		{"same forwarded header", []string{"x-tenant"},
+			callerContext(alice, http.Header{"X-Tenant": {"a"}}), callerContext(alice, http.Header{"X-Tenant": {"a"}}), false, 1},
+		{"other forwarded header", []string{"x-tenant"},
+			callerContext(alice, http.Header{"X-Tenant": {"a"}}), callerContext(alice, http.Header{"X-Tenant": {"b"}}), false, 2},
+		{"header not forwarded", nil,
+			callerContext(alice, http.Header{"X-Tenant": {"a"}}), callerContext(alice, http.Header{"X-Tenant": {"b"}}), false, 1},
+		{"anonymous", nil,
+			callerContext(&auth.Caller{UserId: model.DefaultUserId, Anonymous: true}, nil),
+			callerContext(&auth.Caller{UserId: model.DefaultUserId, Anonymous: true}, nil), false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := cache.NewLRU(10)
+			c := newTestToolCache("config-1", tt.forwarded, store)
+			calls := 0
+			if _, err := c.call(tt.first, toolRequest("forecast"), countingForward(&calls)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.otherKey {
+				c = newTestToolCache("config-2", tt.forwarded, store)
+			}
+			result, err := c.call(tt.second, toolRequest("forecast"), countingForward(&calls))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls != tt.want {
+				t.Errorf("forwarded %d calls, want %d", calls, tt.want)
+			}
+			if text := result.Content[0].(mcp.TextContent).Text; text != "sunny" {
+				t.Errorf("result = %q", text)
+			}
+		})
+	}
+}
+
+func TestToolCacheSkipsToolsNotReadOnly(t *testing.T) {
+	c := newTestToolCache("config-1", nil, cache.NewLRU(10))
+	ctx := callerContext(&auth.Caller{UserId: auth.UserIdOf("alice")}, nil)
+	calls := 0
+	for range 2 {
+		if _, err := c.call(ctx, toolRequest("book"), countingForward(&calls)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("forwarded %d calls, want 2", calls)
+	}
+}
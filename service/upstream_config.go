@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/model"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// gatewayEnvPrefix marks the process env of the gateway itself (e.g. its master key),
+// which server configs may not reference
+const gatewayEnvPrefix = "MCP_GATEWAY_"
+
+const secretVariablePrefix = "secret:"
+
+// variablePattern matches the ${VAR} and ${secret:NAME} references of a server config
+var variablePattern = regexp.MustCompile(`\$\{([^}]+)}`)
+
+// upstreamConfig returns the config of a registered server with the env and headers
+// of the registration layered over those of its base config.
+// The env and headers of a server template instance are the credentials of a user, they are taken literally.
+func upstreamConfig(mcpServer *model.McpServer) (*model.MCPClientConfig, error) {
+	conf := &model.MCPClientConfig{}
+	if err := sonic.Unmarshal(mcpServer.ServerConfig, conf); err != nil {
+		return nil, err
+	}
+	env, err := decodeJSONMap(mcpServer.Env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid env: %w", err)
+	}
+	headers, err := decodeJSONMap(mcpServer.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid headers: %w", err)
+	}
+	conf.Env = layerMap(conf.Env, env)
+	conf.Headers = layerMap(conf.Headers, headers)
+	if mcpServer.TemplateInstance {
+		conf.LiteralEnv = slices.Sorted(maps.Keys(env))
+		conf.LiteralHeaders = slices.Sorted(maps.Keys(headers))
+	}
+	return conf, nil
+}
+
+func decodeJSONMap(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var values map[string]string
+	if err := sonic.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func layerMap(base, values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return base
+	}
+	layered := maps.Clone(base)
+	if layered == nil {
+		layered = make(map[string]string, len(values))
+	}
+	maps.Copy(layered, values)
+	return layered
+}
+
+// resolveMCPClientConfig returns a copy of the config with its env and header values, OAuth client secret
+// and TLS client key decrypted, and the ${VAR} references of its command, args, url, proxy, env, headers,
+// client secret and TLS certificates interpolated.
+// VAR resolves from the env of the config, then the process env, then the secret store,
+// ${secret:NAME} only from the secret store. The literal env and header values are never interpolated.
+func resolveMCPClientConfig(conf *model.MCPClientConfig, secrets *secret.Resolver) (*model.MCPClientConfig, error) {
+	resolved := *conf
+	env, err := secrets.DecryptMap(conf.Env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt env %w", err)
+	}
+	headers, err := secrets.DecryptMap(conf.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt header %w", err)
+	}
+
+	// env values may only reference the process env and the secret store
+	lookupOutside := func(name string) (string, error) {
+		if strings.HasPrefix(name, secretVariablePrefix) {
+			return secrets.Secret(strings.TrimPrefix(name, secretVariablePrefix))
+		}
+		if value, ok := os.LookupEnv(name); ok && !strings.HasPrefix(name, gatewayEnvPrefix) {
+			return value, nil
+		}
+		if value, err := secrets.Secret(name); err == nil {
+			return value, nil
+		}
+		return "", fmt.Errorf("undefined variable ${%s}", name)
+	}
+	for k, v := range env {
+		if slices.Contains(conf.LiteralEnv, k) {
+			continue
+		}
+		if env[k], err = interpolate(v, lookupOutside); err != nil {
+			return nil, fmt.Errorf("env %s: %w", k, err)
+		}
+	}
+	lookup := func(name string) (string, error) {
+		if value, ok := env[name]; ok {
+			return value, nil
+		}
+		return lookupOutside(name)
+	}
+
+	if resolved.Command, err = interpolate(conf.Command, lookup); err != nil {
+		return nil, fmt.Errorf("command: %w", err)
+	}
+	if conf.Args != nil {
+		resolved.Args = make([]string, len(conf.Args))
+		for i, arg := range conf.Args {
+			if resolved.Args[i], err = interpolate(arg, lookup); err != nil {
+				return nil, fmt.Errorf("args: %w", err)
+			}
+		}
+	}
+	if resolved.URL, err = interpolate(conf.URL, lookup); err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+	if resolved.Proxy, err = interpolate(conf.Proxy, lookup); err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	for k, v := range headers {
+		if slices.Contains(conf.LiteralHeaders, k) {
+			continue
+		}
+		if headers[k], err = interpolate(v, lookup); err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+	}
+	if conf.OAuth != nil {
+		oauth := *conf.OAuth
+		if oauth.ClientSecret, err = secrets.Decrypt(oauth.ClientSecret); err != nil {
+			return nil, fmt.Errorf("failed to decrypt oauth client secret %w", err)
+		}
+		if oauth.ClientSecret, err = interpolate(oauth.ClientSecret, lookup); err != nil {
+			return nil, fmt.Errorf("oauth client secret: %w", err)
+		}
+		resolved.OAuth = &oauth
+	}
+	if conf.TLS != nil {
+		tls := *conf.TLS
+		if tls.ClientKey, err = secrets.Decrypt(tls.ClientKey); err != nil {
+			return nil, fmt.Errorf("failed to decrypt tls client key %w", err)
+		}
+		for name, value := range map[string]*string{"ca": &tls.CA, "client cert": &tls.ClientCert, "client key": &tls.ClientKey} {
+			if *value, err = interpolate(*value, lookup); err != nil {
+				return nil, fmt.Errorf("tls %s: %w", name, err)
+			}
+		}
+		resolved.TLS = &tls
+	}
+	resolved.Env = env
+	resolved.Headers = headers
+	return &resolved, nil
+}
+
+// interpolate replaces the ${VAR} references of s with the values returned by lookup.
+func interpolate(s string, lookup func(name string) (string, error)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var lookupErr error
+	interpolated := variablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if lookupErr != nil {
+			return match
+		}
+		value, err := lookup(match[2 : len(match)-1])
+		if err != nil {
+			lookupErr = err
+			return match
+		}
+		return value
+	})
+	return interpolated, lookupErr
+}
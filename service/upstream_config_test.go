@@ -0,0 +1,180 @@
+package service
+
+import (
+	"errors"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/model"
+	"strings"
+	"testing"
+)
+
+type secretMap map[string]string
+
+func (s secretMap) GetSecret(name string) (string, error) {
+	value, ok := s[name]
+	if !ok {
+		return "", errors.New("secret not found")
+	}
+	return value, nil
+}
+
+func newTestResolver(t *testing.T, secrets secretMap) (*secret.Resolver, *secret.Cipher) {
+	t.Helper()
+	c, err := secret.NewCipherFromKey([]byte(strings.Repeat("k", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret.NewResolver(c, secrets), c
+}
+
+func TestResolveMCPClientConfig(t *testing.T) {
+	t.Setenv("UPSTREAM_HOST", "upstream.internal")
+	t.Setenv("MCP_GATEWAY_MASTER_KEY", "master")
+	resolver, _ := newTestResolver(t, secretMap{"API_KEY": "from-store", "TOKEN": "token"})
+
+	tests := []struct {
+		name    string
+		conf    model.MCPClientConfig
+		want    func(conf *model.MCPClientConfig) string
+		wantVal string
+		wantErr string
+	}{
+		{
+			name:    "config env",
+			conf:    model.MCPClientConfig{URL: "https://${HOST}/mcp", Env: map[string]string{"HOST": "example.com"}},
+			want:    func(conf *model.MCPClientConfig) string { return conf.URL },
+			wantVal: "https://example.com/mcp",
+		},
+		{
+			name:    "config env over process env",
+			conf:    model.MCPClientConfig{URL: "https://${UPSTREAM_HOST}/mcp", Env: map[string]string{"UPSTREAM_HOST": "example.com"}},
+			want:    func(conf *model.MCPClientConfig) string { return conf.URL },
+			wantVal: "https://example.com/mcp",
+		},
+		{
+			name:    "process env",
+			conf:    model.MCPClientConfig{URL: "https://${UPSTREAM_HOST}/mcp"},
+			want:    func(conf *model.MCPClientConfig) string { return conf.URL },
+			wantVal: "https://upstream.internal/mcp",
+		},
+		{
+			name:    "secret store",
+			conf:    model.MCPClientConfig{Headers: map[string]string{"Authorization": "Bearer ${API_KEY}"}},
+			want:    func(conf *model.MCPClientConfig) string { return conf.Headers["Authorization"] },
+			wantVal: "Bearer from-store",
+		},
+		{
+			name:    "named secret",
+			conf:    model.MCPClientConfig{Env: map[string]string{"TOKEN": "plain"}, Args: []string{"--token=${secret:TOKEN}"}},
+			want:    func(conf *model.MCPClientConfig) string { return conf.Args[0] },
+			wantVal: "--token=token",
+		},
+		{
+			name:    "env referencing the process env",
+			conf:    model.MCPClientConfig{Command: "server", Env: map[string]string{"URL": "https://${UPSTREAM_HOST}"}},
+			want:    func(conf *model.MCPClientConfig) string { return conf.Env["URL"] },
+			wantVal: "https://upstream.internal",
+		},
+		{
+			name:    "env of the gateway",
+			conf:    model.MCPClientConfig{Command: "server", Args: []string{"${MCP_GATEWAY_MASTER_KEY}"}},
+			wantErr: "undefined variable ${MCP_GATEWAY_MASTER_KEY}",
+		},
+		{
+			name:    "env referencing env",
+			conf:    model.MCPClientConfig{Command: "server", Env: map[string]string{"A": "a", "B": "${A}"}},
+			wantErr: "undefined variable ${A}",
+		},
+		{
+			name:    "undefined variable",
+			conf:    model.MCPClientConfig{URL: "https://${MISSING}/mcp"},
+			wantErr: "undefined variable ${MISSING}",
+		},
+		{
+			name:    "undefined named secret",
+			conf:    model.MCPClientConfig{URL: "https://${secret:UPSTREAM_HOST}/mcp"},
+			wantErr: "url: secret not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := resolveMCPClientConfig(&tt.conf, resolver)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.want(conf); got != tt.wantVal {
+				t.Errorf("resolved %q, want %q", got, tt.wantVal)
+			}
+		})
+	}
+}
+
+func TestResolveMCPClientConfigDecrypts(t *testing.T) {
+	resolver, c := newTestResolver(t, nil)
+	encrypted, err := c.Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &model.MCPClientConfig{
+		URL:     "https://example.com/mcp",
+		Env:     map[string]string{"KEY": encrypted},
+		Headers: map[string]string{"Authorization": "Bearer ${KEY}"},
+		OAuth:   &model.UpstreamOAuth{ClientSecret: encrypted},
+	}
+	resolved, err := resolveMCPClientConfig(conf, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Env["KEY"] != "s3cret" || resolved.Headers["Authorization"] != "Bearer s3cret" || resolved.OAuth.ClientSecret != "s3cret" {
+		t.Errorf("resolved config = %+v, oauth %+v", resolved, resolved.OAuth)
+	}
+	// the stored config keeps its encrypted values
+	if conf.Env["KEY"] != encrypted || conf.OAuth.ClientSecret != encrypted {
+		t.Error("resolveMCPClientConfig() modified its config")
+	}
+}
+
+func TestTemplateInstanceValuesAreLiteral(t *testing.T) {
+	t.Setenv("UPSTREAM_HOST", "upstream.internal")
+	resolver, _ := newTestResolver(t, secretMap{"ADMIN_TOKEN": "admin"})
+	server := &model.McpServer{
+		ServerName:   "github",
+		ServerConfig: []byte(`{"url":"https://${UPSTREAM_HOST}/mcp","headers":{"X-Tenant":"${UPSTREAM_HOST}"}}`),
+		// the credentials of a user must not read the secrets or the env of the gateway
+		Env:              []byte(`{"TOKEN":"${secret:ADMIN_TOKEN}"}`),
+		Headers:          []byte(`{"Authorization":"Bearer ${ADMIN_TOKEN}"}`),
+		TemplateInstance: true,
+	}
+	conf, err := upstreamConfig(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := resolveMCPClientConfig(conf, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Env["TOKEN"] != "${secret:ADMIN_TOKEN}" || resolved.Headers["Authorization"] != "Bearer ${ADMIN_TOKEN}" {
+		t.Errorf("credentials interpolated: env %v, headers %v", resolved.Env, resolved.Headers)
+	}
+	if resolved.URL != "https://upstream.internal/mcp" || resolved.Headers["X-Tenant"] != "upstream.internal" {
+		t.Errorf("template config not interpolated: url %s, headers %v", resolved.URL, resolved.Headers)
+	}
+
+	// the values of a registered server are interpolated
+	server.TemplateInstance = false
+	if conf, err = upstreamConfig(server); err != nil {
+		t.Fatal(err)
+	}
+	if resolved, err = resolveMCPClientConfig(conf, resolver); err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Env["TOKEN"] != "admin" || resolved.Headers["Authorization"] != "Bearer admin" {
+		t.Errorf("registration values not interpolated: env %v, headers %v", resolved.Env, resolved.Headers)
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/model"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// newUpstreamHTTPClient returns the HTTP client of an SSE or streamable HTTP upstream server.
+// It only dials the destinations allowed by the egress policy, through the proxy of the server if any.
+// Through a proxy, the destination of each request is resolved and checked before it is sent to the proxy.
+// The proxy of the process env is ignored.
+func newUpstreamHTTPClient(serverURL, proxy string, tlsConf *model.UpstreamTLS, policy *egress.Policy) (*http.Client, error) {
+	if err := policy.CheckURL(serverURL); err != nil {
+		return nil, err
+	}
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.Proxy = nil
+	httpTransport.DialContext = policy.DialContext(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+	if proxy != "" {
+		if err := policy.CheckURL(proxy); err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		httpTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if err := policy.CheckHost(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			return proxyURL, nil
+		}
+	}
+	if tlsConf != nil {
+		tlsConfig, err := newUpstreamTLSConfig(tlsConf)
+		if err != nil {
+			return nil, err
+		}
+		httpTransport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: httpTransport}, nil
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpstreamHTTPClientChecksProxiedDestinations(t *testing.T) {
+	var proxied atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	// the proxy listens on the loopback address, the only private address allowed
+	policy, err := egress.NewPolicyFromConfig(egress.Config{AllowCIDRs: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient, err := newUpstreamHTTPClient("http://127.0.0.1:8080/mcp", proxy.URL, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := httpClient.Get("http://127.0.0.1:8080/mcp")
+	if err != nil {
+		t.Fatalf("Get allowed destination error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if proxied.Load() != 1 {
+		t.Fatalf("proxy received %d requests, want 1", proxied.Load())
+	}
+
+	// e.g. the destination of a redirect, only the proxy address is ever dialed
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data/", "http://10.0.0.1/mcp"} {
+		if _, err := httpClient.Get(target); !errors.Is(err, egress.ErrDenied) {
+			t.Errorf("Get %s error = %v, want ErrDenied", target, err)
+		}
+	}
+	if proxied.Load() != 1 {
+		t.Fatalf("proxy received %d requests, want 1", proxied.Load())
+	}
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// authorizationTTL bounds the time a user has to complete an authorization started at the gateway
+const authorizationTTL = 10 * time.Minute
+
+// oauthRequestTimeout bounds the requests sent to the authorization server of an upstream server
+const oauthRequestTimeout = 30 * time.Second
+
+var (
+	// ErrOAuthNotConfigured is returned when authorizing a server whose config has no oauth block.
+	ErrOAuthNotConfigured = errors.New("mcp server is not configured for oauth")
+	// ErrUnknownAuthorization is returned by the callback for a state the gateway did not issue or that expired.
+	ErrUnknownAuthorization = errors.New("unknown or expired authorization")
+)
+
+// AuthorizationRequiredError tells that the gateway holds no valid token for an upstream server,
+// because it was never authorized or its refresh token was rejected.
+type AuthorizationRequiredError struct {
+	ServerName string
+}
+
+func (e *AuthorizationRequiredError) Error() string {
+	return fmt.Sprintf("mcp server %s requires authorization, authorize it at /api/v0/servers/%s/oauth/authorize", e.ServerName, e.ServerName)
+}
+
+// asAuthorizationRequired wraps the authorization errors of the OAuth transports into an AuthorizationRequiredError.
+func asAuthorizationRequired(err error, serverName string) error {
+	if err != nil && (client.IsOAuthAuthorizationRequiredError(err) || errors.Is(err, transport.ErrOAuthAuthorizationRequired)) {
+		return &AuthorizationRequiredError{ServerName: serverName}
+	}
+	return err
+}
+
+// oauthTokenStore persists the tokens of an upstream server for a user, it implements transport.TokenStore.
+type oauthTokenStore struct {
+	repo       repository.OAuthTokenRepository
+	secrets    *secret.Resolver
+	userId     string
+	serverName string
+
+	// client registered dynamically, stored along the token
+	mu           sync.Mutex
+	clientId     string
+	clientSecret string
+}
+
+func newOAuthTokenStore(repo repository.OAuthTokenRepository, secrets *secret.Resolver, userId, serverName string) (*oauthTokenStore, error) {
+	s := &oauthTokenStore{
+		repo:       repo,
+		secrets:    secrets,
+		userId:     userId,
+		serverName: serverName,
+	}
+	stored, err := repo.GetOAuthToken(userId, serverName)
+	if errors.Is(err, repository.ErrOAuthTokenNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.clientId = stored.ClientId
+	if s.clientSecret, err = secrets.Decrypt(stored.ClientSecret); err != nil {
+		return nil, fmt.Errorf("failed to decrypt oauth client secret %w", err)
+	}
+	return s, nil
+}
+
+func (s *oauthTokenStore) GetToken(ctx context.Context) (*transport.Token, error) {
+	stored, err := s.repo.GetOAuthToken(s.userId, s.serverName)
+	if errors.Is(err, repository.ErrOAuthTokenNotFound) {
+		return nil, transport.ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Token == "" {
+		return nil, transport.ErrNoToken
+	}
+	data, err := s.secrets.Decrypt(stored.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt oauth token %w", err)
+	}
+	token := &transport.Token{}
+	if err := sonic.UnmarshalString(data, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *oauthTokenStore) SaveToken(ctx context.Context, token *transport.Token) error {
+	data, err := sonic.MarshalString(token)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repo.UpsertOAuthToken(&model.OAuthToken{
+		UserId:       s.userId,
+		ServerName:   s.serverName,
+		ClientId:     s.clientId,
+		ClientSecret: s.clientSecret,
+		Token:        data,
+	})
+}
+
+// setClient records the client registered dynamically, it is stored with the next token.
+func (s *oauthTokenStore) setClient(clientId, clientSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientId = clientId
+	s.clientSecret = clientSecret
+}
+
+// oauthConfig returns the OAuth client config of the upstream server, falling back to the client
+// registered dynamically when the server config declares none. The discovery, registration and token requests
+// are sent with httpClient, the client of the upstream server bound to its egress policy, proxy and TLS settings.
+func (s *oauthTokenStore) oauthConfig(conf *model.UpstreamOAuth, httpClient *http.Client) transport.OAuthConfig {
+	oauthClient := *httpClient
+	oauthClient.Timeout = oauthRequestTimeout
+	config := transport.OAuthConfig{
+		HTTPClient:            &oauthClient,
+		ClientID:              conf.ClientID,
+		ClientSecret:          conf.ClientSecret,
+		RedirectURI:           conf.RedirectURI,
+		Scopes:                conf.Scopes,
+		TokenStore:            s,
+		AuthServerMetadataURL: conf.AuthServerMetadataURL,
+		PKCEEnabled:           true,
+	}
+	if config.ClientID == "" {
+		s.mu.Lock()
+		config.ClientID = s.clientId
+		config.ClientSecret = s.clientSecret
+		s.mu.Unlock()
+	}
+	return config
+}
+
+// pendingAuthorization is an authorization started at the gateway, waiting for the callback.
+type pendingAuthorization struct {
+	handler      *transport.OAuthHandler
+	codeVerifier string
+	serverName   string
+	userId       string
+	expiresAt    time.Time
+}
+
+// AuthorizeUpstream starts the authorization code flow with PKCE of an upstream server for a user
+// and returns the URL of the authorization server to visit. The authorization server redirects
+// to redirectURI, unless the server config sets its own, which must complete it with CompleteUpstreamAuthorization.
+func (m *DynamicMCPServer) AuthorizeUpstream(ctx context.Context, userId, serverName, redirectURI string) (string, error) {
+	mcpServer, err := m.mcpServerService.GetMcpServer(userId, serverName)
+	if err != nil {
+		return "", err
+	}
+	clientConfig, err := upstreamConfig(mcpServer)
+	if err != nil {
+		return "", err
+	}
+	conf, err := resolveMCPClientConfig(clientConfig, m.secrets)
+	if err != nil {
+		return "", err
+	}
+	if conf.OAuth == nil || conf.URL == "" {
+		return "", ErrOAuthNotConfigured
+	}
+	serverURL, err := url.Parse(conf.URL)
+	if err != nil {
+		return "", err
+	}
+	store, err := newOAuthTokenStore(m.oauthTokens, m.secrets, mcpServer.UserId, serverName)
+	if err != nil {
+		return "", err
+	}
+	httpClient, err := newUpstreamHTTPClient(conf.URL, conf.Proxy, conf.TLS, m.egress)
+	if err != nil {
+		return "", err
+	}
+	oauthConfig := store.oauthConfig(conf.OAuth, httpClient)
+	if oauthConfig.RedirectURI == "" {
+		oauthConfig.RedirectURI = redirectURI
+	}
+	handler := transport.NewOAuthHandler(oauthConfig)
+	handler.SetBaseURL(fmt.Sprintf("%s://%s", serverURL.Scheme, serverURL.Host))
+	if conf.OAuth.ClientID == "" {
+		// register a client of our own on each authorization, the redirect uri may have changed
+		if err := handler.RegisterClient(ctx, "wemcp-gateway"); err != nil {
+			return "", fmt.Errorf("failed to register oauth client: %w", err)
+		}
+		store.setClient(handler.GetClientID(), handler.GetClientSecret())
+	}
+
+	codeVerifier, err := client.GenerateCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	state, err := client.GenerateState()
+	if err != nil {
+		return "", err
+	}
+	authURL, err := handler.GetAuthorizationURL(ctx, state, client.GenerateCodeChallenge(codeVerifier))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	m.pendingAuthorizations.Range(func(key, value any) bool {
+		if value.(*pendingAuthorization).expiresAt.Before(now) {
+			m.pendingAuthorizations.Delete(key)
+		}
+		return true
+	})
+	m.pendingAuthorizations.Store(state, &pendingAuthorization{
+		handler:      handler,
+		codeVerifier: codeVerifier,
+		serverName:   serverName,
+		userId:       mcpServer.UserId,
+		expiresAt:    now.Add(authorizationTTL),
+	})
+	return authURL, nil
+}
+
+// CompleteUpstreamAuthorization exchanges the code returned to the callback for the tokens of the upstream server
+// and returns the name of the server. The proxies of the server are rebuilt with the new tokens.
+func (m *DynamicMCPServer) CompleteUpstreamAuthorization(ctx context.Context, state, code string) (string, error) {
+	v, ok := m.pendingAuthorizations.LoadAndDelete(state)
+	if !ok {
+		return "", ErrUnknownAuthorization
+	}
+	pending := v.(*pendingAuthorization)
+	if pending.expiresAt.Before(time.Now()) {
+		return "", ErrUnknownAuthorization
+	}
+	if err := pending.handler.ProcessAuthorizationResponse(ctx, code, state, pending.codeVerifier); err != nil {
+		return "", err
+	}
+	m.logger.Info("Authorized mcp server", zap.String("mcpServerName", pending.serverName), zap.String("userId", pending.userId))
+	m.evict([]string{pending.serverName})
+	return pending.serverName, nil
+}
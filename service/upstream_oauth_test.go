@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/tomeai/mcp-gateway/internal/egress"
+	"github.com/tomeai/mcp-gateway/internal/secret"
+	"github.com/tomeai/mcp-gateway/model"
+	"github.com/tomeai/mcp-gateway/repository"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOAuthTokenStore(t *testing.T) {
+	store, err := newOAuthTokenStore(repository.NewMemoryStore(), secret.NewResolver(nil, nil), "user:alice", "github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := store.GetToken(ctx); !errors.Is(err, transport.ErrNoToken) {
+		t.Fatalf("GetToken() error = %v, want ErrNoToken", err)
+	}
+	if err := store.SaveToken(ctx, &transport.Token{AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.GetToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("GetToken() = %+v", token)
+	}
+}
+
+func TestOAuthRequestsFollowEgressPolicy(t *testing.T) {
+	var requests atomic.Int64
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"issuer": "http://127.0.0.1", "authorization_endpoint": "http://127.0.0.1/authorize", "token_endpoint": "http://127.0.0.1/token"}`))
+	}))
+	defer authServer.Close()
+
+	store, err := newOAuthTokenStore(repository.NewMemoryStore(), secret.NewResolver(nil, nil), "user:alice", "github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &model.UpstreamOAuth{
+		ClientID:              "gateway",
+		RedirectURI:           "https://gateway.example.com/api/v0/oauth/callback",
+		AuthServerMetadataURL: authServer.URL + "/.well-known/oauth-authorization-server",
+	}
+	policy, err := egress.NewPolicyFromConfig(egress.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient, err := newUpstreamHTTPClient("https://mcp.example.com/mcp", "", nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the upstream server advertises an authorization server on a private address
+	handler := transport.NewOAuthHandler(store.oauthConfig(conf, httpClient))
+	if _, err := handler.GetAuthorizationURL(context.Background(), "state", "challenge"); !errors.Is(err, egress.ErrDenied) {
+		t.Fatalf("GetAuthorizationURL() error = %v, want ErrDenied", err)
+	}
+	if requests.Load() != 0 {
+		t.Fatalf("authorization server received %d requests, want 0", requests.Load())
+	}
+
+	// once private addresses are allowed, the same flow reaches it
+	policy, err = egress.NewPolicyFromConfig(egress.Config{AllowPrivate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpClient, err = newUpstreamHTTPClient("https://mcp.example.com/mcp", "", nil, policy); err != nil {
+		t.Fatal(err)
+	}
+	handler = transport.NewOAuthHandler(store.oauthConfig(conf, httpClient))
+	if _, err := handler.GetAuthorizationURL(context.Background(), "state", "challenge"); err != nil {
+		t.Fatalf("GetAuthorizationURL() error = %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("authorization server received %d requests, want 1", requests.Load())
+	}
+}
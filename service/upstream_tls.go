@@ -0,0 +1,70 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/tomeai/mcp-gateway/model"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func newUpstreamTLSConfig(conf *model.UpstreamTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: conf.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if conf.MinVersion != "" {
+		version, ok := tlsVersions[conf.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls min version %s", conf.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if conf.CA != "" {
+		ca, err := readPEM(conf.CA)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("tls ca: no certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.ClientCert != "" || conf.ClientKey != "" {
+		if conf.ClientCert == "" || conf.ClientKey == "" {
+			return nil, errors.New("tls client cert and key must be set together")
+		}
+		certPEM, err := readPEM(conf.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("tls client cert: %w", err)
+		}
+		keyPEM, err := readPEM(conf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("tls client key: %w", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("tls client cert: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// readPEM returns the PEM value given inline or through a ${secret:NAME} reference, interpolated beforehand.
+// Files are never read, the server configs come from the admin API and must not read the files of the gateway host.
+func readPEM(value string) ([]byte, error) {
+	if !strings.Contains(value, "-----BEGIN") {
+		return nil, errors.New("not a PEM value, give it inline or as a ${secret:NAME} reference")
+	}
+	return []byte(value), nil
+}
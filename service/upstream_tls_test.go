@@ -0,0 +1,39 @@
+package service
+
+import (
+	"encoding/pem"
+	"github.com/tomeai/mcp-gateway/model"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpstreamTLSConfigPEM(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}))
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte(ca), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := newUpstreamTLSConfig(&model.UpstreamTLS{CA: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := httpClient.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Get with the inline ca error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	// the configs come from the admin API, they must not read the files of the gateway host
+	for _, conf := range []*model.UpstreamTLS{{CA: path}, {ClientCert: path, ClientKey: path}} {
+		if _, err := newUpstreamTLSConfig(conf); err == nil {
+			t.Errorf("newUpstreamTLSConfig(%+v) read a file", conf)
+		}
+	}
+}
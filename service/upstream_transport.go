@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bytedance/sonic"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"sync"
+)
+
+// upstreamTransport wraps the transport of an upstream client.
+// mcp.ServerCapabilities has no room for the newer capabilities (e.g. completions),
+// so the raw initialize result is kept around to look them up.
+type upstreamTransport struct {
+	transport.Interface
+
+	// started is set for transports that are already running when wrapped (stdio)
+	started bool
+
+	mu               sync.RWMutex
+	initializeResult json.RawMessage
+}
+
+func newUpstreamTransport(t transport.Interface, started bool) *upstreamTransport {
+	return &upstreamTransport{
+		Interface: t,
+		started:   started,
+	}
+}
+
+func (t *upstreamTransport) Start(ctx context.Context) error {
+	if t.started {
+		return nil
+	}
+	return t.Interface.Start(ctx)
+}
+
+func (t *upstreamTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	response, err := t.Interface.SendRequest(ctx, request)
+	if err == nil && response.Error == nil && request.Method == string(mcp.MethodInitialize) {
+		t.mu.Lock()
+		t.initializeResult = response.Result
+		t.mu.Unlock()
+	}
+	return response, err
+}
+
+// hasCapability reports whether the upstream server declared the named capability on initialize.
+func (t *upstreamTransport) hasCapability(name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.initializeResult) == 0 {
+		return false
+	}
+	var result struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	if err := sonic.Unmarshal(t.initializeResult, &result); err != nil {
+		return false
+	}
+	_, ok := result.Capabilities[name]
+	return ok
+}
+
+// SetProtocolVersion forwards the negotiated protocol version to http transports.
+func (t *upstreamTransport) SetProtocolVersion(version string) {
+	if httpConn, ok := t.Interface.(transport.HTTPConnection); ok {
+		httpConn.SetProtocolVersion(version)
+	}
+}
+
+// SetRequestHandler forwards server to client requests (e.g. sampling) for bidirectional transports.
+func (t *upstreamTransport) SetRequestHandler(handler transport.RequestHandler) {
+	if bidirectional, ok := t.Interface.(transport.BidirectionalInterface); ok {
+		bidirectional.SetRequestHandler(handler)
+	}
+}
+
+// SetConnectionLostHandler forwards the connection lost handler to the sse transport.
+func (t *upstreamTransport) SetConnectionLostHandler(handler func(error)) {
+	type connectionLostSetter interface {
+		SetConnectionLostHandler(func(error))
+	}
+	if setter, ok := t.Interface.(connectionLostSetter); ok {
+		setter.SetConnectionLostHandler(handler)
+	}
+}